@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reads a duration like "90m" or "24h" from the environment variable, defVal is returned if it's unset or invalid
+func envDuration(name string, defVal time.Duration) time.Duration {
+	if val, err := time.ParseDuration(os.Getenv(name)); err == nil && val > 0 {
+		return val
+	}
+
+	return defVal
+}
+
+// Reads a positive integer from the environment variable, defVal is returned if it's unset or invalid
+func envInt64(name string, defVal int64) int64 {
+	if val, err := strconv.ParseInt(os.Getenv(name), 10, 64); err == nil && val > 0 {
+		return val
+	}
+
+	return defVal
+}
+
+// Reads a comma separated list from the environment variable, the empty items are skipped
+func envList(name string) []string {
+	list := []string{}
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
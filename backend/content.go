@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"threadhelpServer/imageproc"
+	"threadhelpServer/sanitizer"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/google/uuid"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var errInvalidContent = errors.New("invalid post content")
+
+// Result of the post content processing
+type processedContent struct {
+	// Sanitized HTML that is ready to be stored
+	Content string
+	// Names of the already stored images the content refers to
+	ReferencedImages []string
+}
+
+// Max lengths of the HTML content in bytes, the images of the posts are uploaded separately
+const maxPostLength = 256 * 1024
+const maxCommentLength = 64 * 1024
+
+// Sanitizer policies of the HTML content of posts and comments
+var postPolicy = func() *sanitizer.Policy {
+	policy := sanitizer.QuillPolicy()
+	policy.MaxLength = maxPostLength
+	return policy
+}()
+var commentPolicy = func() *sanitizer.Policy {
+	policy := sanitizer.QuillPolicy()
+	policy.MaxLength = maxCommentLength
+	delete(policy.Elements, "img")
+	return policy
+}()
+
+// Checks and sanitizes the HTML content of a post and collects the uploaded images it refers to.
+// Inline data URLs aren't accepted, the images are uploaded through the upload endpoint.
+// Returns errInvalidContent if the content can't be published
+func processPostContent(content string) (processedContent, error) {
+	result := processedContent{
+		ReferencedImages: []string{},
+	}
+
+	doc, err := parseContent(content, postPolicy)
+	if err != nil {
+		return processedContent{}, err
+	}
+
+	if doc.Find("img").Length() == 0 && len(strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(doc.Text(), " ", ""), "\n", ""), "\t", "")) < 5 {
+		return processedContent{}, errInvalidContent
+	}
+
+	doc.Find("img").Each(func(i int, s *goquery.Selection) {
+		srcVal, ex := s.Attr("src")
+		if !ex {
+			s.Remove()
+			return
+		}
+
+		s.SetAttr("alt", "Post image "+fmt.Sprint(i))
+
+		if srcsetVal, ok := s.Attr("srcset"); ok {
+			for _, candidate := range strings.Split(srcsetVal, ",") {
+				fields := strings.Fields(candidate)
+				if name, ok := strings.CutPrefix(fields[0], "/images/"); ok {
+					result.ReferencedImages = append(result.ReferencedImages, name)
+				}
+			}
+		}
+
+		if name, ok := strings.CutPrefix(srcVal, "/images/"); ok {
+			result.ReferencedImages = append(result.ReferencedImages, name)
+		}
+	})
+
+	result.Content, err = doc.Html()
+	if err != nil {
+		return processedContent{}, err
+	}
+
+	return result, nil
+}
+
+// Checks and sanitizes the HTML content of a comment. Comments can't have images attached,
+// so the images are removed from the content by the policy.
+// Returns errInvalidContent if the content can't be published
+func processCommentContent(content string) (string, error) {
+	doc, err := parseContent(content, commentPolicy)
+	if err != nil {
+		return "", err
+	}
+
+	if len(strings.TrimSpace(doc.Text())) == 0 {
+		return "", errInvalidContent
+	}
+
+	return doc.Html()
+}
+
+// Sanitizes the HTML content with the policy and parses it. The content is checked
+// against the limits of the policy first, so oversized or too deep markup isn't parsed
+func parseContent(content string, policy *sanitizer.Policy) (*goquery.Document, error) {
+	if err := policy.Check(content); err != nil {
+		return nil, errInvalidContent
+	}
+
+	ctx := &html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Div,
+		Data:     "div",
+	}
+	nodes, err := html.ParseFragment(strings.NewReader(policy.Sanitize(content)), ctx)
+	if err != nil {
+		return nil, errInvalidContent
+	}
+
+	for _, node := range nodes {
+		ctx.AppendChild(node)
+	}
+
+	return goquery.NewDocumentFromNode(ctx), nil
+}
+
+// Image with its variants encoded by the image pipeline, ready to be stored
+type preparedImage struct {
+	Name          string
+	ThumbnailName string
+	Result        imageproc.Result
+}
+
+// Resizes the image, strips its metadata and generates the thumbnail
+func prepareImage(imgData []byte) (preparedImage, error) {
+	result, err := imageproc.Process(imgData, imageConfig)
+	if err != nil {
+		return preparedImage{}, err
+	}
+
+	uuidVal, err := uuid.NewRandom()
+	for err != nil {
+		uuidVal, err = uuid.NewRandom()
+	}
+
+	name := uuidVal.String() + "." + result.Full.Ext
+	return preparedImage{
+		Name:          name,
+		ThumbnailName: imageproc.ThumbnailName(name),
+		Result:        result,
+	}, nil
+}
+
+// Value of the srcset attribute that lets browsers pick the variant
+func (img preparedImage) Srcset() string {
+	return fmt.Sprintf("/images/%s %dw, /images/%s %dw",
+		img.ThumbnailName, img.Result.Thumbnail.Width,
+		img.Name, img.Result.Full.Width,
+	)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"threadhelpServer/utils"
+	"time"
+)
+
+// How old an image that isn't referenced anywhere has to be to be deleted.
+// Images are written to the store before their post or upload is in the database
+var imageGCGracePeriod = envDuration("IMAGE_GC_GRACE_PERIOD", time.Hour)
+
+// How often the background collector runs
+var imageGCInterval = envDuration("IMAGE_GC_INTERVAL", 6*time.Hour)
+
+// Result of a garbage collection run
+type imageGCReport struct {
+	// Stored images that aren't referenced by any post, revision or pending upload
+	Orphans []string
+	// Orphans that were deleted, the younger ones are kept until the grace period ends
+	Deleted []string
+	// References of posts to images that aren't in the store
+	Missing []utils.ImageReference
+}
+
+// Compares the images in the store with the images referenced in the database.
+// The orphans older than gracePeriod are deleted unless dryRun is set
+func collectImageGarbage(ctx context.Context, gracePeriod time.Duration, dryRun bool) (imageGCReport, error) {
+	report := imageGCReport{
+		Orphans: []string{},
+		Deleted: []string{},
+		Missing: []utils.ImageReference{},
+	}
+
+	// The store is listed first, so an image can't be taken for an orphan
+	// because its post was added between the two reads
+	images, err := imageStore.List()
+	if err != nil {
+		return imageGCReport{}, err
+	}
+
+	references, err := store.GetImageReferences(ctx)
+	if err != nil {
+		return imageGCReport{}, err
+	}
+
+	referenced := map[string]bool{}
+	for _, ref := range references {
+		referenced[ref.Name] = true
+	}
+
+	stored := map[string]bool{}
+	for _, img := range images {
+		stored[img.Name] = true
+
+		if referenced[img.Name] {
+			continue
+		}
+
+		report.Orphans = append(report.Orphans, img.Name)
+
+		if dryRun || time.Since(img.ModTime) < gracePeriod {
+			continue
+		}
+
+		if err := imageStore.Delete(img.Name); err != nil {
+			logger.Println(err)
+			continue
+		}
+
+		report.Deleted = append(report.Deleted, img.Name)
+	}
+
+	for _, ref := range references {
+		if ref.PostID != "" && !stored[ref.Name] {
+			report.Missing = append(report.Missing, ref)
+		}
+	}
+
+	return report, nil
+}
+
+// Periodically deletes the orphaned images and logs the missing ones
+func runImageCollector() {
+	for {
+		report, err := collectImageGarbage(context.Background(), imageGCGracePeriod, false)
+		if err != nil {
+			logger.Println(err)
+		} else {
+			if len(report.Deleted) > 0 {
+				logger.Printf("Deleted %d orphaned images\n", len(report.Deleted))
+			}
+
+			for _, ref := range report.Missing {
+				logger.Printf("Post %s refers to the missing image %s\n", ref.PostID, ref.Name)
+			}
+		}
+
+		time.Sleep(max(imageGCInterval, time.Minute))
+	}
+}
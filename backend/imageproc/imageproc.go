@@ -0,0 +1,178 @@
+// Package imageproc prepares the images attached to posts: it limits their dimensions,
+// strips the metadata by re-encoding them and generates thumbnails.
+package imageproc
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kolesa-team/go-webp/encoder"
+	"github.com/kolesa-team/go-webp/webp"
+	xdraw "golang.org/x/image/draw"
+)
+
+var ErrUnsupportedImage = errors.New("unsupported image format")
+var ErrImageTooLarge = errors.New("the image has too many pixels")
+
+type Config struct {
+	// Max dimensions of the full-size variant, larger images are scaled down
+	MaxWidth  int
+	MaxHeight int
+	// Max width×height of the decoded image, checked on the header before decoding,
+	// so a small file that declares huge dimensions isn't allocated. No limit if 0
+	MaxPixels int64
+	// Width of the thumbnail variant
+	ThumbnailWidth int
+	// Quality of the lossy encoders, 1-100
+	Quality int
+	// Encodes the variants into webp instead of jpeg or png
+	WebP bool
+}
+
+// Encoded image
+type Variant struct {
+	Data   []byte
+	Ext    string
+	Width  int
+	Height int
+}
+
+type Result struct {
+	Full      Variant
+	Thumbnail Variant
+}
+
+// Reads the configuration from the IMAGE_* environment variables and WEBP_IMAGE_ENCODING
+func ConfigFromEnv() Config {
+	return Config{
+		MaxWidth:       envInt("IMAGE_MAX_WIDTH", 2048),
+		MaxHeight:      envInt("IMAGE_MAX_HEIGHT", 2048),
+		MaxPixels:      int64(envInt("IMAGE_MAX_PIXELS", 40_000_000)),
+		ThumbnailWidth: envInt("IMAGE_THUMBNAIL_WIDTH", 480),
+		Quality:        min(envInt("IMAGE_QUALITY", 80), 100),
+		WebP:           os.Getenv("WEBP_IMAGE_ENCODING") == "true",
+	}
+}
+
+func envInt(name string, defVal int) int {
+	if val, err := strconv.Atoi(os.Getenv(name)); err == nil && val > 0 {
+		return val
+	}
+
+	return defVal
+}
+
+// Returns the name of the thumbnail variant for the name of the full-size variant
+func ThumbnailName(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i != -1 {
+		return name[:i] + "-thumb" + name[i:]
+	}
+
+	return name + "-thumb"
+}
+
+// Decodes a jpeg, png or webp image and encodes its full-size and thumbnail variants.
+// Returns ErrImageTooLarge if the image has more than config.MaxPixels pixels
+func Process(data []byte, config Config) (Result, error) {
+	header, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Result{}, ErrUnsupportedImage
+	}
+
+	if config.MaxPixels > 0 && int64(header.Width)*int64(header.Height) > config.MaxPixels {
+		return Result{}, ErrImageTooLarge
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Result{}, ErrUnsupportedImage
+	}
+
+	// The EXIF metadata is not copied, so the orientation has to be applied to the pixels
+	if format == "jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+
+	full := fit(img, config.MaxWidth, config.MaxHeight)
+	thumbnail := fit(full, config.ThumbnailWidth, config.MaxHeight)
+
+	ext := "jpg"
+	if config.WebP {
+		ext = "webp"
+	} else if hasTransparency(img) {
+		ext = "png"
+	}
+
+	result := Result{}
+	if result.Full, err = encode(full, ext, config.Quality); err != nil {
+		return Result{}, err
+	}
+	if result.Thumbnail, err = encode(thumbnail, ext, config.Quality); err != nil {
+		return Result{}, err
+	}
+
+	return result, nil
+}
+
+// Scales the image down to fit the dimensions, keeping the aspect ratio
+func fit(img image.Image, maxWidth int, maxHeight int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if width <= maxWidth && height <= maxHeight {
+		return img
+	}
+
+	scale := min(float64(maxWidth)/float64(width), float64(maxHeight)/float64(height))
+	newWidth := max(int(float64(width)*scale), 1)
+	newHeight := max(int(float64(height)*scale), 1)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, newWidth, newHeight))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+
+	return dst
+}
+
+func encode(img image.Image, ext string, quality int) (Variant, error) {
+	buff := bytes.NewBuffer([]byte{})
+	var err error
+
+	switch ext {
+	case "webp":
+		var options *encoder.Options
+		options, err = encoder.NewLossyEncoderOptions(encoder.PresetDefault, float32(quality))
+		if err == nil {
+			err = webp.Encode(buff, img, options)
+		}
+	case "png":
+		err = png.Encode(buff, img)
+	default:
+		err = jpeg.Encode(buff, img, &jpeg.Options{Quality: quality})
+	}
+
+	if err != nil {
+		return Variant{}, err
+	}
+
+	return Variant{
+		Data:   buff.Bytes(),
+		Ext:    ext,
+		Width:  img.Bounds().Dx(),
+		Height: img.Bounds().Dy(),
+	}, nil
+}
+
+func hasTransparency(img image.Image) bool {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return !opaque.Opaque()
+	}
+
+	return false
+}
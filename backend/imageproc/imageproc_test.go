@@ -0,0 +1,107 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// Encodes a jpeg image with an EXIF segment that holds the orientation tag
+func jpegWithOrientation(t *testing.T, width int, height int, orientation byte) []byte {
+	buff := bytes.NewBuffer([]byte{})
+	if err := jpeg.Encode(buff, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tiff := []byte{
+		'M', 'M', 0, 42, 0, 0, 0, 8, // Header, the first IFD follows it
+		0, 1, // One entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0, // Orientation, SHORT
+		0, 0, 0, 0, // No next IFD
+	}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(segment) + 2
+
+	data := buff.Bytes()
+	result := []byte{0xFF, 0xD8, 0xFF, 0xE1, byte(length >> 8), byte(length)}
+	result = append(result, segment...)
+	return append(result, data[2:]...)
+}
+
+func TestProcess(t *testing.T) {
+	config := Config{MaxWidth: 400, MaxHeight: 400, ThumbnailWidth: 100, Quality: 80}
+
+	result, err := Process(jpegWithOrientation(t, 800, 200, 6), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotated by the orientation into 200x800 and scaled down to fit 400x400
+	if result.Full.Width != 100 || result.Full.Height != 400 {
+		t.Errorf("full variant is %dx%d, expected 100x400", result.Full.Width, result.Full.Height)
+	}
+	if result.Thumbnail.Width > 100 {
+		t.Errorf("thumbnail is %d wide, expected at most 100", result.Thumbnail.Width)
+	}
+
+	for _, variant := range []Variant{result.Full, result.Thumbnail} {
+		if variant.Ext != "jpg" {
+			t.Errorf("variant is encoded as %q, expected jpg", variant.Ext)
+		}
+		if bytes.Contains(variant.Data, []byte("Exif")) {
+			t.Error("variant keeps the EXIF metadata")
+		}
+	}
+
+	if _, err := Process([]byte("not an image"), config); err != ErrUnsupportedImage {
+		t.Errorf("Process of invalid data returned %v, expected ErrUnsupportedImage", err)
+	}
+}
+
+// Encodes a small png image and rewrites the dimensions in its header
+func pngWithDimensions(t *testing.T, width uint32, height uint32) []byte {
+	buff := bytes.NewBuffer([]byte{})
+	if err := png.Encode(buff, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	// The IHDR chunk follows the 8 bytes of the signature: length, type, data and CRC
+	data := buff.Bytes()
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	return data
+}
+
+func TestProcessTooLarge(t *testing.T) {
+	config := Config{MaxWidth: 400, MaxHeight: 400, ThumbnailWidth: 100, Quality: 80, MaxPixels: 40_000_000}
+
+	// A few bytes that would decode into 100000x100000 pixels
+	bomb := pngWithDimensions(t, 100_000, 100_000)
+	if _, err := Process(bomb, config); err != ErrImageTooLarge {
+		t.Errorf("Process of a %d bytes image with huge dimensions returned %v, expected ErrImageTooLarge", len(bomb), err)
+	}
+
+	if _, err := Process(jpegWithOrientation(t, 800, 200, 1), config); err != nil {
+		t.Errorf("Process of an image under the limit returned %v", err)
+	}
+}
+
+func TestThumbnailName(t *testing.T) {
+	cases := map[string]string{
+		"name.jpg":  "name-thumb.jpg",
+		"name.webp": "name-thumb.webp",
+		"name":      "name-thumb",
+	}
+
+	for name, expected := range cases {
+		if thumbnail := ThumbnailName(name); thumbnail != expected {
+			t.Errorf("ThumbnailName(%q) = %q, expected %q", name, thumbnail, expected)
+		}
+	}
+}
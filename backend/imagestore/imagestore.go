@@ -0,0 +1,66 @@
+// Package imagestore keeps the images attached to posts. The images can be stored
+// in a local directory or in an S3-compatible bucket, which is selected with IMAGE_STORE.
+package imagestore
+
+import (
+	"errors"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("image not found")
+var ErrInvalidName = errors.New("invalid image name")
+
+type ImageStore interface {
+	// Writes the image, replacing the image with the same name
+	Put(name string, data []byte) error
+	// Reads the image, returns ErrNotFound if there is none
+	Get(name string) ([]byte, error)
+	// Deletes the image, deleting an image that doesn't exist isn't an error
+	Delete(name string) error
+	// URL the image can be downloaded from by browsers
+	URL(name string) string
+	// Lists all stored images
+	List() ([]ImageInfo, error)
+}
+
+type ImageInfo struct {
+	Name string
+	// When the image was written
+	ModTime time.Time
+}
+
+// Creates the store selected by IMAGE_STORE: "local" (default) or "s3".
+// The local store uses the IMAGES_DIR directory, images by default.
+// The S3 store is configured with the S3_* environment variables
+func FromEnv() (ImageStore, error) {
+	switch os.Getenv("IMAGE_STORE") {
+	case "", "local":
+		dir := os.Getenv("IMAGES_DIR")
+		if dir == "" {
+			dir = "images"
+		}
+
+		return NewLocalStore(dir)
+	case "s3":
+		return NewS3Store(S3ConfigFromEnv())
+	default:
+		return nil, errors.New("unknown IMAGE_STORE " + os.Getenv("IMAGE_STORE"))
+	}
+}
+
+// Image names are single path elements, so they can't point outside of the store
+func validName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, "/\\") && path.Clean(name) == name
+}
+
+func contentType(name string) string {
+	if mimeType := mime.TypeByExtension(path.Ext(name)); mimeType != "" {
+		return mimeType
+	}
+
+	return "application/octet-stream"
+}
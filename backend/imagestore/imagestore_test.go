@@ -0,0 +1,90 @@
+package imagestore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, store)
+}
+
+// Runs against a MinIO server, e.g. docker run -p 9000:9000 minio/minio server /data,
+// with S3_TEST_ENDPOINT=localhost:9000 and the default minioadmin credentials
+func TestS3Store(t *testing.T) {
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT isn't set")
+	}
+
+	config := S3Config{
+		Endpoint:  endpoint,
+		AccessKey: "minioadmin",
+		SecretKey: "minioadmin",
+		Bucket:    "threadhelp-test",
+		UseSSL:    false,
+	}
+	if accessKey := os.Getenv("S3_TEST_ACCESS_KEY"); accessKey != "" {
+		config.AccessKey = accessKey
+		config.SecretKey = os.Getenv("S3_TEST_SECRET_KEY")
+	}
+
+	store, err := NewS3Store(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, store)
+}
+
+func testStore(t *testing.T, store ImageStore) {
+	data := []byte("image data")
+
+	if err := store.Put("test.jpg", data); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := store.Get("test.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Errorf("Get returned %q, expected %q", stored, data)
+	}
+
+	images, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(images, func(img ImageInfo) bool { return img.Name == "test.jpg" }) {
+		t.Errorf("List returned %v without the stored image", images)
+	}
+
+	if store.URL("test.jpg") == "" {
+		t.Error("URL returned an empty string")
+	}
+
+	if err := store.Delete("test.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("test.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a deleted image returned %v, expected ErrNotFound", err)
+	}
+	if err := store.Delete("test.jpg"); err != nil {
+		t.Errorf("Delete of a deleted image returned %v", err)
+	}
+
+	for _, name := range []string{"", "../test.jpg", "dir/test.jpg", ".hidden"} {
+		if err := store.Put(name, data); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Put(%q) returned %v, expected ErrInvalidName", name, err)
+		}
+	}
+}
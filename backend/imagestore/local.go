@@ -0,0 +1,109 @@
+package imagestore
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Stores the images in a directory, which is served by the web server under /images/
+type LocalStore struct {
+	Dir string
+}
+
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &LocalStore{Dir: dir}, nil
+}
+
+// The image is written into a temporary file first, so it's never served half-written
+func (s *LocalStore) Put(name string, data []byte) error {
+	if !validName(name) {
+		return ErrInvalidName
+	}
+
+	tmpFile, err := os.CreateTemp(s.Dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if err := tmpFile.Chmod(0644); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), filepath.Join(s.Dir, name))
+}
+
+func (s *LocalStore) Get(name string) ([]byte, error) {
+	if !validName(name) {
+		return nil, ErrInvalidName
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.Dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	return data, err
+}
+
+func (s *LocalStore) Delete(name string) error {
+	if !validName(name) {
+		return ErrInvalidName
+	}
+
+	err := os.Remove(filepath.Join(s.Dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *LocalStore) URL(name string) string {
+	return "/images/" + name
+}
+
+// Temporary files of the writes in progress aren't listed
+func (s *LocalStore) List() ([]ImageInfo, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return []ImageInfo{}, err
+	}
+
+	images := []ImageInfo{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !validName(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return []ImageInfo{}, err
+		}
+
+		images = append(images, ImageInfo{
+			Name:    entry.Name(),
+			ModTime: info.ModTime(),
+		})
+	}
+
+	return images, nil
+}
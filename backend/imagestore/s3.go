@@ -0,0 +1,160 @@
+package imagestore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+var S3CTX = context.Background()
+
+// How long the presigned URLs of images are valid, when the bucket has no public URL
+const presignExpiration = 24 * time.Hour
+
+type S3Config struct {
+	// Host and port of the S3 API, e.g. s3.amazonaws.com or localhost:9000
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	UseSSL    bool
+	// Base URL the objects of the bucket are publicly available under, e.g. a CDN.
+	// Presigned URLs are used if it's empty
+	PublicURL string
+}
+
+// Stores the images in a bucket of an S3-compatible storage, e.g. AWS S3 or MinIO
+type S3Store struct {
+	client    *minio.Client
+	bucket    string
+	publicURL string
+}
+
+// Reads the configuration from the S3_ENDPOINT, S3_ACCESS_KEY, S3_SECRET_KEY,
+// S3_BUCKET, S3_REGION, S3_USE_SSL and S3_PUBLIC_URL environment variables
+func S3ConfigFromEnv() S3Config {
+	return S3Config{
+		Endpoint:  os.Getenv("S3_ENDPOINT"),
+		AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_SECRET_KEY"),
+		Bucket:    os.Getenv("S3_BUCKET"),
+		Region:    os.Getenv("S3_REGION"),
+		UseSSL:    os.Getenv("S3_USE_SSL") != "false",
+		PublicURL: os.Getenv("S3_PUBLIC_URL"),
+	}
+}
+
+// Connects to the storage and creates the bucket if it doesn't exist
+func NewS3Store(config S3Config) (*S3Store, error) {
+	region := config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.UseSSL,
+		Region: region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(S3CTX, config.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		if err := client.MakeBucket(S3CTX, config.Bucket, minio.MakeBucketOptions{Region: region}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &S3Store{
+		client:    client,
+		bucket:    config.Bucket,
+		publicURL: strings.TrimSuffix(config.PublicURL, "/"),
+	}, nil
+}
+
+func (s *S3Store) Put(name string, data []byte) error {
+	if !validName(name) {
+		return ErrInvalidName
+	}
+
+	_, err := s.client.PutObject(S3CTX, s.bucket, name, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType:  contentType(name),
+		CacheControl: "public, max-age=432000, immutable",
+	})
+	return err
+}
+
+func (s *S3Store) Get(name string) ([]byte, error) {
+	if !validName(name) {
+		return nil, ErrInvalidName
+	}
+
+	object, err := s.client.GetObject(S3CTX, s.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *S3Store) Delete(name string) error {
+	if !validName(name) {
+		return ErrInvalidName
+	}
+
+	return s.client.RemoveObject(S3CTX, s.bucket, name, minio.RemoveObjectOptions{})
+}
+
+// Returns the public URL of the image, or a presigned one if the bucket isn't public.
+// An empty string is returned if the URL can't be presigned
+func (s *S3Store) URL(name string) string {
+	if s.publicURL != "" {
+		return s.publicURL + "/" + url.PathEscape(name)
+	}
+
+	presigned, err := s.client.PresignedGetObject(S3CTX, s.bucket, name, presignExpiration, url.Values{})
+	if err != nil {
+		return ""
+	}
+
+	return presigned.String()
+}
+
+func (s *S3Store) List() ([]ImageInfo, error) {
+	images := []ImageInfo{}
+	for object := range s.client.ListObjects(S3CTX, s.bucket, minio.ListObjectsOptions{}) {
+		if object.Err != nil {
+			return []ImageInfo{}, object.Err
+		}
+
+		images = append(images, ImageInfo{
+			Name:    object.Key,
+			ModTime: object.LastModified,
+		})
+	}
+
+	return images, nil
+}
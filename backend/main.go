@@ -1,20 +1,137 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"threadhelpServer/imagestore"
 	"threadhelpServer/utils"
+	"time"
 )
 
 var logger = log.New(os.Stdout, "WEB | ", log.Ldate|log.Ltime|log.Lshortfile|log.Lmsgprefix)
 var cacheStorage = utils.NewCacheStorage()
 
 func main() {
-	if err := utils.InitDB(&cacheStorage); err != nil {
+	database, err := utils.OpenDatabase(&cacheStorage)
+	if err != nil {
 		logger.Fatalln(err)
 	}
 
-	defer utils.CloseDB()
+	defer database.Close()
+
+	store = database
+
+	// The migrations are run by the command itself
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(database, os.Args[2:]); err != nil {
+			logger.Fatalln(err)
+		}
+		return
+	}
+
+	applied, err := database.MigrateUp()
+	for _, migration := range applied {
+		logger.Println("applied migration", migration)
+	}
+	if err != nil {
+		logger.Fatalln(err)
+	}
+
+	imageStore, err = imagestore.FromEnv()
+	if err != nil {
+		logger.Fatalln(err)
+	}
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "gc-images":
+			if err := runImageGCCommand(os.Args[2:]); err != nil {
+				logger.Fatalln(err)
+			}
+			return
+		default:
+			logger.Fatalln("unknown command " + os.Args[1])
+		}
+	}
 
 	logger.Fatalln(StartWebServer())
 }
+
+// gc-images [-dry-run] [-grace duration]
+// Deletes the orphaned images and prints the posts that refer to missing images
+func runImageGCCommand(args []string) error {
+	flags := flag.NewFlagSet("gc-images", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report the orphaned images without deleting them")
+	grace := flags.Duration("grace", imageGCGracePeriod, "how old an orphaned image has to be to be deleted")
+	flags.Parse(args)
+
+	report, err := collectImageGarbage(context.Background(), *grace, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range report.Orphans {
+		fmt.Println("orphan", name)
+	}
+	for _, ref := range report.Missing {
+		fmt.Println("missing", ref.Name, "post", ref.PostID)
+	}
+
+	fmt.Printf("%d orphaned images, %d deleted, %d missing\n", len(report.Orphans), len(report.Deleted), len(report.Missing))
+	return nil
+}
+
+// migrate status | up | down [-steps n]
+func runMigrateCommand(database utils.Database, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate status | up | down [-steps n]")
+	}
+
+	switch args[0] {
+	case "status":
+		states, err := database.MigrationStatus()
+		if err != nil {
+			return err
+		}
+
+		for _, state := range states {
+			if state.AppliedAt != nil {
+				fmt.Println("applied", state.Migration, time.Time(*state.AppliedAt).Format(time.DateTime))
+			} else {
+				fmt.Println("pending", state.Migration)
+			}
+		}
+	case "up":
+		applied, err := database.MigrateUp()
+		for _, migration := range applied {
+			fmt.Println("applied", migration)
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%d migrations applied\n", len(applied))
+	case "down":
+		flags := flag.NewFlagSet("migrate down", flag.ExitOnError)
+		steps := flags.Int("steps", 1, "how many of the last applied migrations to revert")
+		flags.Parse(args[1:])
+
+		reverted, err := database.MigrateDown(*steps)
+		for _, migration := range reverted {
+			fmt.Println("reverted", migration)
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%d migrations reverted\n", len(reverted))
+	default:
+		return errors.New("unknown migrate command " + args[0])
+	}
+
+	return nil
+}
@@ -20,3 +20,39 @@ func TestJsonTime(t *testing.T) {
 		t.Fatalf("Expected %d, got %s", now.UnixMilli(), string(b))
 	}
 }
+
+func TestValidReaction(t *testing.T) {
+	cases := map[string]bool{
+		"👍":         true,
+		"🇺🇦":        true,
+		"👨‍👩‍👧":     true,
+		"1️⃣":       true,
+		"":          false,
+		"a":         false,
+		"👍 ":        false,
+		"👍👍👍👍👍👍👍👍👍": false,
+	}
+
+	for emoji, expected := range cases {
+		if validReaction(emoji) != expected {
+			t.Errorf("validReaction(%q) = %t, expected %t", emoji, !expected, expected)
+		}
+	}
+}
+
+func TestPostWithMetaJSON(t *testing.T) {
+	post := utils.PostWithMeta{
+		Post:     utils.Post{ID: "post", Content: "hidden", PubDate: utils.JSONTime(time.UnixMilli(1))},
+		PostMeta: utils.PostMeta{Content: "content", Likes: 2, Liked: true},
+	}
+
+	b, err := json.Marshal(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"postId":"post","pubTime":1,"content":"content","likes":2,"liked":true}`
+	if string(b) != expected {
+		t.Errorf("Expected %s, got %s", expected, string(b))
+	}
+}
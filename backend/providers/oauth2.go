@@ -15,10 +15,12 @@ import (
 type OAuthProvider struct {
 	firebaseAuth *auth.Client
 	cacheStorage *utils.CacheStorage
-	AllowDomain  string
+	// Checks whether the user is blacklisted
+	store       utils.Store
+	AllowDomain string
 }
 
-func NewOAuthProvider(allowDomain string, cacheStorage *utils.CacheStorage) (OAuthProvider, error) {
+func NewOAuthProvider(allowDomain string, cacheStorage *utils.CacheStorage, store utils.Store) (OAuthProvider, error) {
 	opt := option.WithCredentialsFile("./firebaseSecretKey.json")
 	app, err := firebase.NewApp(context.Background(), nil, opt)
 	if err != nil {
@@ -34,6 +36,7 @@ func NewOAuthProvider(allowDomain string, cacheStorage *utils.CacheStorage) (OAu
 		firebaseAuth: firebaseAuth,
 		AllowDomain:  allowDomain,
 		cacheStorage: cacheStorage,
+		store:        store,
 	}, nil
 }
 
@@ -99,7 +102,7 @@ func (a OAuthProvider) CheckLogin(c *fiber.Ctx) bool {
 	(*c).Locals("uid", uid)
 	(*c).Locals("displayName", displayName)
 
-	if utils.IsInBlacklist(email) {
+	if a.store.IsInBlacklist((*c).Context(), email) {
 		return false
 	}
 	return true
@@ -0,0 +1,37 @@
+package sanitizer
+
+// Policy for the HTML produced by the Quill editor of the frontend
+func QuillPolicy() *Policy {
+	return &Policy{
+		Elements: map[string][]string{
+			"p":      {"class"},
+			"h1":     {"class"},
+			"h2":     {"class"},
+			"br":     {},
+			"strong": {},
+			"em":     {},
+			"u":      {},
+			"span":   {"class"},
+			"a":      {"href"},
+			"img":    {"src", "srcset", "alt"},
+			"pre":    {"class"},
+			"ul":     {"class"},
+			"ol":     {"class"},
+			"li":     {"class"},
+		},
+		DropElements: []string{
+			"script", "style", "template", "iframe", "frame", "frameset", "object", "embed",
+			"noscript", "noembed", "noframes", "textarea", "select", "title", "head", "svg", "math",
+		},
+		URLAttributes:     []string{"href", "src"},
+		SrcsetAttributes:  []string{"srcset"},
+		URLSchemes:        []string{"http", "https", "mailto"},
+		AllowRelativeURLs: true,
+		Classes: []string{
+			"ql-align-*", "ql-indent-*", "ql-direction-rtl", "ql-size-*", "ql-font-*", "ql-syntax",
+		},
+		LinkRel: "noopener noreferrer nofollow",
+		// The editor nests a few elements at most, e.g. a link in a formatted list item
+		MaxDepth: 64,
+	}
+}
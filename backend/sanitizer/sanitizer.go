@@ -0,0 +1,380 @@
+// Package sanitizer cleans user provided HTML according to a declarative policy.
+// The input is parsed with golang.org/x/net/html and the resulting node tree is walked,
+// so the output is always well-formed markup that contains only the allowed elements and attributes.
+package sanitizer
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Describes what is kept in the sanitized HTML
+type Policy struct {
+	// Allowed elements with the attributes allowed on them.
+	// Other elements are replaced with their children
+	Elements map[string][]string
+	// Elements that are removed together with their children
+	DropElements []string
+	// Attributes whose value is a URL
+	URLAttributes []string
+	// Attributes whose value is a list of image candidates, e.g. srcset
+	SrcsetAttributes []string
+	// Allowed URL schemes, lowercase
+	URLSchemes []string
+	// Allows URLs without a scheme, e.g. /images/name.webp
+	AllowRelativeURLs bool
+	// Allowed types of base64 data URLs in the src attribute of img elements, e.g. image/png
+	DataImageTypes []string
+	// Allowed values of the class attribute. A value ending with * allows any class
+	// that starts with the rest of the value and continues with letters, digits or dashes
+	Classes []string
+	// Value of the rel attribute added to every link, the attribute isn't added if empty
+	LinkRel string
+	// Max length of the markup in bytes, no limit if 0
+	MaxLength int
+	// Max number of the elements open at once, no limit if 0. It's counted on the tokens
+	// before parsing, so the elements that are closed implicitly count until their parent is closed
+	MaxDepth int
+}
+
+var ErrTooLong = errors.New("the markup is too long")
+var ErrTooDeep = errors.New("the markup is nested too deep")
+
+// How many times the output is parsed again at most, until it doesn't change
+const maxPasses = 4
+
+var classSuffixRegexp = regexp.MustCompile("^[a-z0-9-]+$")
+var base64Regexp = regexp.MustCompile("^[A-Za-z0-9+/]*={0,2}$")
+var srcsetDescriptorRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[wx]$`)
+
+// Elements that have no end tag
+var voidElements = []atom.Atom{
+	atom.Area, atom.Base, atom.Br, atom.Col, atom.Embed, atom.Hr, atom.Img, atom.Input,
+	atom.Keygen, atom.Link, atom.Meta, atom.Param, atom.Source, atom.Track, atom.Wbr,
+}
+
+// Checks the markup against MaxLength and MaxDepth. Only the tokenizer runs over it,
+// so the check is linear and can be done before the markup reaches the parser
+func (p *Policy) Check(content string) error {
+	if p.MaxLength > 0 && len(content) > p.MaxLength {
+		return ErrTooLong
+	}
+
+	if p.MaxDepth <= 0 {
+		return nil
+	}
+
+	depth := 0
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return err
+			}
+			return nil
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			if slices.Contains(voidElements, atom.Lookup(name)) {
+				continue
+			}
+
+			depth++
+			if depth > p.MaxDepth {
+				return ErrTooDeep
+			}
+		case html.EndTagToken:
+			depth = max(depth-1, 0)
+		}
+	}
+}
+
+// Sanitizes an HTML fragment. The result is stable: sanitizing it again returns the same string.
+// The fragments that fail Check are sanitized to an empty string
+func (p *Policy) Sanitize(content string) string {
+	if p.Check(content) != nil {
+		return ""
+	}
+
+	output := p.sanitizeStable(content)
+	// The parser can add elements, e.g. when it reopens the formatting elements,
+	// so the output is checked too for the result to stay stable
+	if p.Check(output) != nil {
+		return ""
+	}
+
+	return output
+}
+
+func (p *Policy) sanitizeStable(content string) string {
+	output := p.sanitizePass(content)
+
+	for i := 0; i < maxPasses; i++ {
+		next := p.sanitizePass(output)
+		if next == output {
+			return output
+		}
+
+		output = next
+	}
+
+	// The markup doesn't settle, which can happen only with very broken input,
+	// so keeping just the text is the safest option
+	text := renderNodes([]*html.Node{{
+		Type: html.TextNode,
+		Data: strings.ReplaceAll(nodesText(parseFragment(output)), "\x00", ""),
+	}})
+	if p.sanitizePass(text) == text {
+		return text
+	}
+
+	return ""
+}
+
+// Sanitizes the nodes in place and returns the nodes that remain at the top level
+func (p *Policy) SanitizeNodes(nodes []*html.Node) []*html.Node {
+	ctx := &html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Div,
+		Data:     "div",
+	}
+
+	for _, node := range nodes {
+		if node.Parent != nil {
+			node.Parent.RemoveChild(node)
+		}
+		ctx.AppendChild(node)
+	}
+
+	p.sanitizeChildren(ctx)
+
+	result := []*html.Node{}
+	for child := ctx.FirstChild; child != nil; child = ctx.FirstChild {
+		ctx.RemoveChild(child)
+		result = append(result, child)
+	}
+
+	return result
+}
+
+// Checks whether the value of an URL attribute is allowed on the element
+func (p *Policy) AllowedURL(element string, value string) bool {
+	value = strings.TrimSpace(value)
+	if strings.IndexFunc(value, func(r rune) bool {
+		return r < 0x20 || r == 0x7f || r == ' '
+	}) != -1 {
+		return false
+	}
+
+	if element == "img" && len(p.DataImageTypes) > 0 {
+		if data, ok := strings.CutPrefix(value, "data:"); ok {
+			mimeType, encoded, found := strings.Cut(data, ";base64,")
+			return found && slices.Contains(p.DataImageTypes, mimeType) && base64Regexp.MatchString(encoded)
+		}
+	}
+
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+
+	if parsed.Scheme == "" {
+		// Colons before the first slash would turn the relative URL into a scheme in browsers
+		if beforeSlash, _, _ := strings.Cut(value, "/"); strings.Contains(beforeSlash, ":") {
+			return false
+		}
+
+		return p.AllowRelativeURLs
+	}
+
+	return slices.Contains(p.URLSchemes, strings.ToLower(parsed.Scheme))
+}
+
+// Checks whether every candidate of a srcset value has an allowed URL and a valid descriptor.
+// Data URLs aren't allowed there, as their commas can't be told apart from the separators
+func (p *Policy) AllowedSrcset(value string) bool {
+	_, ok := p.normalizeSrcset(value)
+	return ok
+}
+
+// Returns the srcset value with its candidates separated by ", "
+func (p *Policy) normalizeSrcset(value string) (string, bool) {
+	candidates := []string{}
+
+	for _, candidate := range strings.Split(value, ",") {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 || len(fields) > 2 {
+			return "", false
+		}
+
+		if strings.HasPrefix(strings.ToLower(fields[0]), "data:") || !p.AllowedURL("", fields[0]) {
+			return "", false
+		}
+
+		if len(fields) == 2 && !srcsetDescriptorRegexp.MatchString(fields[1]) {
+			return "", false
+		}
+
+		candidates = append(candidates, strings.Join(fields, " "))
+	}
+
+	return strings.Join(candidates, ", "), true
+}
+
+// Checks whether the class name is allowed by the policy
+func (p *Policy) AllowedClass(class string) bool {
+	for _, allowed := range p.Classes {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok {
+			if suffix, ok := strings.CutPrefix(class, prefix); ok && classSuffixRegexp.MatchString(suffix) {
+				return true
+			}
+		} else if class == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *Policy) sanitizePass(content string) string {
+	return renderNodes(p.SanitizeNodes(parseFragment(content)))
+}
+
+func (p *Policy) sanitizeChildren(parent *html.Node) {
+	for child := parent.FirstChild; child != nil; {
+		next := child.NextSibling
+
+		switch child.Type {
+		case html.TextNode:
+		case html.ElementNode:
+			_, allowed := p.Elements[child.Data]
+
+			if child.Namespace != "" || slices.Contains(p.DropElements, child.Data) {
+				parent.RemoveChild(child)
+			} else if !allowed {
+				// Children are moved to the parent and sanitized when the loop reaches them
+				first := child.FirstChild
+				for grandChild := child.FirstChild; grandChild != nil; grandChild = child.FirstChild {
+					child.RemoveChild(grandChild)
+					parent.InsertBefore(grandChild, child)
+				}
+				parent.RemoveChild(child)
+
+				if first != nil {
+					next = first
+				}
+			} else {
+				p.sanitizeAttributes(child)
+				p.sanitizeChildren(child)
+			}
+		default:
+			parent.RemoveChild(child)
+		}
+
+		child = next
+	}
+}
+
+func (p *Policy) sanitizeAttributes(node *html.Node) {
+	allowedAttributes := p.Elements[node.Data]
+	attributes := []html.Attribute{}
+
+	for _, attr := range node.Attr {
+		if attr.Namespace != "" || !slices.Contains(allowedAttributes, attr.Key) {
+			continue
+		}
+
+		if slices.Contains(p.URLAttributes, attr.Key) {
+			if !p.AllowedURL(node.Data, attr.Val) {
+				continue
+			}
+			attr.Val = strings.TrimSpace(attr.Val)
+		}
+
+		if slices.Contains(p.SrcsetAttributes, attr.Key) {
+			normalized, ok := p.normalizeSrcset(attr.Val)
+			if !ok {
+				continue
+			}
+			attr.Val = normalized
+		}
+
+		if attr.Key == "class" {
+			classes := []string{}
+			for _, class := range strings.Fields(attr.Val) {
+				if p.AllowedClass(class) && !slices.Contains(classes, class) {
+					classes = append(classes, class)
+				}
+			}
+
+			if len(classes) == 0 {
+				continue
+			}
+			attr.Val = strings.Join(classes, " ")
+		}
+
+		if node.Data == "a" && attr.Key == "rel" && p.LinkRel != "" {
+			continue
+		}
+
+		attributes = append(attributes, attr)
+	}
+
+	if node.Data == "a" && p.LinkRel != "" {
+		attributes = append(attributes, html.Attribute{Key: "rel", Val: p.LinkRel})
+	}
+
+	node.Attr = attributes
+}
+
+func parseFragment(content string) []*html.Node {
+	nodes, err := html.ParseFragment(strings.NewReader(content), &html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Div,
+		Data:     "div",
+	})
+	if err != nil {
+		return []*html.Node{}
+	}
+
+	return nodes
+}
+
+func renderNodes(nodes []*html.Node) string {
+	builder := strings.Builder{}
+	for _, node := range nodes {
+		if err := html.Render(&builder, node); err != nil {
+			return ""
+		}
+	}
+
+	return builder.String()
+}
+
+func nodesText(nodes []*html.Node) string {
+	builder := strings.Builder{}
+
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.TextNode {
+			builder.WriteString(node.Data)
+		}
+
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+
+	for _, node := range nodes {
+		walk(node)
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,160 @@
+package sanitizer
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+var seeds = []string{
+	`<p>Hello <strong>world</strong></p>`,
+	`<p class="ql-align-center ql-evil">centered</p><ol><li class="ql-indent-1">item</li></ol>`,
+	`<a href="javascript:alert(1)">link</a>`,
+	`<a href=" JaVaScRiPt:alert(1)">link</a>`,
+	`<a href="java&#x09;script:alert(1)">link</a>`,
+	`<a href="https://example.com" onclick="alert(1)" rel="opener">link</a>`,
+	`<img src="x" onerror="alert(1)">`,
+	`<img src="data:image/png;base64,iVBORw0KGgo=">`,
+	`<img src="data:text/html;base64,PHNjcmlwdD4=">`,
+	`<script>alert(1)</script><p>after</p>`,
+	`<svg><script>alert(1)</script></svg>`,
+	`<math><mi xlink:href="javascript:alert(1)">x</mi></math>`,
+	`<div><p>nested <span class="ql-size-large">text</span></p></div>`,
+	`<p><p>double</p></p>`,
+	`<a href="/a"><a href="/b">nested links</a></a>`,
+	`<pre>
+leading newline</pre>`,
+	`<!-- comment --><p>text</p>`,
+	`<table><tr><td>cell</td></tr></table>`,
+	`<form><input value="x"></form>`,
+	`<p>unclosed <em>emphasis`,
+	`<style>p{color:red}</style>`,
+	`<textarea><script>alert(1)</script></textarea>`,
+	"<p>\x00null</p>",
+	`<img src="/images/a.jpg" srcset="/images/a-thumb.jpg 480w, javascript:alert(1) 2048w">`,
+	`<img srcset="data:image/png;base64,iVBO,Rw0KGgo= 1x">`,
+	// Kept the parser of golang.org/x/net/html before v0.33.0 busy for minutes
+	hangingInput,
+}
+
+const hangingInput = "</br><em><body><html>\n<svg><head><title><select><head><table></u>x<col><head><i></form><option></td><textarea><td>-->"
+
+func FuzzSanitize(f *testing.F) {
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	policy := QuillPolicy()
+
+	f.Fuzz(func(t *testing.T, input string) {
+		output := policy.Sanitize(input)
+
+		checkSafe(t, policy, output)
+
+		if again := policy.Sanitize(output); again != output {
+			t.Fatalf("Sanitize isn't idempotent\ninput:  %q\nfirst:  %q\nsecond: %q", input, output, again)
+		}
+	})
+}
+
+func TestSanitize(t *testing.T) {
+	policy := QuillPolicy()
+
+	cases := map[string]string{
+		`<p>Hello <strong>world</strong></p>`:                         `<p>Hello <strong>world</strong></p>`,
+		`<script>alert(1)</script><p>after</p>`:                       `<p>after</p>`,
+		`<div><p>text</p></div>`:                                      `<p>text</p>`,
+		`<a href="javascript:alert(1)">link</a>`:                      `<a rel="noopener noreferrer nofollow">link</a>`,
+		`<a href="https://example.com" onclick="x">link</a>`:          `<a href="https://example.com" rel="noopener noreferrer nofollow">link</a>`,
+		`<p class="ql-align-center ql-evil">centered</p>`:             `<p class="ql-align-center">centered</p>`,
+		`<img src="data:text/html;base64,PHNjcmlwdD4=" alt="">`:       `<img alt=""/>`,
+		`<img src="data:image/png;base64,iVBORw0KGgo=" alt="">`:       `<img alt=""/>`,
+		`<img src="/a.jpg" srcset="/a-thumb.jpg  480w,/a.jpg 2048w">`: `<img src="/a.jpg" srcset="/a-thumb.jpg 480w, /a.jpg 2048w"/>`,
+		`<img src="/a.jpg" srcset="javascript:x 1x">`:                 `<img src="/a.jpg"/>`,
+		hangingInput: "<br/><em>\n</em>",
+	}
+
+	for input, expected := range cases {
+		if output := policy.Sanitize(input); output != expected {
+			t.Errorf("Sanitize(%q) = %q, expected %q", input, output, expected)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	policy := QuillPolicy()
+	policy.MaxLength = 1024
+
+	cases := map[string]error{
+		`<p>Hello <strong>world</strong></p>`:                     nil,
+		strings.Repeat("<em>", 64) + "deep":                       nil,
+		strings.Repeat("<em>", 65) + "too deep":                   ErrTooDeep,
+		strings.Repeat("<em>x</em>", 100):                         nil,
+		strings.Repeat("<br><img>", 100):                          nil,
+		"<textarea>" + strings.Repeat("<em>", 100):                nil,
+		strings.Repeat("</em>", 100) + strings.Repeat("<em>", 64): nil,
+		strings.Repeat("a", 1025):                                 ErrTooLong,
+	}
+
+	for input, expected := range cases {
+		if err := policy.Check(input); err != expected {
+			t.Errorf("Check(%.40q) = %v, expected %v", input, err, expected)
+		}
+
+		if expected != nil && policy.Sanitize(input) != "" {
+			t.Errorf("Sanitize(%.40q) isn't empty", input)
+		}
+	}
+}
+
+// Fails the test if the HTML contains anything the policy doesn't allow
+func checkSafe(t *testing.T, policy *Policy, output string) {
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		switch node.Type {
+		case html.TextNode:
+		case html.ElementNode:
+			allowedAttributes, ok := policy.Elements[node.Data]
+			if !ok || node.Namespace != "" {
+				t.Fatalf("element %q isn't allowed: %q", node.Data, output)
+			}
+
+			for _, attr := range node.Attr {
+				if !slices.Contains(allowedAttributes, attr.Key) && !(node.Data == "a" && attr.Key == "rel") {
+					t.Fatalf("attribute %q isn't allowed on %q: %q", attr.Key, node.Data, output)
+				}
+
+				if slices.Contains(policy.URLAttributes, attr.Key) {
+					lower := strings.ToLower(attr.Val)
+					if !policy.AllowedURL(node.Data, attr.Val) || strings.Contains(lower, "script:") {
+						t.Fatalf("unsafe URL %q: %q", attr.Val, output)
+					}
+				}
+
+				if slices.Contains(policy.SrcsetAttributes, attr.Key) && !policy.AllowedSrcset(attr.Val) {
+					t.Fatalf("unsafe srcset %q: %q", attr.Val, output)
+				}
+
+				if attr.Key == "class" {
+					for _, class := range strings.Fields(attr.Val) {
+						if !policy.AllowedClass(class) {
+							t.Fatalf("class %q isn't allowed: %q", class, output)
+						}
+					}
+				}
+			}
+		default:
+			t.Fatalf("node of type %d isn't allowed: %q", node.Type, output)
+		}
+
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+
+	for _, node := range parseFragment(output) {
+		walk(node)
+	}
+}
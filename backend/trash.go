@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// How long a deleted post stays in the trash before it's purged together with its images
+var trashRetention = envDuration("TRASH_RETENTION", 30*24*time.Hour)
+
+// Periodically purges the posts that are in the trash longer than trashRetention
+func runTrashPurger() {
+	interval := max(min(trashRetention/2, time.Hour), time.Minute)
+
+	for {
+		images, err := store.PurgeTrash(context.Background(), trashRetention)
+		if err != nil {
+			logger.Println(err)
+		} else {
+			removeImages(images)
+		}
+
+		time.Sleep(interval)
+	}
+}
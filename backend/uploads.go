@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"slices"
+	"threadhelpServer/imageproc"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// How long an uploaded image waits to be referenced by a post before it's deleted
+var uploadExpiration = envDuration("UPLOAD_EXPIRATION", 24*time.Hour)
+
+// Max size of an uploaded image in bytes
+var uploadMaxSize = envInt64("UPLOAD_MAX_SIZE", 10*1024*1024)
+
+// Room for the multipart boundaries and headers around the uploaded file
+const uploadFormOverhead = 64 * 1024
+
+var errUnsupportedImage = errors.New("unsupported image format")
+var errUploadTooLarge = errors.New("the uploaded file is too large")
+var errNoUpload = errors.New("no file in the upload form")
+
+// Image formats accepted by the upload endpoint
+var uploadFormats = []string{"image/jpeg", "image/png", "image/webp"}
+
+// Streams the file of the multipart form field into a temporary file without buffering
+// the request body. Files over uploadMaxSize are rejected with errUploadTooLarge.
+// The caller closes and removes the returned file
+func receiveUpload(req *fasthttp.Request, field string) (*os.File, error) {
+	boundary := string(req.Header.MultipartFormBoundary())
+	if boundary == "" {
+		return nil, errNoUpload
+	}
+
+	maxBodySize := uploadMaxSize + uploadFormOverhead
+	if int64(req.Header.ContentLength()) > maxBodySize {
+		return nil, errUploadTooLarge
+	}
+
+	body := req.BodyStream()
+	if body == nil {
+		body = bytes.NewReader(req.Body())
+	}
+
+	form := multipart.NewReader(io.LimitReader(body, maxBodySize), boundary)
+	for {
+		part, err := form.NextPart()
+		if err != nil {
+			return nil, errNoUpload
+		}
+
+		if part.FormName() != field || part.FileName() == "" {
+			continue
+		}
+
+		file, err := os.CreateTemp("", "upload-*")
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := io.Copy(file, io.LimitReader(part, uploadMaxSize+1))
+		if err == nil && n > uploadMaxSize {
+			err = errUploadTooLarge
+		}
+		if err == nil {
+			_, err = file.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			discardUpload(file)
+			return nil, err
+		}
+
+		return file, nil
+	}
+}
+
+// Closes and removes the temporary file of an upload
+func discardUpload(file *os.File) {
+	file.Close()
+	if err := os.Remove(file.Name()); err != nil {
+		logger.Println(err)
+	}
+}
+
+// Passes an uploaded image through the image pipeline and writes its variants into the image store
+func storeUploadedImage(file io.Reader) (preparedImage, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return preparedImage{}, errUnsupportedImage
+	}
+	head = head[:n]
+
+	if !slices.Contains(uploadFormats, http.DetectContentType(head)) {
+		return preparedImage{}, errUnsupportedImage
+	}
+
+	imgData, err := io.ReadAll(io.LimitReader(io.MultiReader(bytes.NewReader(head), file), uploadMaxSize))
+	if err != nil {
+		return preparedImage{}, err
+	}
+
+	img, err := prepareImage(imgData)
+	if err != nil {
+		if errors.Is(err, imageproc.ErrUnsupportedImage) {
+			return preparedImage{}, errUnsupportedImage
+		}
+		return preparedImage{}, err
+	}
+
+	if err := imageStore.Put(img.Name, img.Result.Full.Data); err != nil {
+		return preparedImage{}, err
+	}
+
+	if err := imageStore.Put(img.ThumbnailName, img.Result.Thumbnail.Data); err != nil {
+		removeImages([]string{img.Name})
+		return preparedImage{}, err
+	}
+
+	return img, nil
+}
+
+// Deletes the images from the store. The failures are only logged,
+// the images that are left are deleted later by the image collector
+func removeImages(names []string) {
+	for _, img := range names {
+		if err := imageStore.Delete(img); err != nil {
+			logger.Println(err)
+		}
+	}
+}
+
+// Periodically deletes the uploaded images that weren't attached to any post in time
+func runUploadsCleaner() {
+	interval := max(min(uploadExpiration/2, 10*time.Minute), time.Minute)
+
+	for {
+		names, err := store.DeleteExpiredUploads(context.Background(), uploadExpiration)
+		if err != nil {
+			logger.Println(err)
+		} else {
+			removeImages(names)
+		}
+
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Another board already has the slug
+var ErrBoardSlugTaken = errors.New("another board has the slug")
+
+// Thematic board that groups posts
+type Board struct {
+	ID          string `json:"boardId"`
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	Description string `json:"description"`
+	Position    int32  `json:"position"`
+}
+
+// Returns all boards in their display order
+func GetBoards(ctx context.Context) ([]Board, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return []Board{}, err
+	}
+	defer con.Release()
+
+	rows, err := con.Query(ctx, "SELECT id, name, slug, description, position FROM boards ORDER BY position, name")
+	if err != nil {
+		return []Board{}, err
+	}
+
+	defer rows.Close()
+
+	boards := []Board{}
+	for rows.Next() {
+		var board Board
+		if err := rows.Scan(&board.ID, &board.Name, &board.Slug, &board.Description, &board.Position); err != nil {
+			return []Board{}, err
+		}
+
+		boards = append(boards, board)
+	}
+
+	return boards, rows.Err()
+}
+
+// Returns the board with the id
+func GetBoard(ctx context.Context, boardId string) (Board, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return Board{}, err
+	}
+	defer con.Release()
+
+	board := Board{ID: boardId}
+
+	row := con.QueryRow(ctx, "SELECT name, slug, description, position FROM boards WHERE id=$1", boardId)
+	if err := row.Scan(&board.Name, &board.Slug, &board.Description, &board.Position); err != nil {
+		return Board{}, err
+	}
+
+	return board, nil
+}
+
+func AddBoard(ctx context.Context, board Board) (Board, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return Board{}, err
+	}
+	defer con.Release()
+
+	row := con.QueryRow(
+		ctx,
+		"INSERT INTO boards(name, slug, description, position) VALUES($1, $2, $3, $4) RETURNING id",
+		board.Name, board.Slug, board.Description, board.Position,
+	)
+	if err := row.Scan(&board.ID); err != nil {
+		return Board{}, boardError(err)
+	}
+
+	return board, nil
+}
+
+func UpdateBoard(ctx context.Context, board Board) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer con.Release()
+
+	row := con.QueryRow(
+		ctx,
+		"UPDATE boards SET name=$1, slug=$2, description=$3, position=$4 WHERE id=$5 RETURNING id",
+		board.Name, board.Slug, board.Description, board.Position, board.ID,
+	)
+
+	return boardError(row.Scan(&board.ID))
+}
+
+// Translates the violation of the unique slug into ErrBoardSlugTaken
+func boardError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
+		return ErrBoardSlugTaken
+	}
+
+	return err
+}
+
+// Deletes the board, its posts stay in the global feed
+func DeleteBoard(ctx context.Context, boardId string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer con.Release()
+
+	row := con.QueryRow(ctx, "DELETE FROM boards WHERE id=$1 RETURNING id", boardId)
+
+	return row.Scan(&boardId)
+}
+
+// Returns a page of the feed of the board
+func GetBoardPosts(ctx context.Context, boardSlug string, query FeedQuery) (FeedPage, error) {
+	return queryFeed(ctx, "deletedAt IS NULL AND boardId=(SELECT id FROM boards WHERE slug=$1)", []any{boardSlug}, query)
+}
@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Reply to a post or to another comment
+type Comment struct {
+	ID              string   `json:"commentId"`
+	PostID          string   `json:"postId"`
+	ParentID        string   `json:"parentId,omitempty"`
+	UserID          string   `json:"userId,omitempty"`
+	UserDisplayName string   `json:"userDisplayName,omitempty"`
+	PubDate         JSONTime `json:"pubTime,omitempty"`
+	Content         string   `json:"content"`
+	Replies         uint64   `json:"replies"`
+	UserEmail       string   `json:"-"`
+}
+
+// Page of the comments, the oldest first
+type CommentPage struct {
+	Comments []Comment `json:"comments"`
+	// Cursor of the later comments, empty if there are none
+	NextCursor string `json:"nextCursor"`
+}
+
+// Position of a comment among the comments, the cursors are encoded like the ones of the feed
+func commentCursor(comment Comment) feedCursor {
+	return feedCursor{PubDate: time.Time(comment.PubDate).UnixMicro(), ID: comment.ID}
+}
+
+// Makes a page of up to limit+1 comments, the extra comment tells there are more
+func newCommentPage(comments []Comment, limit uint32) CommentPage {
+	page := CommentPage{Comments: comments}
+	if uint32(len(comments)) > limit {
+		page.Comments = comments[:limit]
+		page.NextCursor = commentCursor(page.Comments[limit-1]).encode()
+	}
+
+	return page
+}
+
+// Converts an empty id into NULL
+func nullableId(id string) *string {
+	if id == "" {
+		return nil
+	}
+
+	return &id
+}
+
+// Adds a comment to a post. If ParentID is set, the comment becomes a reply to the parent comment,
+// which must belong to the same post
+func AddComment(ctx context.Context, comment Comment) (Comment, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return Comment{}, err
+	}
+	defer con.Release()
+
+	var pubDate pgtype.Timestamp
+
+	r := con.QueryRow(
+		ctx,
+		`INSERT INTO comments(postId, parentId, userId, userEmail, userDisplayName, content)
+		SELECT $1::uuid, $2::uuid, $3, $4, $5, $6
+		WHERE EXISTS(SELECT 1 FROM posts WHERE id=$1 AND deletedAt IS NULL)
+		AND ($2::uuid IS NULL OR EXISTS(SELECT 1 FROM comments WHERE id=$2 AND postId=$1))
+		RETURNING id, pubDate`,
+		comment.PostID, nullableId(comment.ParentID), comment.UserID, comment.UserEmail, comment.UserDisplayName, comment.Content,
+	)
+	if err := r.Scan(&comment.ID, &pubDate); err != nil {
+		return Comment{}, err
+	}
+
+	comment.PubDate = JSONTime(pubDate.Time)
+
+	return comment, nil
+}
+
+// Deletes a comment together with all replies to it and returns the id of the post it belonged to
+func DeleteComment(ctx context.Context, commentId string, userId string) (postId string, err error) {
+	postId, _, err = deleteComment(ctx, "DELETE FROM comments WHERE id=$1 AND userId=$2 RETURNING postId, userId", commentId, userId)
+	return postId, err
+}
+
+// Deletes any comment together with all replies to it and returns the id of the post it belonged to
+// and the id of its author
+func DeleteCommentAdmin(ctx context.Context, commentId string) (postId string, authorId string, err error) {
+	return deleteComment(ctx, "DELETE FROM comments WHERE id=$1 RETURNING postId, userId", commentId)
+}
+
+func deleteComment(ctx context.Context, query string, args ...any) (string, string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	defer con.Release()
+
+	var postId, authorId string
+	if err := con.QueryRow(ctx, query, args...).Scan(&postId, &authorId); err != nil {
+		return "", "", err
+	}
+
+	return postId, authorId, nil
+}
+
+func GetCommentAuthor(ctx context.Context, commentId string) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer con.Release()
+
+	var userId string
+
+	row := con.QueryRow(ctx, "SELECT userId FROM comments WHERE id=$1", commentId)
+	if err := row.Scan(&userId); err != nil {
+		return "", err
+	}
+
+	return userId, nil
+}
+
+// Returns a page of up to count comments of a post, the oldest first. If parentId is empty,
+// the top level comments are returned, otherwise the replies to the parent comment.
+// The cursor is the NextCursor of the previous page, ErrInvalidCursor if it isn't valid
+func GetComments(ctx context.Context, postId string, parentId string, cursor string, count uint32) (CommentPage, error) {
+	// The position is kept in the cursor, so the page continues even if the last comment is deleted
+	var afterDate *time.Time
+	var afterId *string
+	if cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			return CommentPage{}, err
+		}
+
+		pubDate := after.pubDate()
+		afterDate, afterId = &pubDate, &after.ID
+	}
+
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return CommentPage{}, err
+	}
+	defer con.Release()
+
+	rows, err := con.Query(
+		ctx,
+		`SELECT c.id, c.parentId, c.userId, c.userDisplayName, c.pubDate, c.content,
+			(SELECT COUNT(1) FROM comments r WHERE r.parentId=c.id)
+		FROM comments c
+		WHERE c.postId=$1 AND c.parentId IS NOT DISTINCT FROM $2::uuid
+		AND ($3::timestamp IS NULL OR (c.pubDate, c.id) > ($3::timestamp, $4::uuid))
+		ORDER BY c.pubDate, c.id
+		LIMIT $5`,
+		postId, nullableId(parentId), afterDate, afterId, count+1,
+	)
+	if err != nil {
+		return CommentPage{}, err
+	}
+
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		var comment Comment
+		var parent *string
+		var pubDate pgtype.Timestamp
+		if err := rows.Scan(&comment.ID, &parent, &comment.UserID, &comment.UserDisplayName, &pubDate, &comment.Content, &comment.Replies); err != nil {
+			return CommentPage{}, err
+		}
+
+		comment.PostID = postId
+		if parent != nil {
+			comment.ParentID = *parent
+		}
+		comment.PubDate = JSONTime(pubDate.Time)
+
+		comments = append(comments, comment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return CommentPage{}, err
+	}
+
+	return newCommentPage(comments, count), nil
+}
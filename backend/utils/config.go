@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// Reads a duration like "30s" or "1h" from the environment variable, defVal is returned if it's unset or invalid
+func envDuration(name string, defVal time.Duration) time.Duration {
+	if val, err := time.ParseDuration(os.Getenv(name)); err == nil && val > 0 {
+		return val
+	}
+
+	return defVal
+}
+
+// Reads a positive integer from the environment variable, defVal is returned if it's unset or invalid
+func envInt32(name string, defVal int32) int32 {
+	if val, err := strconv.ParseInt(os.Getenv(name), 10, 32); err == nil && val > 0 {
+		return int32(val)
+	}
+
+	return defVal
+}
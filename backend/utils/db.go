@@ -2,20 +2,32 @@ package utils
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 var DBADDRESS = os.Getenv("DB_ADDRESS")
-var DBCTX = context.Background()
 var cacheStorage *CacheStorage
 var db *pgxpool.Pool
 
+// Limit of every storage call, so a slow query doesn't hold the request
+var queryTimeout = envDuration("DB_QUERY_TIMEOUT", 10*time.Second)
+
+// How long a storage call waits for a free connection of the pool
+var acquireTimeout = envDuration("DB_ACQUIRE_TIMEOUT", 3*time.Second)
+
+var ErrForbidden = errors.New("the user isn't allowed to perform this action")
+
+// The pool has no free connection and can't open a new one in time
+var ErrDBUnavailable = errors.New("no database connection is available")
+
 type JSONTime time.Time
 
 func (t JSONTime) MarshalJSON() ([]byte, error) {
@@ -23,70 +35,94 @@ func (t JSONTime) MarshalJSON() ([]byte, error) {
 }
 
 type Post struct {
-	ID              string   `json:"postId"`
-	UserID          string   `json:"userId,omitempty"`
-	UserDisplayName string   `json:"userDisplayName,omitempty"`
-	PubDate         JSONTime `json:"pubTime,omitempty"`
-	Content         string   `json:"-"`
-	UserEmail       string   `json:"-"`
-	AttachedImages  []string `json:"-"`
+	ID              string    `json:"postId"`
+	UserID          string    `json:"userId,omitempty"`
+	UserDisplayName string    `json:"userDisplayName,omitempty"`
+	PubDate         JSONTime  `json:"pubTime,omitempty"`
+	EditDate        *JSONTime `json:"editTime,omitempty"`
+	BoardID         string    `json:"boardId,omitempty"`
+	Content         string    `json:"-"`
+	UserEmail       string    `json:"-"`
+	AttachedImages  []string  `json:"-"`
+	// Language code of the content, used by the search
+	Language string `json:"-"`
+	// Set for the posts in the trash
+	Deletion *PostDeletion `json:"deletion,omitempty"`
 }
 
-func InitDB(cs *CacheStorage) error {
-	cacheStorage = cs
+// Converts a nullable timestamp into a JSONTime pointer, nil if the timestamp is NULL
+func optionalJSONTime(t pgtype.Timestamp) *JSONTime {
+	if !t.Valid {
+		return nil
+	}
 
-	var err error
-	db, err = pgxpool.New(DBCTX, DBADDRESS)
+	jsonTime := JSONTime(t.Time)
+	return &jsonTime
+}
 
-	if err != nil {
-		return err
+// Splits the attachedImages column value into image names, skipping empty ones
+func splitImages(attachedImages string) []string {
+	images := []string{}
+	for _, img := range strings.Split(attachedImages, ",") {
+		if img != "" {
+			images = append(images, img)
+		}
 	}
 
-	return InitTables()
+	return images
 }
 
-func InitTables() error {
-	query := `
-CREATE TABLE IF NOT EXISTS blacklist(
-	gmail text PRIMARY KEY
-);
-CREATE TABLE IF NOT EXISTS admins(
-	gmail text PRIMARY KEY
-);
-CREATE TABLE IF NOT EXISTS posts(
-	id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
-	userId text,
-	userEmail text,
-	userDisplayName text,
-	content text,
-	pubDate timestamp without time zone DEFAULT NOW(),
-	attachedImages text
-);
-CREATE TABLE IF NOT EXISTS likes(
-	userId text,
-	postId text
-);
-`
-	con, err := db.Acquire(DBCTX)
+// Connects to the database, the schema is created by MigrateUp. The pool settings of DB_ADDRESS,
+// e.g. pool_max_conns, are overridden by DB_MAX_CONNS, DB_MIN_CONNS, DB_MAX_CONN_LIFETIME
+// and DB_MAX_CONN_IDLE_TIME
+func InitDB(cs *CacheStorage) error {
+	cacheStorage = cs
+
+	config, err := pgxpool.ParseConfig(DBADDRESS)
 	if err != nil {
 		return err
 	}
-	defer con.Release()
 
-	tx, err := con.Begin(DBCTX)
-	if err != nil {
-		return err
+	config.MaxConns = envInt32("DB_MAX_CONNS", config.MaxConns)
+	config.MinConns = min(envInt32("DB_MIN_CONNS", config.MinConns), config.MaxConns)
+	config.MaxConnLifetime = envDuration("DB_MAX_CONN_LIFETIME", config.MaxConnLifetime)
+	config.MaxConnIdleTime = envDuration("DB_MAX_CONN_IDLE_TIME", config.MaxConnIdleTime)
+
+	db, err = pgxpool.NewWithConfig(context.Background(), config)
+
+	return err
+}
+
+// Limits the storage call to DB_QUERY_TIMEOUT
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, queryTimeout)
+}
+
+// Takes a connection from the pool. ErrDBUnavailable is returned if none is free
+// within DB_ACQUIRE_TIMEOUT or a new one can't be opened
+func acquire(ctx context.Context) (*pgxpool.Conn, error) {
+	acquireCtx, cancel := context.WithTimeout(ctx, acquireTimeout)
+	defer cancel()
+
+	con, err := db.Acquire(acquireCtx)
+	if err != nil && ctx.Err() == nil {
+		return nil, fmt.Errorf("%w: %w", ErrDBUnavailable, err)
 	}
 
-	tx.Exec(DBCTX, query)
-	return tx.Commit(DBCTX)
+	return con, err
+}
+
+// Tells whether the storage call failed because the database is overloaded
+// or too slow, so it can be retried later
+func IsUnavailable(err error) bool {
+	return errors.Is(err, ErrDBUnavailable) || errors.Is(err, context.DeadlineExceeded)
 }
 
 func CloseDB() {
 	db.Close()
 }
 
-func IsInBlacklist(gmail string) bool {
+func IsInBlacklist(ctx context.Context, gmail string) bool {
 	if cached, found := cacheStorage.GetCache("userBlacklist;" + gmail); found {
 		if ret, ok := cached.(bool); ok {
 			return ret
@@ -95,13 +131,16 @@ func IsInBlacklist(gmail string) bool {
 
 	var inBlacklist bool = false
 
-	con, err := db.Acquire(DBCTX)
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
 	if err != nil {
 		return false
 	}
 	defer con.Release()
 
-	if con.QueryRow(DBCTX, "SELECT EXISTS(SELECT 1 FROM blacklist WHERE gmail=$1 LIMIT 1)", gmail).Scan(&inBlacklist) != nil {
+	if con.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM blacklist WHERE gmail=$1 LIMIT 1)", gmail).Scan(&inBlacklist) != nil {
 		return false
 	}
 
@@ -110,7 +149,7 @@ func IsInBlacklist(gmail string) bool {
 	return inBlacklist
 }
 
-func IsAdmin(gmail string) bool {
+func IsAdmin(ctx context.Context, gmail string) bool {
 	if cached, found := cacheStorage.GetCache("userAdmin;" + gmail); found {
 		if ret, ok := cached.(bool); ok {
 			return ret
@@ -119,13 +158,16 @@ func IsAdmin(gmail string) bool {
 
 	var isAdmin bool = false
 
-	con, err := db.Acquire(DBCTX)
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
 	if err != nil {
 		return false
 	}
 	defer con.Release()
 
-	if con.QueryRow(DBCTX, "SELECT EXISTS(SELECT 1 FROM admins WHERE gmail=$1 LIMIT 1)", gmail).Scan(&isAdmin) != nil {
+	if con.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM admins WHERE gmail=$1 LIMIT 1)", gmail).Scan(&isAdmin) != nil {
 		return false
 	}
 
@@ -134,24 +176,36 @@ func IsAdmin(gmail string) bool {
 	return isAdmin
 }
 
-func AddPost(post Post) (Post, error) {
-	con, err := db.Acquire(DBCTX)
+// Adds a post. The pending uploads of the author among referencedImages are attached to the post
+func AddPost(ctx context.Context, post Post, referencedImages []string) (Post, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
 	if err != nil {
 		return Post{}, err
 	}
 	defer con.Release()
 
-	tx, err := con.Begin(DBCTX)
+	tx, err := con.Begin(ctx)
 	if err != nil {
 		return Post{}, err
 	}
+	defer tx.Rollback(ctx)
+
+	claimed, err := claimUploads(ctx, tx, post.UserID, referencedImages)
+	if err != nil {
+		return Post{}, err
+	}
+	post.AttachedImages = append(post.AttachedImages, claimed...)
 
 	var pubDate pgtype.Timestamp
 
 	r := tx.QueryRow(
-		DBCTX,
-		"INSERT INTO posts(userId, userEmail, userDisplayName, content, attachedImages) VALUES($1, $2, $3, $4, $5) RETURNING id, pubDate",
-		post.UserID, post.UserEmail, post.UserDisplayName, post.Content, strings.Join(post.AttachedImages, ","),
+		ctx,
+		"INSERT INTO posts(userId, userEmail, userDisplayName, content, attachedImages, boardId, searchText, searchConfig) VALUES($1, $2, $3, $4, $5, $6, $7, $8::regconfig) RETURNING id, pubDate",
+		post.UserID, post.UserEmail, post.UserDisplayName, post.Content, strings.Join(post.AttachedImages, ","), nullableId(post.BoardID),
+		contentText(post.Content), SearchConfig(post.Language),
 	)
 	if err := r.Scan(&post.ID, &pubDate); err != nil {
 		return Post{}, err
@@ -159,109 +213,93 @@ func AddPost(post Post) (Post, error) {
 
 	post.PubDate = JSONTime(pubDate.Time)
 
-	if err := tx.Commit(DBCTX); err != nil {
+	if err := tx.Commit(ctx); err != nil {
 		return Post{}, err
 	}
 
 	return post, nil
 }
 
-func DeletePost(postId string, userId string) (attachedImages []string, error error) {
-	con, err := db.Acquire(DBCTX)
-	if err != nil {
-		return []string{}, err
-	}
-	defer con.Release()
-
-	tx, err := con.Begin(DBCTX)
-	if err != nil {
-		return []string{}, err
-	}
-
-	var attachedImgs string
-	row := tx.QueryRow(DBCTX, "DELETE FROM posts WHERE userId=$1 AND id=$2 RETURNING attachedImages", userId, postId)
-
-	err = row.Scan(&attachedImgs)
-	if err != nil {
-		return []string{}, err
-	}
-
-	if err := tx.Commit(DBCTX); err != nil {
-		return []string{}, err
-	}
-
-	return strings.Split(attachedImgs, ","), nil
+// Returns a page of the feed of all posts
+func GetPosts(ctx context.Context, query FeedQuery) (FeedPage, error) {
+	return queryFeed(ctx, "deletedAt IS NULL", []any{}, query)
 }
 
-func DeletePostAdmin(postId string) (attachedImages []string, error error) {
-	con, err := db.Acquire(DBCTX)
+// Reads a page of the feed. The filter limits the posts with the arguments in args,
+// the arguments of the cursor follow them
+func queryFeed(ctx context.Context, filter string, args []any, query FeedQuery) (FeedPage, error) {
+	cursor, err := decodeFeedCursor(query)
 	if err != nil {
-		return []string{}, err
+		return FeedPage{}, err
 	}
-	defer con.Release()
 
-	tx, err := con.Begin(DBCTX)
+	sql, args := feedSQL(postColumns, filter, args, cursor, query)
+	posts, err := queryPosts(ctx, sql, args...)
 	if err != nil {
-		return []string{}, err
+		return FeedPage{}, err
 	}
 
-	var attachedImgs string
-	row := tx.QueryRow(DBCTX, "DELETE FROM posts WHERE id=$1 RETURNING attachedImages", postId)
+	return newFeedPage(posts, query), nil
+}
 
-	err = row.Scan(&attachedImgs)
-	if err != nil {
-		return []string{}, err
+// Builds the query of a page of the feed that selects the columns, it reads up to query.Limit+1 posts
+func feedSQL(columns string, filter string, args []any, cursor *feedCursor, query FeedQuery) (string, []any) {
+	conditions := []string{filter}
+
+	order := "pubDate DESC, id"
+	if cursor != nil {
+		args = append(args, cursor.pubDate(), cursor.ID)
+		date, id := len(args)-1, len(args)
+
+		// Written so posts_pubdate_idx limits the dates
+		if query.Newer {
+			conditions = append(conditions, fmt.Sprintf("pubDate >= $%d AND (pubDate > $%d OR id < $%d)", date, date, id))
+			order = "pubDate, id DESC"
+		} else {
+			conditions = append(conditions, fmt.Sprintf("pubDate <= $%d AND (pubDate < $%d OR id > $%d)", date, date, id))
+		}
 	}
 
-	if err := tx.Commit(DBCTX); err != nil {
-		return []string{}, err
-	}
+	args = append(args, query.Limit+1)
+	sql := fmt.Sprintf("SELECT %s FROM posts WHERE %s ORDER BY %s LIMIT $%d", columns, strings.Join(conditions, " AND "), order, len(args))
 
-	return strings.Split(attachedImgs, ","), nil
+	return sql, args
 }
 
-func GetNewestPosts(count uint32) ([]Post, error) {
-	con, err := db.Acquire(DBCTX)
-	if err != nil {
-		return []Post{}, err
-	}
-	defer con.Release()
+// Columns that are selected by queryPosts
+const postColumns = "id, pubDate, editDate, userId, userDisplayName, boardId"
 
-	rows, err := con.Query(DBCTX, "SELECT id, pubDate, userId, userDisplayName FROM posts ORDER BY pubDate DESC LIMIT $1", count)
+// Scans a row of postColumns, the columns that follow them are scanned into dest
+func scanPost(row pgx.Row, dest ...any) (Post, error) {
+	var post Post
+	var boardId *string
+	var pubDate, editDate pgtype.Timestamp
+	err := row.Scan(append([]any{&post.ID, &pubDate, &editDate, &post.UserID, &post.UserDisplayName, &boardId}, dest...)...)
 	if err != nil {
-		return []Post{}, err
+		return Post{}, err
 	}
 
-	defer rows.Close()
-
-	posts := []Post{}
-	for rows.Next() {
-		var postId, userId, userDisplayName string
-		var pubDate pgtype.Timestamp
-		err := rows.Scan(&postId, &pubDate, &userId, &userDisplayName)
-		if err != nil {
-			return []Post{}, err
-		}
-
-		posts = append(posts, Post{
-			ID:              postId,
-			UserID:          userId,
-			UserDisplayName: userDisplayName,
-			PubDate:         JSONTime(pubDate.Time),
-		})
+	post.PubDate = JSONTime(pubDate.Time)
+	post.EditDate = optionalJSONTime(editDate)
+	if boardId != nil {
+		post.BoardID = *boardId
 	}
 
-	return posts, nil
+	return post, nil
 }
 
-func GetNewestPostsFrom(postId string, count uint32) ([]Post, error) {
-	con, err := db.Acquire(DBCTX)
+// Runs a query that selects postColumns and returns the posts metadata
+func queryPosts(ctx context.Context, query string, args ...any) ([]Post, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
 	if err != nil {
 		return []Post{}, err
 	}
 	defer con.Release()
 
-	rows, err := con.Query(DBCTX, "SELECT id, pubDate, userId, userDisplayName FROM posts WHERE pubDate < (SELECT pubDate FROM posts WHERE id=$1 LIMIT 1) ORDER BY pubDate DESC LIMIT $2", postId, count)
+	rows, err := con.Query(ctx, query, args...)
 	if err != nil {
 		return []Post{}, err
 	}
@@ -270,26 +308,22 @@ func GetNewestPostsFrom(postId string, count uint32) ([]Post, error) {
 
 	posts := []Post{}
 	for rows.Next() {
-		var postId, userId, userDisplayName string
-		var pubDate pgtype.Timestamp
-		err := rows.Scan(&postId, &pubDate, &userId, &userDisplayName)
+		post, err := scanPost(rows)
 		if err != nil {
 			return []Post{}, err
 		}
 
-		posts = append(posts, Post{
-			ID:              postId,
-			UserID:          userId,
-			UserDisplayName: userDisplayName,
-			PubDate:         JSONTime(pubDate.Time),
-		})
+		posts = append(posts, post)
 	}
 
-	return posts, nil
+	return posts, rows.Err()
 }
 
-func GetPostContent(postId string) (string, error) {
-	con, err := db.Acquire(DBCTX)
+func GetPostContent(ctx context.Context, postId string) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -297,7 +331,7 @@ func GetPostContent(postId string) (string, error) {
 
 	var content string
 
-	row := con.QueryRow(DBCTX, "SELECT content FROM posts WHERE id=$1", postId)
+	row := con.QueryRow(ctx, "SELECT content FROM posts WHERE id=$1 AND deletedAt IS NULL", postId)
 	if err := row.Scan(&content); err != nil {
 		return "", err
 	}
@@ -305,56 +339,68 @@ func GetPostContent(postId string) (string, error) {
 	return content, nil
 }
 
-func AddLike(userId string, postId string) error {
-	con, err := db.Acquire(DBCTX)
-	if err != nil {
-		return err
-	}
-	defer con.Release()
-
-	tx, err := con.Begin(DBCTX)
-	if err != nil {
-		return err
-	}
-
-	_, err = tx.Exec(DBCTX, "INSERT INTO likes(userId, postId) SELECT $1, $2 WHERE NOT EXISTS (SELECT 1 FROM likes WHERE userId=$1 AND postId=$2)", userId, postId)
-	if err != nil {
-		return err
-	}
-
-	if err := tx.Commit(DBCTX); err != nil {
-		return err
-	}
+// Data of a post the feed loads besides the metadata
+type PostMeta struct {
+	Content string `json:"content"`
+	Likes   uint64 `json:"likes"`
+	// The post is liked by the user that asks
+	Liked bool `json:"liked"`
+}
 
-	return nil
+// Post with its data, as returned by the feed with the withMeta option
+type PostWithMeta struct {
+	Post
+	PostMeta
 }
 
-func RemoveLike(userId string, postId string) error {
-	con, err := db.Acquire(DBCTX)
+// Returns the content and the likes of the posts by their ids in one query.
+// The posts that don't exist are left out
+func GetPostsMeta(ctx context.Context, postIds []string, userId string) (map[string]PostMeta, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
 	if err != nil {
-		return err
+		return map[string]PostMeta{}, err
 	}
 	defer con.Release()
 
-	tx, err := con.Begin(DBCTX)
+	rows, err := con.Query(
+		ctx,
+		`SELECT id, content, likesCount, EXISTS(SELECT 1 FROM likes WHERE postId=posts.id AND userId=$2 AND reaction=$3)
+		FROM posts WHERE id=ANY($1::uuid[]) AND deletedAt IS NULL`,
+		postIds, userId, DefaultReaction,
+	)
 	if err != nil {
-		return err
+		return map[string]PostMeta{}, err
 	}
 
-	_, err = tx.Exec(DBCTX, "DELETE FROM likes WHERE userId=$1 AND postId=$2", userId, postId)
-	if err != nil {
-		return err
-	}
+	defer rows.Close()
 
-	if err := tx.Commit(DBCTX); err != nil {
-		return err
+	metas := map[string]PostMeta{}
+	for rows.Next() {
+		var postId string
+		var content *string
+		var meta PostMeta
+		if err := rows.Scan(&postId, &content, &meta.Likes, &meta.Liked); err != nil {
+			return map[string]PostMeta{}, err
+		}
+
+		if content != nil {
+			meta.Content = *content
+		}
+		metas[postId] = meta
 	}
 
-	return nil
+	return metas, rows.Err()
 }
 
-func GetPostLikes(postId string) (uint64, error) {
-	con, err := db.Acquire(DBCTX)
+// The posts that don't exist have no likes
+func GetPostLikes(ctx context.Context, postId string) (uint64, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -362,7 +408,7 @@ func GetPostLikes(postId string) (uint64, error) {
 
 	var count uint64
 
-	row := con.QueryRow(DBCTX, "SELECT COUNT(1) FROM likes WHERE postId=$1", postId)
+	row := con.QueryRow(ctx, "SELECT COALESCE((SELECT likesCount FROM posts WHERE id=$1 AND deletedAt IS NULL), 0)", postId)
 	if err := row.Scan(&count); err != nil {
 		return 0, err
 	}
@@ -370,8 +416,11 @@ func GetPostLikes(postId string) (uint64, error) {
 	return count, nil
 }
 
-func CheckUserLikedPost(userId string, postId string) (bool, error) {
-	con, err := db.Acquire(DBCTX)
+func CheckUserLikedPost(ctx context.Context, userId string, postId string) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
 	if err != nil {
 		return true, err
 	}
@@ -379,7 +428,7 @@ func CheckUserLikedPost(userId string, postId string) (bool, error) {
 
 	var liked bool
 
-	row := con.QueryRow(DBCTX, "SELECT EXISTS(SELECT 1 FROM likes WHERE userId=$1 AND postId=$2)", userId, postId)
+	row := con.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM likes WHERE userId=$1 AND postId=$2 AND reaction=$3)", userId, postId, DefaultReaction)
 	if err := row.Scan(&liked); err != nil {
 		return true, err
 	}
@@ -0,0 +1,240 @@
+package utils
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Types of the events sent to the clients, they are also the SSE event names
+const (
+	EventNewPost       = "newPost"
+	EventEditPost      = "editPost"
+	EventDeletePost    = "delPost"
+	EventRestorePost   = "restorePost"
+	EventNewComment    = "newComment"
+	EventDeleteComment = "delComment"
+	EventUpdateLikes   = "updateLikes"
+	EventUpdateBoards  = "updateBoards"
+	// The reaction counts of a post changed
+	EventUpdateReactions = "updateReactions"
+	// The admins changed the reaction types
+	EventUpdateReactionTypes = "updateReactionTypes"
+	// The client missed events that can't be replayed and has to reload its data
+	EventResync = "resync"
+	// The client is disconnected by the server
+	EventEvicted = "evicted"
+	// A user is writing a comment to the post
+	EventTyping = "typing"
+	// An admin changed the content of another user, sent to the author and to the admins
+	EventModeration = "moderation"
+	// Something happened to the content of the user, e.g. a reply to their comment
+	EventNotification = "notification"
+	// A user connected or disconnected, the leaves are delayed in case the user reconnects
+	EventPresenceJoin  = "presenceJoin"
+	EventPresenceLeave = "presenceLeave"
+)
+
+// Kinds of the notifications
+const (
+	NotificationComment = "comment"
+	NotificationReply   = "reply"
+)
+
+// Event sent to the clients. ID and Timestamp are set when the event is sent.
+// The id consists of the id of the server and the number of the event on it
+type Event struct {
+	Type      string   `json:"type"`
+	ID        string   `json:"id"`
+	Timestamp JSONTime `json:"timestamp"`
+	Payload   any      `json:"payload,omitempty"`
+	// Data of the event in the legacy "type;data" format, the payload is used if it's empty
+	legacyData string
+}
+
+type PostIDPayload struct {
+	PostID string `json:"postId"`
+}
+
+type CommentIDPayload struct {
+	CommentID string `json:"commentId"`
+}
+
+type LikesPayload struct {
+	PostID string `json:"postId"`
+	Likes  uint64 `json:"likes"`
+}
+
+type ReactionsPayload struct {
+	PostID string         `json:"postId"`
+	Counts ReactionCounts `json:"counts"`
+}
+
+type TypingPayload struct {
+	PostID          string `json:"postId"`
+	UserID          string `json:"userId"`
+	UserDisplayName string `json:"userDisplayName"`
+}
+
+// The action is the type of the event that reports the change, e.g. delPost
+type ModerationPayload struct {
+	Action    string `json:"action"`
+	PostID    string `json:"postId"`
+	CommentID string `json:"commentId,omitempty"`
+	AuthorID  string `json:"authorId"`
+}
+
+type NotificationPayload struct {
+	Kind            string `json:"kind"`
+	PostID          string `json:"postId"`
+	CommentID       string `json:"commentId"`
+	UserID          string `json:"userId"`
+	UserDisplayName string `json:"userDisplayName"`
+}
+
+type EvictedPayload struct {
+	Reason string `json:"reason"`
+}
+
+func NewPostEvent(post Post) Event {
+	return Event{Type: EventNewPost, Payload: post}
+}
+
+func EditPostEvent(post Post) Event {
+	return Event{Type: EventEditPost, Payload: post}
+}
+
+func DeletePostEvent(postId string) Event {
+	return Event{Type: EventDeletePost, Payload: PostIDPayload{postId}, legacyData: postId}
+}
+
+// The post was taken out of the trash, the clients put it back at its place in the feed
+func RestorePostEvent(post Post) Event {
+	return Event{Type: EventRestorePost, Payload: post}
+}
+
+func NewCommentEvent(comment Comment) Event {
+	return Event{Type: EventNewComment, Payload: comment}
+}
+
+func DeleteCommentEvent(commentId string) Event {
+	return Event{Type: EventDeleteComment, Payload: CommentIDPayload{commentId}, legacyData: commentId}
+}
+
+// The legacy format has only the post id, the clients fetch the likes themselves
+func UpdateLikesEvent(postId string, likes uint64) Event {
+	return Event{Type: EventUpdateLikes, Payload: LikesPayload{postId, likes}, legacyData: postId}
+}
+
+func UpdateBoardsEvent() Event {
+	return Event{Type: EventUpdateBoards}
+}
+
+// The legacy format has only the post id, as in updateLikes
+func UpdateReactionsEvent(postId string, counts ReactionCounts) Event {
+	return Event{Type: EventUpdateReactions, Payload: ReactionsPayload{postId, counts}, legacyData: postId}
+}
+
+func UpdateReactionTypesEvent() Event {
+	return Event{Type: EventUpdateReactionTypes}
+}
+
+func TypingEvent(postId string, userId string, userDisplayName string) Event {
+	return Event{Type: EventTyping, Payload: TypingPayload{postId, userId, userDisplayName}}
+}
+
+func ModerationEvent(action string, postId string, commentId string, authorId string) Event {
+	return Event{Type: EventModeration, Payload: ModerationPayload{action, postId, commentId, authorId}}
+}
+
+// Notifies about the comment, the kind tells why the user receives it
+func NotificationEvent(kind string, comment Comment) Event {
+	return Event{
+		Type: EventNotification,
+		Payload: NotificationPayload{
+			Kind:            kind,
+			PostID:          comment.PostID,
+			CommentID:       comment.ID,
+			UserID:          comment.UserID,
+			UserDisplayName: comment.UserDisplayName,
+		},
+	}
+}
+
+func PresenceJoinEvent(user PresenceUser) Event {
+	return Event{Type: EventPresenceJoin, Payload: user}
+}
+
+func PresenceLeaveEvent(user PresenceUser) Event {
+	return Event{Type: EventPresenceLeave, Payload: user}
+}
+
+func resyncEvent() Event {
+	return Event{Type: EventResync}
+}
+
+func evictedEvent(reason string) Event {
+	return Event{Type: EventEvicted, Payload: EvictedPayload{reason}, legacyData: reason}
+}
+
+// Renders the event as an SSE message with the id, the event name and the JSON envelope
+func (e Event) message(envelope []byte) []byte {
+	message := append(e.idLine(), "event: "+e.Type+"\ndata: "...)
+	message = append(message, envelope...)
+	return append(message, "\n\n"...)
+}
+
+// Renders the event as an SSE message in the legacy format, which has no event name,
+// so the clients receive it in onmessage
+func (e Event) legacyMessage() ([]byte, error) {
+	data := []byte(e.Type + ";" + e.legacyData)
+	if e.legacyData == "" && e.Payload != nil {
+		payload, err := json.Marshal(e.Payload)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, payload...)
+	}
+
+	message := append(e.idLine(), "data: "...)
+	message = append(message, data...)
+	return append(message, "\n\n"...), nil
+}
+
+// Events without an id, which aren't replayed, don't change the last event id of the client
+func (e Event) idLine() []byte {
+	if e.ID == "" {
+		return []byte{}
+	}
+
+	return []byte("id: " + e.ID + "\n")
+}
+
+// Sets the id of the event and renders its messages. The time is set if the event doesn't have it.
+// The event doesn't get an id if the instance is empty
+func newSSEEvent(instance string, seq uint64, event Event, target eventTarget) (sseEvent, error) {
+	if instance != "" {
+		event.ID = instance + "-" + strconv.FormatUint(seq, 10)
+	}
+	if time.Time(event.Timestamp).IsZero() {
+		event.Timestamp = JSONTime(time.Now())
+	}
+
+	envelope, err := json.Marshal(event)
+	if err != nil {
+		return sseEvent{}, err
+	}
+
+	legacyMessage, err := event.legacyMessage()
+	if err != nil {
+		return sseEvent{}, err
+	}
+
+	return sseEvent{
+		id:            seq,
+		envelope:      envelope,
+		message:       event.message(envelope),
+		legacyMessage: legacyMessage,
+		target:        target,
+	}, nil
+}
@@ -0,0 +1,328 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Channel of the NOTIFY messages with the ids of the new events in the outbox
+const fanoutChannel = "sse_events"
+
+// How long the events stay in the outbox for the instances that reconnect
+const fanoutRetention = 5 * time.Minute
+
+// How many of the recently received event ids are remembered to skip the duplicates
+const fanoutSeenSize = 4096
+
+// How many events wait to be written into the outbox, the events over it are dropped
+// so a slow or unavailable database doesn't hold up the requests
+const fanoutQueueSize = 1024
+
+// Max number of the events written into the outbox by one statement
+const fanoutBatchSize = 64
+
+var errFanoutQueueFull = errors.New("the SSE fanout queue is full")
+
+// How far back the instance looks for the missed events after reconnecting.
+// The ids are taken before the transactions commit, so a smaller id can become visible later
+const fanoutCatchUpMargin = 100
+
+// Event as it's stored in the outbox
+type fanoutMessage struct {
+	Origin    string          `json:"origin"`
+	Type      string          `json:"type"`
+	Timestamp int64           `json:"timestamp"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Legacy    string          `json:"legacy,omitempty"`
+	Target    eventTarget     `json:"target"`
+}
+
+// Shares the events between the instances of the backend through Postgres.
+// Every event is written into the sseOutbox table and its id is sent with NOTIFY,
+// the other instances LISTEN, read the event and send it to their clients.
+// The events are written in the background, the requests only queue them
+type pgFanout struct {
+	server *sseServer
+	// Encoded events that wait to be written
+	queue chan []byte
+	// Writes a batch of the encoded events, writeOutbox unless it's replaced by the tests
+	write func(batch [][]byte) error
+	// Ids of the recently received events, the oldest first
+	seen      map[int64]struct{}
+	seenOrder []int64
+	// Largest received id
+	lastId int64
+	mutex  sync.Mutex
+}
+
+// Starts sharing the events with the other instances that use the same database.
+// Must be called before the clients connect
+func (a *sseServer) StartFanout() {
+	a.fanout = newPgFanout(a, writeOutbox)
+
+	go a.fanout.listen()
+	go a.fanout.cleanOutbox()
+}
+
+func newPgFanout(server *sseServer, write func(batch [][]byte) error) *pgFanout {
+	fanout := &pgFanout{
+		server:    server,
+		queue:     make(chan []byte, fanoutQueueSize),
+		write:     write,
+		seen:      map[int64]struct{}{},
+		seenOrder: []int64{},
+	}
+
+	go fanout.runPublisher()
+	return fanout
+}
+
+func encodeFanoutMessage(origin string, event Event, target eventTarget) ([]byte, error) {
+	message := fanoutMessage{
+		Origin:    origin,
+		Type:      event.Type,
+		Timestamp: time.Now().UnixMilli(),
+		Legacy:    event.legacyData,
+		Target:    target,
+	}
+
+	if event.Payload != nil {
+		payload, err := json.Marshal(event.Payload)
+		if err != nil {
+			return nil, err
+		}
+		message.Payload = payload
+	}
+
+	return json.Marshal(message)
+}
+
+// Queues the event for the other instances. It doesn't wait for the database,
+// the event is dropped with errFanoutQueueFull if the queue is full
+func (f *pgFanout) publish(event Event, target eventTarget) error {
+	data, err := encodeFanoutMessage(f.server.instance, event, target)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case f.queue <- data:
+		return nil
+	default:
+		return errFanoutQueueFull
+	}
+}
+
+// Writes the queued events into the outbox, the events that are queued
+// while a batch is written go into the next batch together
+func (f *pgFanout) runPublisher() {
+	for data := range f.queue {
+		batch := [][]byte{data}
+
+	collect:
+		for len(batch) < fanoutBatchSize {
+			select {
+			case data := <-f.queue:
+				batch = append(batch, data)
+			default:
+				break collect
+			}
+		}
+
+		if err := f.write(batch); err != nil {
+			log.Printf("SSE fanout: %d events are lost: %v", len(batch), err)
+		}
+	}
+}
+
+// Inserts the events into the outbox in order and notifies the other instances
+func writeOutbox(batch [][]byte) error {
+	payloads := make([]string, len(batch))
+	for i, data := range batch {
+		payloads[i] = string(data)
+	}
+
+	ctx, cancel := withQueryTimeout(context.Background())
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer con.Release()
+
+	_, err = con.Exec(
+		ctx,
+		`WITH e AS (INSERT INTO sseOutbox(payload) SELECT p FROM unnest($1::text[]) WITH ORDINALITY AS t(p, n) ORDER BY n RETURNING id)
+		SELECT pg_notify($2, id::text) FROM e`,
+		payloads, fanoutChannel,
+	)
+	return err
+}
+
+// Sends the event of another instance to the local clients,
+// unless it was sent by this instance or is already received
+func (f *pgFanout) receive(id int64, data []byte) error {
+	f.mutex.Lock()
+	if _, ok := f.seen[id]; ok {
+		f.mutex.Unlock()
+		return nil
+	}
+
+	f.seen[id] = struct{}{}
+	f.seenOrder = append(f.seenOrder, id)
+	if len(f.seenOrder) > fanoutSeenSize {
+		delete(f.seen, f.seenOrder[0])
+		f.seenOrder = f.seenOrder[1:]
+	}
+	f.lastId = max(f.lastId, id)
+	f.mutex.Unlock()
+
+	var message fanoutMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return err
+	}
+
+	if message.Origin == f.server.instance {
+		return nil
+	}
+
+	event := Event{
+		Type:       message.Type,
+		Timestamp:  JSONTime(time.UnixMilli(message.Timestamp)),
+		legacyData: message.Legacy,
+	}
+	if len(message.Payload) > 0 {
+		event.Payload = message.Payload
+	}
+
+	return f.server.sendTo(event, message.Target)
+}
+
+// Listens for the events and reconnects when the connection is lost
+func (f *pgFanout) listen() {
+	backoff := time.Second
+
+	for {
+		connected, err := f.listenOnce()
+		if connected {
+			backoff = time.Second
+		}
+		log.Println("SSE fanout:", err)
+
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// Returns whether the connection was set up before the error
+func (f *pgFanout) listenOnce() (bool, error) {
+	// The connection listens as long as it's open, without a timeout
+	ctx := context.Background()
+
+	pooled, err := db.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// The listening connection can't go back to the pool
+	con := pooled.Hijack()
+	defer con.Close(ctx)
+
+	if _, err := con.Exec(ctx, "LISTEN "+fanoutChannel); err != nil {
+		return false, err
+	}
+
+	f.mutex.Lock()
+	lastId := f.lastId
+	f.mutex.Unlock()
+
+	// On the first connection only the new events are received
+	if lastId == 0 {
+		if err := con.QueryRow(ctx, "SELECT COALESCE(MAX(id), 0) FROM sseOutbox").Scan(&lastId); err != nil {
+			return false, err
+		}
+
+		f.mutex.Lock()
+		f.lastId = max(f.lastId, lastId)
+		f.mutex.Unlock()
+	} else {
+		lastId -= fanoutCatchUpMargin
+	}
+
+	// The events that were sent while the instance wasn't listening
+	rows, err := con.Query(ctx, "SELECT id, payload FROM sseOutbox WHERE id > $1 ORDER BY id", lastId)
+	if err != nil {
+		return false, err
+	}
+
+	missed := map[int64][]byte{}
+	order := []int64{}
+	for rows.Next() {
+		var id int64
+		var payload string
+		if err := rows.Scan(&id, &payload); err != nil {
+			rows.Close()
+			return false, err
+		}
+
+		missed[id] = []byte(payload)
+		order = append(order, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	for _, id := range order {
+		if err := f.receive(id, missed[id]); err != nil {
+			log.Println("SSE fanout:", err)
+		}
+	}
+
+	for {
+		notification, err := con.WaitForNotification(ctx)
+		if err != nil {
+			return true, err
+		}
+
+		id, err := strconv.ParseInt(notification.Payload, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		var payload string
+		if err := con.QueryRow(ctx, "SELECT payload FROM sseOutbox WHERE id=$1", id).Scan(&payload); err != nil {
+			log.Println("SSE fanout:", err)
+			continue
+		}
+
+		if err := f.receive(id, []byte(payload)); err != nil {
+			log.Println("SSE fanout:", err)
+		}
+	}
+}
+
+// Periodically deletes the old events from the outbox
+func (f *pgFanout) cleanOutbox() {
+	for {
+		ctx, cancel := withQueryTimeout(context.Background())
+		con, err := acquire(ctx)
+		if err == nil {
+			_, err = con.Exec(ctx, "DELETE FROM sseOutbox WHERE createdAt < NOW() - $1::interval", fanoutRetention)
+			con.Release()
+		}
+		cancel()
+
+		if err != nil {
+			log.Println("SSE fanout:", err)
+		}
+
+		time.Sleep(time.Minute)
+	}
+}
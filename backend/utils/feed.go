@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The feed shows the newest posts first, the posts published at the same time are ordered
+// by the id, as the posts_pubdate_idx index is
+type FeedQuery struct {
+	// NextCursor or PrevCursor of a page, the newest posts if empty
+	Cursor string
+	// The posts newer than the cursor instead of the older ones. Requires a cursor
+	Newer bool
+	Limit uint32
+}
+
+type FeedPage struct {
+	Posts []Post `json:"posts"`
+	// Cursor of the older posts, empty if there are none
+	NextCursor string `json:"nextCursor"`
+	// Cursor of the newer posts. It's set even if there are none yet, so the client can ask for them later
+	PrevCursor string `json:"prevCursor"`
+}
+
+// Position of a post in the feed. The date has the precision of Postgres, the microseconds
+type feedCursor struct {
+	PubDate int64  `json:"t"`
+	ID      string `json:"i"`
+}
+
+func postFeedCursor(post Post) feedCursor {
+	return feedCursor{PubDate: time.Time(post.PubDate).UnixMicro(), ID: post.ID}
+}
+
+// Publication date of the cursor, in UTC like the dates read from the database
+func (c feedCursor) pubDate() time.Time {
+	return time.UnixMicro(c.PubDate).UTC()
+}
+
+// Checks whether the post is after the cursor in the feed, i.e. older
+func (c feedCursor) before(post Post) bool {
+	pubDate := time.Time(post.PubDate).UnixMicro()
+	return pubDate < c.PubDate || pubDate == c.PubDate && post.ID > c.ID
+}
+
+func (c feedCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Decodes the cursor of the query, nil if the query has no cursor
+func decodeFeedCursor(query FeedQuery) (*feedCursor, error) {
+	if query.Cursor == "" {
+		if query.Newer {
+			return nil, ErrInvalidCursor
+		}
+
+		return nil, nil
+	}
+
+	return decodeCursor(query.Cursor)
+}
+
+// Decodes a cursor made by encode
+func decodeCursor(encoded string) (*feedCursor, error) {
+	cursor := &feedCursor{}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || json.Unmarshal(data, cursor) != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if _, err := uuid.Parse(cursor.ID); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+// Makes a page of up to query.Limit+1 posts read in the order of the query:
+// the newest first, or the oldest first for the newer posts. The extra post tells there are more
+func newFeedPage(posts []Post, query FeedQuery) FeedPage {
+	more := uint32(len(posts)) > query.Limit
+	if more {
+		posts = posts[:query.Limit]
+	}
+
+	if query.Newer {
+		slices.Reverse(posts)
+	}
+
+	page := FeedPage{Posts: posts}
+	if len(posts) == 0 {
+		// Nothing is newer than the cursor yet, or nothing is older
+		if query.Newer {
+			page.NextCursor = query.Cursor
+		}
+		page.PrevCursor = query.Cursor
+
+		return page
+	}
+
+	page.PrevCursor = postFeedCursor(posts[0]).encode()
+	// The posts before the newer ones are the ones the client already has
+	if more || query.Newer {
+		page.NextCursor = postFeedCursor(posts[len(posts)-1]).encode()
+	}
+
+	return page
+}
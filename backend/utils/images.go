@@ -0,0 +1,51 @@
+package utils
+
+import "context"
+
+// Image name with the post that refers to it. PostID is empty for the pending uploads
+type ImageReference struct {
+	Name   string
+	PostID string
+}
+
+// Returns every image that is attached to a post or a post revision, or is a pending upload
+func GetImageReferences(ctx context.Context) ([]ImageReference, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return []ImageReference{}, err
+	}
+	defer con.Release()
+
+	rows, err := con.Query(ctx, `
+SELECT id::text, COALESCE(attachedImages, '') FROM posts
+UNION ALL
+SELECT postId::text, COALESCE(attachedImages, '') FROM postRevisions
+UNION ALL
+SELECT '', name FROM uploads
+`)
+	if err != nil {
+		return []ImageReference{}, err
+	}
+
+	defer rows.Close()
+
+	references := []ImageReference{}
+	for rows.Next() {
+		var postId, attachedImgs string
+		if err := rows.Scan(&postId, &attachedImgs); err != nil {
+			return []ImageReference{}, err
+		}
+
+		for _, img := range splitImages(attachedImgs) {
+			references = append(references, ImageReference{
+				Name:   img,
+				PostID: postId,
+			})
+		}
+	}
+
+	return references, rows.Err()
+}
@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Connects to the database of TEST_DB_ADDRESS and migrates it, the test is skipped without it.
+// The database is changed by the tests, don't point it to real data
+func testDB(t *testing.T) {
+	address := os.Getenv("TEST_DB_ADDRESS")
+	if address == "" {
+		t.Skip("TEST_DB_ADDRESS isn't set")
+	}
+
+	if db == nil {
+		cs := NewCacheStorage()
+		DBADDRESS = address
+		if err := InitDB(&cs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLikesConcurrency(t *testing.T) {
+	ctx := context.Background()
+
+	testDB(t)
+
+	post, err := AddPost(ctx, Post{UserID: "author", UserDisplayName: "Author", Content: "likes"}, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every user likes the post several times at once
+	const users = 50
+	const repeats = 4
+
+	run := func(change func(ctx context.Context, userId string, postId string) (uint64, error)) {
+		var wg sync.WaitGroup
+		for i := 0; i < users*repeats; i++ {
+			wg.Add(1)
+			go func(userId string) {
+				defer wg.Done()
+				if _, err := change(ctx, userId, post.ID); err != nil {
+					t.Error(err)
+				}
+			}("user" + strconv.Itoa(i%users))
+		}
+		wg.Wait()
+	}
+
+	expectLikes := func(expected uint64) {
+		t.Helper()
+
+		likes, err := GetPostLikes(ctx, post.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var rows uint64
+		if err := db.QueryRow(ctx, "SELECT COUNT(1) FROM likes WHERE postId=$1", post.ID).Scan(&rows); err != nil {
+			t.Fatal(err)
+		}
+
+		if likes != expected || rows != expected {
+			t.Errorf("the counter is %d and there are %d likes, expected %d", likes, rows, expected)
+		}
+	}
+
+	run(AddLike)
+	expectLikes(users)
+
+	run(RemoveLike)
+	expectLikes(0)
+
+	if _, err := AddLike(ctx, "user", post.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DeletePostAdmin(ctx, post.ID, "admin", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PurgeTrash(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The likes are deleted together with the post when it's purged
+	expectLikes(0)
+}
+
+func TestReactions(t *testing.T) {
+	ctx := context.Background()
+
+	testDB(t)
+
+	post, err := AddPost(ctx, Post{UserID: "author", UserDisplayName: "Author", Content: "reactions"}, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeletePostAdmin(ctx, post.ID, "admin", "")
+
+	if err := SetReactionType(ctx, ReactionType{Emoji: "🎉", Position: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := AddReaction(ctx, "user", post.ID, "🦀"); err != ErrUnknownReaction {
+		t.Errorf("adding an unknown reaction returned %v, expected ErrUnknownReaction", err)
+	}
+
+	for _, userId := range []string{"user", "other"} {
+		if _, err := AddReaction(ctx, userId, post.ID, "🎉"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	likes, err := AddLike(ctx, "user", post.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if likes != 1 {
+		t.Errorf("%d likes, expected 1", likes)
+	}
+
+	reactions, err := GetPostReactions(ctx, post.ID, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reactions.Counts["🎉"] != 2 || reactions.Counts[DefaultReaction] != 1 || len(reactions.Mine) != 2 {
+		t.Errorf("unexpected reactions %+v", reactions)
+	}
+
+	if err := DeleteReactionType(ctx, DefaultReaction); err != ErrForbidden {
+		t.Errorf("deleting the default reaction returned %v, expected ErrForbidden", err)
+	}
+
+	// The reactions are deleted together with their type
+	if err := DeleteReactionType(ctx, "🎉"); err != nil {
+		t.Fatal(err)
+	}
+
+	reactions, err = GetPostReactions(ctx, post.ID, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reactions.Counts) != 1 || len(reactions.Mine) != 1 {
+		t.Errorf("unexpected reactions %+v after the type is deleted", reactions)
+	}
+}
+
+func TestGetPostsMeta(t *testing.T) {
+	ctx := context.Background()
+
+	testDB(t)
+
+	liked, err := AddPost(ctx, Post{UserID: "author", Content: "liked"}, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeletePostAdmin(ctx, liked.ID, "admin", "")
+
+	other, err := AddPost(ctx, Post{UserID: "author", Content: "other"}, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeletePostAdmin(ctx, other.ID, "admin", "")
+
+	if _, err := AddLike(ctx, "user", liked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := "00000000-0000-0000-0000-000000000000"
+	metas, err := GetPostsMeta(ctx, []string{liked.ID, other.ID, missing}, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]PostMeta{
+		liked.ID: {Content: "liked", Likes: 1, Liked: true},
+		other.ID: {Content: "other"},
+	}
+	if len(metas) != len(expected) || metas[liked.ID] != expected[liked.ID] || metas[other.ID] != expected[other.ID] {
+		t.Errorf("unexpected metas %+v", metas)
+	}
+}
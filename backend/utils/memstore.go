@@ -0,0 +1,948 @@
+package utils
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Store that keeps everything in memory, for the tests of the handlers.
+// It behaves as PgStore except for the search, which matches the words as they are
+type MemoryStore struct {
+	// Ordered by the publication date, the newest last
+	posts []Post
+	// The users that reacted to the posts, by the post and the reaction
+	reactions     map[string]map[string][]string
+	reactionTypes map[string]int32
+	uploads       map[string]memoryUpload
+	admins        map[string]bool
+	blacklist     map[string]bool
+	// Ordered by the publication date, the newest last
+	comments []Comment
+	boards   []Board
+	// Ordered by the edit date, the newest last
+	revisions []PostRevision
+	mutex     sync.Mutex
+}
+
+type memoryUpload struct {
+	userId     string
+	uploadDate time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		posts:         []Post{},
+		reactions:     map[string]map[string][]string{},
+		reactionTypes: map[string]int32{DefaultReaction: 0},
+		uploads:       map[string]memoryUpload{},
+		admins:        map[string]bool{},
+		blacklist:     map[string]bool{},
+		comments:      []Comment{},
+		boards:        []Board{},
+		revisions:     []PostRevision{},
+	}
+}
+
+func (s *MemoryStore) AddAdmin(email string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.admins[email] = true
+}
+
+func (s *MemoryStore) AddToBlacklist(email string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.blacklist[email] = true
+}
+
+// Finds a post that isn't in the trash. Must be called with the mutex locked
+func (s *MemoryStore) find(postId string) (int, *Post) {
+	return s.findDeleted(postId, false)
+}
+
+// Finds a post in the trash if deleted is true, or a post that isn't in it.
+// Must be called with the mutex locked
+func (s *MemoryStore) findDeleted(postId string, deleted bool) (int, *Post) {
+	for i := range s.posts {
+		if s.posts[i].ID == postId && (s.posts[i].Deletion != nil) == deleted {
+			return i, &s.posts[i]
+		}
+	}
+
+	return -1, nil
+}
+
+// The metadata of the post, as returned by the post lists
+func postMetadata(post Post) Post {
+	return Post{
+		ID:              post.ID,
+		UserID:          post.UserID,
+		UserDisplayName: post.UserDisplayName,
+		PubDate:         post.PubDate,
+		EditDate:        post.EditDate,
+		BoardID:         post.BoardID,
+	}
+}
+
+func (s *MemoryStore) AddPost(_ context.Context, post Post, referencedImages []string) (Post, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// The posts are ordered by the date, so it must be unique. The cursors keep the microseconds
+	pubDate := time.Now().Truncate(time.Microsecond)
+	if len(s.posts) > 0 {
+		if last := time.Time(s.posts[len(s.posts)-1].PubDate); !pubDate.After(last) {
+			pubDate = last.Add(time.Millisecond)
+		}
+	}
+
+	post.ID = uuid.NewString()
+	post.PubDate = JSONTime(pubDate)
+	post.AttachedImages = slices.Clone(post.AttachedImages)
+	for _, name := range referencedImages {
+		if upload, ok := s.uploads[name]; ok && upload.userId == post.UserID {
+			delete(s.uploads, name)
+			post.AttachedImages = append(post.AttachedImages, name)
+		}
+	}
+	s.posts = append(s.posts, post)
+
+	return post, nil
+}
+
+func (s *MemoryStore) DeletePost(_ context.Context, postId string, userId string, reason string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, p := s.find(postId)
+	if p == nil || p.UserID != userId {
+		return pgx.ErrNoRows
+	}
+
+	p.Deletion = &PostDeletion{Date: JSONTime(time.Now()), UserID: userId, Reason: reason}
+	return nil
+}
+
+func (s *MemoryStore) DeletePostAdmin(_ context.Context, postId string, adminId string, reason string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, p := s.find(postId)
+	if p == nil {
+		return "", pgx.ErrNoRows
+	}
+
+	p.Deletion = &PostDeletion{Date: JSONTime(time.Now()), UserID: adminId, Reason: reason}
+	return p.UserID, nil
+}
+
+func (s *MemoryStore) RestorePost(_ context.Context, postId string, userId string, asAdmin bool) (Post, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, p := s.findDeleted(postId, true)
+	if p == nil {
+		return Post{}, pgx.ErrNoRows
+	}
+
+	if !asAdmin && (p.UserID != userId || p.Deletion.UserID != userId) {
+		return Post{}, ErrForbidden
+	}
+
+	p.Deletion = nil
+	return postMetadata(*p), nil
+}
+
+func (s *MemoryStore) PurgeTrash(_ context.Context, maxAge time.Duration) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	images := []string{}
+	for i := len(s.posts) - 1; i >= 0; i-- {
+		if p := s.posts[i]; p.Deletion != nil && time.Since(time.Time(p.Deletion.Date)) > maxAge {
+			images = append(images, p.AttachedImages...)
+			images = append(images, s.delete(i)...)
+		}
+	}
+
+	return images, nil
+}
+
+// Deletes the post at the index together with its reactions, comments and revisions,
+// returns the images of the revisions. Must be called with the mutex locked
+func (s *MemoryStore) delete(i int) []string {
+	postId := s.posts[i].ID
+	delete(s.reactions, postId)
+	s.posts = slices.Delete(s.posts, i, i+1)
+
+	s.comments = slices.DeleteFunc(s.comments, func(comment Comment) bool {
+		return comment.PostID == postId
+	})
+
+	images := []string{}
+	s.revisions = slices.DeleteFunc(s.revisions, func(revision PostRevision) bool {
+		if revision.PostID != postId {
+			return false
+		}
+
+		images = append(images, revision.AttachedImages...)
+		return true
+	})
+
+	return images
+}
+
+func (s *MemoryStore) GetPosts(_ context.Context, query FeedQuery) (FeedPage, error) {
+	return s.feed(query, func(post Post) (Post, bool) {
+		return postMetadata(post), post.Deletion == nil
+	})
+}
+
+func (s *MemoryStore) GetTrash(_ context.Context, userId string, query FeedQuery) (FeedPage, error) {
+	return s.feed(query, func(post Post) (Post, bool) {
+		trashed := postMetadata(post)
+		trashed.Content = post.Content
+		trashed.Deletion = post.Deletion
+
+		return trashed, post.Deletion != nil && (userId == "" || post.UserID == userId)
+	})
+}
+
+// Reads a page of the posts that the filter accepts, the filter also returns
+// the post as the page shows it
+func (s *MemoryStore) feed(query FeedQuery, filter func(post Post) (Post, bool)) (FeedPage, error) {
+	cursor, err := decodeFeedCursor(query)
+	if err != nil {
+		return FeedPage{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// In the order of the feed
+	feed := slices.Clone(s.posts)
+	slices.SortFunc(feed, func(a Post, b Post) int {
+		if c := time.Time(b.PubDate).Compare(time.Time(a.PubDate)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	if query.Newer {
+		slices.Reverse(feed)
+	}
+
+	posts := []Post{}
+	for _, post := range feed {
+		if uint32(len(posts)) > query.Limit {
+			break
+		}
+
+		if cursor != nil && cursor.before(post) == query.Newer {
+			continue
+		}
+		if cursor != nil && query.Newer && postFeedCursor(post) == *cursor {
+			continue
+		}
+
+		if post, ok := filter(post); ok {
+			posts = append(posts, post)
+		}
+	}
+
+	return newFeedPage(posts, query), nil
+}
+
+func (s *MemoryStore) GetPostContent(_ context.Context, postId string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, p := s.find(postId)
+	if p == nil {
+		return "", pgx.ErrNoRows
+	}
+
+	return p.Content, nil
+}
+
+func (s *MemoryStore) GetPostAuthor(_ context.Context, postId string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, p := s.find(postId)
+	if p == nil {
+		return "", pgx.ErrNoRows
+	}
+
+	return p.UserID, nil
+}
+
+func (s *MemoryStore) GetPostsMeta(_ context.Context, postIds []string, userId string) (map[string]PostMeta, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	metas := map[string]PostMeta{}
+	for _, postId := range postIds {
+		_, p := s.find(postId)
+		if p == nil {
+			continue
+		}
+
+		likes := s.reactions[postId][DefaultReaction]
+		metas[postId] = PostMeta{
+			Content: p.Content,
+			Likes:   uint64(len(likes)),
+			Liked:   slices.Contains(likes, userId),
+		}
+	}
+
+	return metas, nil
+}
+
+// Matches the posts that have all words of the query, the rank is the number of the matched words
+func (s *MemoryStore) SearchPosts(_ context.Context, query SearchQuery) ([]SearchResult, string, error) {
+	cursor, err := decodeSearchCursor(query.Cursor)
+	if err != nil {
+		return []SearchResult{}, "", err
+	}
+
+	words := searchWords(query.Query)
+	if len(words) == 0 {
+		return []SearchResult{}, "", nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	type match struct {
+		post    Post
+		rank    float64
+		snippet string
+	}
+
+	matches := []match{}
+	for _, post := range s.posts {
+		pubDate := time.Time(post.PubDate)
+		if post.Deletion != nil ||
+			query.AuthorID != "" && post.UserID != query.AuthorID ||
+			!query.From.IsZero() && pubDate.Before(query.From) ||
+			!query.To.IsZero() && !pubDate.Before(query.To) {
+			continue
+		}
+
+		found := map[string]bool{}
+		rank := 0
+		snippet := []string{}
+		for _, field := range strings.Fields(contentText(post.Content)) {
+			marked := false
+			for _, word := range searchWords(field) {
+				if slices.Contains(words, word) {
+					found[word] = true
+					rank++
+					marked = true
+				}
+			}
+
+			if marked {
+				field = headlineStart + field + headlineStop
+			}
+			snippet = append(snippet, field)
+		}
+
+		if len(found) == len(words) {
+			matches = append(matches, match{postMetadata(post), float64(rank), strings.Join(snippet, " ")})
+		}
+	}
+
+	slices.SortFunc(matches, func(a match, b match) int {
+		if c := cmp.Compare(b.rank, a.rank); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.post.ID, a.post.ID)
+	})
+
+	results := []SearchResult{}
+	var lastRank float64
+	for _, m := range matches {
+		if cursor != nil && (m.rank > cursor.Rank || m.rank == cursor.Rank && m.post.ID >= cursor.ID) {
+			continue
+		}
+		if uint32(len(results)) == query.Limit {
+			break
+		}
+
+		lastRank = m.rank
+		results = append(results, SearchResult{Post: m.post, Rank: float32(m.rank), Snippet: markSnippet(m.snippet)})
+	}
+
+	nextCursor := ""
+	if len(results) > 0 && uint32(len(results)) == query.Limit {
+		nextCursor, err = encodeSearchCursor(lastRank, results[len(results)-1].ID)
+		if err != nil {
+			return []SearchResult{}, "", err
+		}
+	}
+
+	return results, nextCursor, nil
+}
+
+// Lower case words of the text without the punctuation
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func (s *MemoryStore) AddReaction(_ context.Context, userId string, postId string, reaction string) (ReactionCounts, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, p := s.find(postId); p == nil {
+		return ReactionCounts{}, pgx.ErrNoRows
+	}
+
+	if _, ok := s.reactionTypes[reaction]; !ok {
+		return ReactionCounts{}, ErrUnknownReaction
+	}
+
+	if s.reactions[postId] == nil {
+		s.reactions[postId] = map[string][]string{}
+	}
+	if users := s.reactions[postId][reaction]; !slices.Contains(users, userId) {
+		s.reactions[postId][reaction] = append(users, userId)
+	}
+
+	return s.counts(postId), nil
+}
+
+func (s *MemoryStore) RemoveReaction(_ context.Context, userId string, postId string, reaction string) (ReactionCounts, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, p := s.find(postId); p == nil {
+		return ReactionCounts{}, pgx.ErrNoRows
+	}
+
+	if users, ok := s.reactions[postId][reaction]; ok {
+		s.reactions[postId][reaction] = slices.DeleteFunc(users, func(u string) bool {
+			return u == userId
+		})
+	}
+
+	return s.counts(postId), nil
+}
+
+// Must be called with the mutex locked
+func (s *MemoryStore) counts(postId string) ReactionCounts {
+	counts := ReactionCounts{}
+	for reaction, users := range s.reactions[postId] {
+		if len(users) > 0 {
+			counts[reaction] = uint64(len(users))
+		}
+	}
+
+	return counts
+}
+
+func (s *MemoryStore) GetPostReactions(_ context.Context, postId string, userId string) (PostReactions, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	mine := []string{}
+	for reaction, users := range s.reactions[postId] {
+		if slices.Contains(users, userId) {
+			mine = append(mine, reaction)
+		}
+	}
+	slices.Sort(mine)
+
+	return PostReactions{Counts: s.counts(postId), Mine: mine}, nil
+}
+
+func (s *MemoryStore) GetPostLikes(_ context.Context, postId string) (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, p := s.find(postId); p == nil {
+		return 0, nil
+	}
+
+	return uint64(len(s.reactions[postId][DefaultReaction])), nil
+}
+
+func (s *MemoryStore) CheckUserLikedPost(_ context.Context, userId string, postId string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return slices.Contains(s.reactions[postId][DefaultReaction], userId), nil
+}
+
+func (s *MemoryStore) GetReactionTypes(_ context.Context) ([]ReactionType, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	reactionTypes := []ReactionType{}
+	for emoji, position := range s.reactionTypes {
+		reactionTypes = append(reactionTypes, ReactionType{Emoji: emoji, Position: position})
+	}
+
+	slices.SortFunc(reactionTypes, func(a ReactionType, b ReactionType) int {
+		if c := cmp.Compare(a.Position, b.Position); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Emoji, b.Emoji)
+	})
+
+	return reactionTypes, nil
+}
+
+func (s *MemoryStore) SetReactionType(_ context.Context, reactionType ReactionType) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.reactionTypes[reactionType.Emoji] = reactionType.Position
+	return nil
+}
+
+func (s *MemoryStore) DeleteReactionType(_ context.Context, emoji string) error {
+	if emoji == DefaultReaction {
+		return ErrForbidden
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.reactionTypes[emoji]; !ok {
+		return pgx.ErrNoRows
+	}
+
+	delete(s.reactionTypes, emoji)
+	for _, reactions := range s.reactions {
+		delete(reactions, emoji)
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) AddUploads(_ context.Context, names []string, userId string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, name := range names {
+		s.uploads[name] = memoryUpload{userId: userId, uploadDate: time.Now()}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpiredUploads(_ context.Context, maxAge time.Duration) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	names := []string{}
+	for name, upload := range s.uploads {
+		if time.Since(upload.uploadDate) > maxAge {
+			delete(s.uploads, name)
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+func (s *MemoryStore) GetImageReferences(_ context.Context) ([]ImageReference, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	references := []ImageReference{}
+	for _, post := range s.posts {
+		for _, img := range post.AttachedImages {
+			references = append(references, ImageReference{Name: img, PostID: post.ID})
+		}
+	}
+	for _, revision := range s.revisions {
+		for _, img := range revision.AttachedImages {
+			references = append(references, ImageReference{Name: img, PostID: revision.PostID})
+		}
+	}
+	for name := range s.uploads {
+		references = append(references, ImageReference{Name: name})
+	}
+
+	return references, nil
+}
+
+func (s *MemoryStore) AddComment(_ context.Context, comment Comment) (Comment, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, p := s.find(comment.PostID); p == nil {
+		return Comment{}, pgx.ErrNoRows
+	}
+
+	if comment.ParentID != "" {
+		if _, parent := s.findComment(comment.ParentID); parent == nil || parent.PostID != comment.PostID {
+			return Comment{}, pgx.ErrNoRows
+		}
+	}
+
+	// The comments are ordered by the date like the posts, so it must be unique as well
+	pubDate := time.Now().Truncate(time.Microsecond)
+	if len(s.comments) > 0 {
+		if last := time.Time(s.comments[len(s.comments)-1].PubDate); !pubDate.After(last) {
+			pubDate = last.Add(time.Millisecond)
+		}
+	}
+
+	comment.ID = uuid.NewString()
+	comment.PubDate = JSONTime(pubDate)
+	comment.Replies = 0
+	s.comments = append(s.comments, comment)
+
+	return comment, nil
+}
+
+// Must be called with the mutex locked
+func (s *MemoryStore) findComment(commentId string) (int, *Comment) {
+	for i := range s.comments {
+		if s.comments[i].ID == commentId {
+			return i, &s.comments[i]
+		}
+	}
+
+	return -1, nil
+}
+
+func (s *MemoryStore) DeleteComment(_ context.Context, commentId string, userId string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, comment := s.findComment(commentId)
+	if comment == nil || comment.UserID != userId {
+		return "", pgx.ErrNoRows
+	}
+
+	postId := comment.PostID
+	s.deleteComment(commentId)
+
+	return postId, nil
+}
+
+func (s *MemoryStore) DeleteCommentAdmin(_ context.Context, commentId string) (string, string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, comment := s.findComment(commentId)
+	if comment == nil {
+		return "", "", pgx.ErrNoRows
+	}
+
+	postId, authorId := comment.PostID, comment.UserID
+	s.deleteComment(commentId)
+
+	return postId, authorId, nil
+}
+
+// Deletes the comment and the replies to it. Must be called with the mutex locked
+func (s *MemoryStore) deleteComment(commentId string) {
+	deleted := []string{commentId}
+	for len(deleted) > 0 {
+		parentId := deleted[0]
+		deleted = deleted[1:]
+
+		s.comments = slices.DeleteFunc(s.comments, func(comment Comment) bool {
+			if comment.ParentID == parentId {
+				deleted = append(deleted, comment.ID)
+			}
+			return comment.ID == parentId || comment.ParentID == parentId
+		})
+	}
+}
+
+func (s *MemoryStore) GetCommentAuthor(_ context.Context, commentId string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, comment := s.findComment(commentId)
+	if comment == nil {
+		return "", pgx.ErrNoRows
+	}
+
+	return comment.UserID, nil
+}
+
+func (s *MemoryStore) GetComments(_ context.Context, postId string, parentId string, cursor string, count uint32) (CommentPage, error) {
+	var after *feedCursor
+	if cursor != "" {
+		var err error
+		if after, err = decodeCursor(cursor); err != nil {
+			return CommentPage{}, err
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	comments := []Comment{}
+	for _, comment := range s.comments {
+		if uint32(len(comments)) > count {
+			break
+		}
+
+		if comment.PostID != postId || comment.ParentID != parentId {
+			continue
+		}
+		if position := commentCursor(comment); after != nil &&
+			(position.PubDate < after.PubDate || position.PubDate == after.PubDate && position.ID <= after.ID) {
+			continue
+		}
+
+		comment.UserEmail = ""
+		for _, reply := range s.comments {
+			if reply.ParentID == comment.ID {
+				comment.Replies++
+			}
+		}
+		comments = append(comments, comment)
+	}
+
+	return newCommentPage(comments, count), nil
+}
+
+func (s *MemoryStore) GetBoards(_ context.Context) ([]Board, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	boards := slices.Clone(s.boards)
+	slices.SortFunc(boards, func(a Board, b Board) int {
+		if c := cmp.Compare(a.Position, b.Position); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	return boards, nil
+}
+
+// Must be called with the mutex locked
+func (s *MemoryStore) findBoard(match func(board Board) bool) (int, *Board) {
+	for i := range s.boards {
+		if match(s.boards[i]) {
+			return i, &s.boards[i]
+		}
+	}
+
+	return -1, nil
+}
+
+func (s *MemoryStore) GetBoard(_ context.Context, boardId string) (Board, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, board := s.findBoard(func(board Board) bool { return board.ID == boardId })
+	if board == nil {
+		return Board{}, pgx.ErrNoRows
+	}
+
+	return *board, nil
+}
+
+func (s *MemoryStore) AddBoard(_ context.Context, board Board) (Board, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, taken := s.findBoard(func(b Board) bool { return b.Slug == board.Slug }); taken != nil {
+		return Board{}, ErrBoardSlugTaken
+	}
+
+	board.ID = uuid.NewString()
+	s.boards = append(s.boards, board)
+
+	return board, nil
+}
+
+func (s *MemoryStore) UpdateBoard(_ context.Context, board Board) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, current := s.findBoard(func(b Board) bool { return b.ID == board.ID })
+	if current == nil {
+		return pgx.ErrNoRows
+	}
+
+	if _, taken := s.findBoard(func(b Board) bool { return b.Slug == board.Slug && b.ID != board.ID }); taken != nil {
+		return ErrBoardSlugTaken
+	}
+
+	*current = board
+	return nil
+}
+
+func (s *MemoryStore) DeleteBoard(_ context.Context, boardId string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i, board := s.findBoard(func(board Board) bool { return board.ID == boardId })
+	if board == nil {
+		return pgx.ErrNoRows
+	}
+
+	s.boards = slices.Delete(s.boards, i, i+1)
+	for i := range s.posts {
+		if s.posts[i].BoardID == boardId {
+			s.posts[i].BoardID = ""
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) GetBoardPosts(_ context.Context, boardSlug string, query FeedQuery) (FeedPage, error) {
+	s.mutex.Lock()
+	_, board := s.findBoard(func(board Board) bool { return board.Slug == boardSlug })
+	boardId := ""
+	if board != nil {
+		boardId = board.ID
+	}
+	s.mutex.Unlock()
+
+	return s.feed(query, func(post Post) (Post, bool) {
+		return postMetadata(post), boardId != "" && post.BoardID == boardId && post.Deletion == nil
+	})
+}
+
+func (s *MemoryStore) EditPost(_ context.Context, postId string, edit PostEdit, asAdmin bool) (Post, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, err := s.findForEdit(postId, edit.EditorID, asAdmin)
+	if err != nil {
+		return Post{}, err
+	}
+
+	knownImages := slices.Clone(p.AttachedImages)
+	for _, revision := range s.revisions {
+		if revision.PostID == postId {
+			knownImages = append(knownImages, revision.AttachedImages...)
+		}
+	}
+	for _, name := range edit.ReferencedImages {
+		if upload, ok := s.uploads[name]; ok && upload.userId == edit.EditorID {
+			delete(s.uploads, name)
+			knownImages = append(knownImages, name)
+		}
+	}
+
+	attachedImages := slices.Clone(edit.AttachedImages)
+	for _, img := range edit.ReferencedImages {
+		if slices.Contains(knownImages, img) && !slices.Contains(attachedImages, img) {
+			attachedImages = append(attachedImages, img)
+		}
+	}
+	edit.AttachedImages = attachedImages
+
+	s.archiveAndReplace(p, edit)
+
+	return postMetadata(*p), nil
+}
+
+func (s *MemoryStore) RestorePostRevision(_ context.Context, postId string, revisionId string, editorId string, editorDisplayName string, asAdmin bool) (Post, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, err := s.findForEdit(postId, editorId, asAdmin)
+	if err != nil {
+		return Post{}, err
+	}
+
+	i := slices.IndexFunc(s.revisions, func(revision PostRevision) bool {
+		return revision.ID == revisionId && revision.PostID == postId
+	})
+	if i == -1 {
+		return Post{}, pgx.ErrNoRows
+	}
+
+	s.archiveAndReplace(p, PostEdit{
+		EditorID:          editorId,
+		EditorDisplayName: editorDisplayName,
+		Content:           s.revisions[i].Content,
+		AttachedImages:    slices.Clone(s.revisions[i].AttachedImages),
+	})
+
+	return postMetadata(*p), nil
+}
+
+// Finds a post the editor is allowed to edit. Must be called with the mutex locked
+func (s *MemoryStore) findForEdit(postId string, editorId string, asAdmin bool) (*Post, error) {
+	_, p := s.find(postId)
+	if p == nil {
+		return nil, pgx.ErrNoRows
+	}
+
+	if !asAdmin && p.UserID != editorId {
+		return nil, ErrForbidden
+	}
+
+	return p, nil
+}
+
+// Saves the current version of the post as a revision and replaces it with the edit.
+// Must be called with the mutex locked
+func (s *MemoryStore) archiveAndReplace(p *Post, edit PostEdit) {
+	editDate := JSONTime(time.Now().Truncate(time.Microsecond))
+
+	s.revisions = append(s.revisions, PostRevision{
+		ID:                uuid.NewString(),
+		PostID:            p.ID,
+		EditorID:          edit.EditorID,
+		EditorDisplayName: edit.EditorDisplayName,
+		EditDate:          editDate,
+		Content:           p.Content,
+		AttachedImages:    p.AttachedImages,
+	})
+
+	p.Content = edit.Content
+	p.AttachedImages = edit.AttachedImages
+	p.EditDate = &editDate
+	if edit.Language != "" {
+		p.Language = edit.Language
+	}
+}
+
+func (s *MemoryStore) GetPostRevisions(_ context.Context, postId string) ([]PostRevision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	revisions := []PostRevision{}
+	for i := len(s.revisions) - 1; i >= 0; i-- {
+		if s.revisions[i].PostID == postId {
+			revisions = append(revisions, s.revisions[i])
+		}
+	}
+
+	return revisions, nil
+}
+
+func (s *MemoryStore) IsAdmin(_ context.Context, email string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.admins[email]
+}
+
+func (s *MemoryStore) IsInBlacklist(_ context.Context, email string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.blacklist[email]
+}
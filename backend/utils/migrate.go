@@ -0,0 +1,305 @@
+package utils
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// The migrations run before the server starts and aren't limited by DB_QUERY_TIMEOUT
+var migrationCtx = context.Background()
+
+// Key of the advisory lock held while the migrations run,
+// so the instances that start together don't apply them twice
+const migrationsLockKey = 7261504193
+
+// 0001_name.up.sql or 0001_name.down.sql
+var migrationFileRegexp = regexp.MustCompile(`^([0-9]+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationState struct {
+	Migration
+	// Nil if the migration isn't applied
+	AppliedAt *JSONTime
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Reads the migrations from the directory, ordered by the version. Every migration must have
+// an up and a down file and the versions must go one by one from 1
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return []Migration{}, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFileRegexp.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return []Migration{}, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return []Migration{}, fmt.Errorf("migration %d has two names, %s and %s", version, migration.Name, match[2])
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return []Migration{}, err
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for version := 1; version <= len(byVersion); version++ {
+		migration, ok := byVersion[version]
+		if !ok {
+			return []Migration{}, fmt.Errorf("migration %d is missing", version)
+		}
+		if migration.Up == "" || migration.Down == "" {
+			return []Migration{}, fmt.Errorf("migration %s must have an up and a down file", migration)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	return migrations, nil
+}
+
+// Database the migrations are applied to
+type migrationTarget interface {
+	// Creates the table of the applied migrations and returns the versions it has
+	appliedMigrations() (map[int]time.Time, error)
+	// Runs a migration in a transaction together with the change of the applied migrations
+	runMigration(migration Migration, up bool) error
+}
+
+// Postgres connection that holds the migrations lock
+type pgMigrationTarget struct {
+	con *pgxpool.Conn
+}
+
+// Acquires a connection that holds the migrations lock
+// and returns the known migrations with their state
+func lockMigrations() (pgMigrationTarget, []MigrationState, error) {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		return pgMigrationTarget{}, nil, err
+	}
+
+	con, err := db.Acquire(migrationCtx)
+	if err != nil {
+		return pgMigrationTarget{}, nil, err
+	}
+
+	// Session level lock, it's released by unlockMigrations
+	if _, err := con.Exec(migrationCtx, "SELECT pg_advisory_lock($1)", migrationsLockKey); err != nil {
+		con.Release()
+		return pgMigrationTarget{}, nil, err
+	}
+
+	target := pgMigrationTarget{con: con}
+	states, err := migrationStates(target, migrations)
+	if err != nil {
+		unlockMigrations(target)
+		return pgMigrationTarget{}, nil, err
+	}
+
+	return target, states, nil
+}
+
+func unlockMigrations(target pgMigrationTarget) {
+	target.con.Exec(migrationCtx, "SELECT pg_advisory_unlock($1)", migrationsLockKey)
+	target.con.Release()
+}
+
+func (t pgMigrationTarget) appliedMigrations() (map[int]time.Time, error) {
+	_, err := t.con.Exec(migrationCtx, `CREATE TABLE IF NOT EXISTS schema_migrations(
+	version integer PRIMARY KEY,
+	name text NOT NULL,
+	appliedAt timestamp without time zone NOT NULL DEFAULT NOW()
+)`)
+	if err != nil {
+		return map[int]time.Time{}, err
+	}
+
+	rows, err := t.con.Query(migrationCtx, "SELECT version, appliedAt FROM schema_migrations")
+	if err != nil {
+		return map[int]time.Time{}, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return map[int]time.Time{}, err
+		}
+
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+func (t pgMigrationTarget) runMigration(migration Migration, up bool) error {
+	tx, err := t.con.Begin(migrationCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(migrationCtx)
+
+	query := migration.Down
+	if up {
+		query = migration.Up
+	}
+
+	// Without the arguments the script is sent as a simple query, which may have several statements
+	if _, err := tx.Exec(migrationCtx, query); err != nil {
+		return fmt.Errorf("migration %s: %w", migration, err)
+	}
+
+	if up {
+		_, err = tx.Exec(migrationCtx, "INSERT INTO schema_migrations(version, name) VALUES($1, $2)", migration.Version, migration.Name)
+	} else {
+		_, err = tx.Exec(migrationCtx, "DELETE FROM schema_migrations WHERE version=$1", migration.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("migration %s: %w", migration, err)
+	}
+
+	return tx.Commit(migrationCtx)
+}
+
+func migrationStates(target migrationTarget, migrations []Migration) ([]MigrationState, error) {
+	applied, err := target.appliedMigrations()
+	if err != nil {
+		return []MigrationState{}, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, migration := range migrations {
+		state := MigrationState{Migration: migration}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			jsonTime := JSONTime(appliedAt)
+			state.AppliedAt = &jsonTime
+			delete(applied, migration.Version)
+		}
+
+		states = append(states, state)
+	}
+
+	// The database was migrated by a newer version of the backend
+	if len(applied) > 0 {
+		unknown := []int{}
+		for version := range applied {
+			unknown = append(unknown, version)
+		}
+		slices.Sort(unknown)
+
+		return []MigrationState{}, fmt.Errorf("the database has unknown migrations %v", unknown)
+	}
+
+	return states, nil
+}
+
+func migrateUp(target migrationTarget, states []MigrationState) ([]Migration, error) {
+	applied := []Migration{}
+	for _, state := range states {
+		if state.AppliedAt != nil {
+			continue
+		}
+
+		if err := target.runMigration(state.Migration, true); err != nil {
+			return applied, err
+		}
+		applied = append(applied, state.Migration)
+	}
+
+	return applied, nil
+}
+
+func migrateDown(target migrationTarget, states []MigrationState, steps int) ([]Migration, error) {
+	if steps < 1 {
+		return []Migration{}, errors.New("at least one migration has to be reverted")
+	}
+
+	reverted := []Migration{}
+	for i := len(states) - 1; i >= 0 && len(reverted) < steps; i-- {
+		if states[i].AppliedAt == nil {
+			continue
+		}
+
+		if err := target.runMigration(states[i].Migration, false); err != nil {
+			return reverted, err
+		}
+		reverted = append(reverted, states[i].Migration)
+	}
+
+	return reverted, nil
+}
+
+// Returns the known migrations and whether they are applied
+func MigrationStatus() ([]MigrationState, error) {
+	target, states, err := lockMigrations()
+	if err != nil {
+		return []MigrationState{}, err
+	}
+	defer unlockMigrations(target)
+
+	return states, nil
+}
+
+// Applies the migrations that aren't applied yet and returns them
+func MigrateUp() ([]Migration, error) {
+	target, states, err := lockMigrations()
+	if err != nil {
+		return []Migration{}, err
+	}
+	defer unlockMigrations(target)
+
+	return migrateUp(target, states)
+}
+
+// Reverts up to steps of the last applied migrations and returns them
+func MigrateDown(steps int) ([]Migration, error) {
+	target, states, err := lockMigrations()
+	if err != nil {
+		return []Migration{}, err
+	}
+	defer unlockMigrations(target)
+
+	return migrateDown(target, states, steps)
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("migration %s is at the position %d", migration, i)
+		}
+	}
+
+	file := &fstest.MapFile{Data: []byte("SELECT 1;")}
+	cases := map[string]fstest.MapFS{
+		"missing down file": {
+			"m/0001_a.up.sql": file,
+		},
+		"missing version": {
+			"m/0001_a.up.sql": file, "m/0001_a.down.sql": file,
+			"m/0003_c.up.sql": file, "m/0003_c.down.sql": file,
+		},
+		"two names": {
+			"m/0001_a.up.sql": file, "m/0001_b.down.sql": file,
+		},
+		"invalid name": {
+			"m/0001_a.up.sql": file, "m/0001_a.down.sql": file, "m/readme.md": file,
+		},
+	}
+
+	for name, fsys := range cases {
+		if _, err := loadMigrations(fsys, "m"); err == nil {
+			t.Errorf("loaded the migrations with a %s", name)
+		}
+	}
+
+	migrations, err = loadMigrations(fstest.MapFS{
+		"m/0002_b.up.sql": file, "m/0002_b.down.sql": file,
+		"m/0001_a.up.sql": file, "m/0001_a.down.sql": file,
+	}, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].String() != "0001_a" || migrations[1].String() != "0002_b" {
+		t.Errorf("unexpected migrations %v", migrations)
+	}
+}
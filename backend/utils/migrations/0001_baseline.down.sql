@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS sseOutbox;
+DROP TABLE IF EXISTS uploads;
+DROP TABLE IF EXISTS comments;
+DROP TABLE IF EXISTS postRevisions;
+DROP TABLE IF EXISTS likes;
+DROP TABLE IF EXISTS posts;
+DROP TABLE IF EXISTS boards;
+DROP TABLE IF EXISTS admins;
+DROP TABLE IF EXISTS blacklist;
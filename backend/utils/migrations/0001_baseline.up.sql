@@ -0,0 +1,65 @@
+-- Schema of the deployments created before the migrations, so every statement is idempotent
+CREATE TABLE IF NOT EXISTS blacklist(
+	gmail text PRIMARY KEY
+);
+CREATE TABLE IF NOT EXISTS admins(
+	gmail text PRIMARY KEY
+);
+CREATE TABLE IF NOT EXISTS posts(
+	id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+	userId text,
+	userEmail text,
+	userDisplayName text,
+	content text,
+	pubDate timestamp without time zone DEFAULT NOW(),
+	attachedImages text
+);
+CREATE TABLE IF NOT EXISTS likes(
+	userId text,
+	postId text
+);
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS editDate timestamp without time zone;
+CREATE TABLE IF NOT EXISTS postRevisions(
+	id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+	postId uuid,
+	editorId text,
+	editorDisplayName text,
+	content text,
+	attachedImages text,
+	editDate timestamp without time zone DEFAULT NOW()
+);
+CREATE TABLE IF NOT EXISTS comments(
+	id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+	postId uuid REFERENCES posts(id) ON DELETE CASCADE,
+	parentId uuid REFERENCES comments(id) ON DELETE CASCADE,
+	userId text,
+	userEmail text,
+	userDisplayName text,
+	content text,
+	pubDate timestamp without time zone DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS comments_thread_idx ON comments(postId, parentId, pubDate, id);
+CREATE TABLE IF NOT EXISTS boards(
+	id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+	name text NOT NULL,
+	slug text NOT NULL UNIQUE,
+	description text NOT NULL DEFAULT '',
+	position integer NOT NULL DEFAULT 0
+);
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS boardId uuid REFERENCES boards(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS posts_board_idx ON posts(boardId, pubDate DESC);
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS searchConfig regconfig NOT NULL DEFAULT 'simple';
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS searchText text NOT NULL DEFAULT '';
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS searchVector tsvector GENERATED ALWAYS AS (to_tsvector(searchConfig, searchText)) STORED;
+CREATE INDEX IF NOT EXISTS posts_search_idx ON posts USING GIN(searchVector);
+CREATE TABLE IF NOT EXISTS uploads(
+	name text PRIMARY KEY,
+	userId text NOT NULL,
+	uploadDate timestamp without time zone DEFAULT NOW()
+);
+CREATE TABLE IF NOT EXISTS sseOutbox(
+	id bigserial PRIMARY KEY,
+	payload text NOT NULL,
+	createdAt timestamp without time zone DEFAULT NOW()
+);
+UPDATE posts SET searchText=regexp_replace(content, '<[^>]+>', ' ', 'g') WHERE searchText='' AND content IS NOT NULL;
@@ -0,0 +1,12 @@
+ALTER TABLE comments ALTER COLUMN postId DROP NOT NULL;
+
+ALTER TABLE postRevisions DROP CONSTRAINT IF EXISTS postRevisions_post_fkey;
+ALTER TABLE postRevisions ALTER COLUMN postId DROP NOT NULL;
+
+DROP INDEX IF EXISTS sseOutbox_createdAt_idx;
+DROP INDEX IF EXISTS uploads_date_idx;
+DROP INDEX IF EXISTS postRevisions_post_idx;
+DROP INDEX IF EXISTS likes_user_post_idx;
+DROP INDEX IF EXISTS likes_post_idx;
+DROP INDEX IF EXISTS posts_user_idx;
+DROP INDEX IF EXISTS posts_pubdate_idx;
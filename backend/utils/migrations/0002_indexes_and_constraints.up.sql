@@ -0,0 +1,16 @@
+CREATE INDEX IF NOT EXISTS posts_pubdate_idx ON posts(pubDate DESC, id);
+CREATE INDEX IF NOT EXISTS posts_user_idx ON posts(userId);
+CREATE INDEX IF NOT EXISTS likes_post_idx ON likes(postId);
+CREATE INDEX IF NOT EXISTS likes_user_post_idx ON likes(userId, postId);
+CREATE INDEX IF NOT EXISTS postRevisions_post_idx ON postRevisions(postId, editDate DESC);
+CREATE INDEX IF NOT EXISTS uploads_date_idx ON uploads(uploadDate);
+CREATE INDEX IF NOT EXISTS sseOutbox_createdAt_idx ON sseOutbox(createdAt);
+
+-- The revisions are deleted by the code after the post, as their images have to be removed,
+-- so the key is checked at the end of the transaction. Drop the ones left by the failed deletions
+DELETE FROM postRevisions WHERE postId IS NULL OR postId NOT IN (SELECT id FROM posts);
+ALTER TABLE postRevisions ALTER COLUMN postId SET NOT NULL;
+ALTER TABLE postRevisions ADD CONSTRAINT postRevisions_post_fkey FOREIGN KEY (postId) REFERENCES posts(id) DEFERRABLE INITIALLY DEFERRED;
+
+DELETE FROM comments WHERE postId IS NULL;
+ALTER TABLE comments ALTER COLUMN postId SET NOT NULL;
@@ -0,0 +1,10 @@
+ALTER TABLE posts DROP COLUMN IF EXISTS likesCount;
+
+DROP INDEX IF EXISTS likes_user_idx;
+ALTER TABLE likes DROP CONSTRAINT IF EXISTS likes_post_fkey;
+ALTER TABLE likes DROP CONSTRAINT IF EXISTS likes_pkey;
+ALTER TABLE likes ALTER COLUMN postId TYPE text USING postId::text;
+ALTER TABLE likes ALTER COLUMN postId DROP NOT NULL;
+ALTER TABLE likes ALTER COLUMN userId DROP NOT NULL;
+CREATE INDEX IF NOT EXISTS likes_post_idx ON likes(postId);
+CREATE INDEX IF NOT EXISTS likes_user_post_idx ON likes(userId, postId);
@@ -0,0 +1,19 @@
+-- The post ids were stored as text, the likes that don't refer to an existing post are dropped
+DELETE FROM likes WHERE userId IS NULL OR postId IS NULL
+	OR postId !~* '^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$';
+ALTER TABLE likes ALTER COLUMN postId TYPE uuid USING postId::uuid;
+DELETE FROM likes WHERE postId NOT IN (SELECT id FROM posts);
+
+-- Duplicates left by the concurrent likes
+DELETE FROM likes a USING likes b WHERE a.ctid < b.ctid AND a.userId = b.userId AND a.postId = b.postId;
+
+-- The primary key covers the lookups by the post
+DROP INDEX IF EXISTS likes_post_idx;
+DROP INDEX IF EXISTS likes_user_post_idx;
+ALTER TABLE likes ADD PRIMARY KEY (postId, userId);
+ALTER TABLE likes ADD CONSTRAINT likes_post_fkey FOREIGN KEY (postId) REFERENCES posts(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS likes_user_idx ON likes(userId);
+
+-- Number of the likes, changed together with the likes table
+ALTER TABLE posts ADD COLUMN likesCount integer NOT NULL DEFAULT 0;
+UPDATE posts SET likesCount = (SELECT COUNT(1) FROM likes WHERE likes.postId = posts.id);
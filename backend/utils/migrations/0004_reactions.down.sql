@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS postReactionCounts;
+
+DELETE FROM likes WHERE reaction <> '👍';
+ALTER TABLE likes DROP CONSTRAINT likes_pkey;
+ALTER TABLE likes DROP COLUMN reaction;
+ALTER TABLE likes ADD PRIMARY KEY (postId, userId);
+
+DROP TABLE IF EXISTS reactionTypes;
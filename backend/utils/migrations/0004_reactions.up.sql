@@ -0,0 +1,20 @@
+-- Reactions the users can choose from, managed by the admins. The first one is the like
+CREATE TABLE reactionTypes(
+	emoji text PRIMARY KEY,
+	position integer NOT NULL DEFAULT 0
+);
+INSERT INTO reactionTypes(emoji, position) VALUES('👍', 0);
+
+-- The likes table keeps all reactions, the existing likes become the default reaction
+ALTER TABLE likes ADD COLUMN reaction text NOT NULL DEFAULT '👍' REFERENCES reactionTypes(emoji) ON DELETE CASCADE;
+ALTER TABLE likes ALTER COLUMN reaction DROP DEFAULT;
+ALTER TABLE likes DROP CONSTRAINT likes_pkey;
+ALTER TABLE likes ADD PRIMARY KEY (postId, userId, reaction);
+
+-- Numbers of the reactions other than the default one, which is counted by posts.likesCount
+CREATE TABLE postReactionCounts(
+	postId uuid REFERENCES posts(id) ON DELETE CASCADE,
+	reaction text REFERENCES reactionTypes(emoji) ON DELETE CASCADE,
+	count integer NOT NULL,
+	PRIMARY KEY (postId, reaction)
+);
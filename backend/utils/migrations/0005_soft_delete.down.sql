@@ -0,0 +1,11 @@
+-- The posts in the trash are deleted for good, their images are left to the image collector
+DELETE FROM postRevisions WHERE postId IN (SELECT id FROM posts WHERE deletedAt IS NOT NULL);
+DELETE FROM posts WHERE deletedAt IS NOT NULL;
+
+DROP INDEX IF EXISTS posts_deletedat_idx;
+DROP INDEX IF EXISTS posts_pubdate_idx;
+CREATE INDEX posts_pubdate_idx ON posts(pubDate DESC, id);
+
+ALTER TABLE posts DROP COLUMN deleteReason;
+ALTER TABLE posts DROP COLUMN deletedBy;
+ALTER TABLE posts DROP COLUMN deletedAt;
@@ -0,0 +1,9 @@
+DROP TRIGGER posts_search_delete;
+DROP TRIGGER posts_search_update;
+DROP TRIGGER posts_search_insert;
+DROP TABLE postsSearch;
+DROP TABLE uploads;
+DROP TABLE likes;
+DROP TABLE posts;
+DROP TABLE admins;
+DROP TABLE blacklist;
@@ -0,0 +1,41 @@
+-- SQLite keeps the tables of the Store only: the posts, their likes, the pending uploads and the roles.
+-- The ids are generated by the backend and the dates are unix timestamps in milliseconds
+CREATE TABLE blacklist(
+	gmail text PRIMARY KEY
+);
+CREATE TABLE admins(
+	gmail text PRIMARY KEY
+);
+CREATE TABLE posts(
+	id text PRIMARY KEY,
+	userId text,
+	userEmail text,
+	userDisplayName text,
+	content text,
+	pubDate integer NOT NULL,
+	editDate integer,
+	attachedImages text,
+	boardId text,
+	searchText text NOT NULL DEFAULT ''
+);
+CREATE TABLE likes(
+	userId text,
+	postId text
+);
+CREATE TABLE uploads(
+	name text PRIMARY KEY,
+	userId text NOT NULL,
+	uploadDate integer NOT NULL
+);
+
+-- Full text index of the posts, kept in sync by the triggers
+CREATE VIRTUAL TABLE postsSearch USING fts5(postId UNINDEXED, searchText, tokenize='porter unicode61 remove_diacritics 2');
+CREATE TRIGGER posts_search_insert AFTER INSERT ON posts BEGIN
+	INSERT INTO postsSearch(postId, searchText) VALUES(NEW.id, NEW.searchText);
+END;
+CREATE TRIGGER posts_search_update AFTER UPDATE OF searchText ON posts BEGIN
+	UPDATE postsSearch SET searchText=NEW.searchText WHERE postId=OLD.id;
+END;
+CREATE TRIGGER posts_search_delete AFTER DELETE ON posts BEGIN
+	DELETE FROM postsSearch WHERE postId=OLD.id;
+END;
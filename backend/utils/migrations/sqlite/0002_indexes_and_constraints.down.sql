@@ -0,0 +1,5 @@
+DROP INDEX uploads_date_idx;
+DROP INDEX likes_user_post_idx;
+DROP INDEX likes_post_idx;
+DROP INDEX posts_user_idx;
+DROP INDEX posts_pubdate_idx;
@@ -0,0 +1,5 @@
+CREATE INDEX posts_pubdate_idx ON posts(pubDate DESC, id);
+CREATE INDEX posts_user_idx ON posts(userId);
+CREATE INDEX likes_post_idx ON likes(postId);
+CREATE INDEX likes_user_post_idx ON likes(userId, postId);
+CREATE INDEX uploads_date_idx ON uploads(uploadDate);
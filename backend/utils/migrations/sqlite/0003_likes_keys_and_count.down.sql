@@ -0,0 +1,11 @@
+ALTER TABLE posts DROP COLUMN likesCount;
+
+CREATE TABLE likes_old(
+	userId text,
+	postId text
+);
+INSERT INTO likes_old(userId, postId) SELECT userId, postId FROM likes;
+DROP TABLE likes;
+ALTER TABLE likes_old RENAME TO likes;
+CREATE INDEX likes_post_idx ON likes(postId);
+CREATE INDEX likes_user_post_idx ON likes(userId, postId);
@@ -0,0 +1,15 @@
+-- SQLite can't add the keys to a table, so the likes are copied into a new one without the duplicates
+CREATE TABLE likes_new(
+	postId text NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+	userId text NOT NULL,
+	PRIMARY KEY (postId, userId)
+);
+INSERT OR IGNORE INTO likes_new(postId, userId)
+	SELECT postId, userId FROM likes WHERE userId IS NOT NULL AND postId IN (SELECT id FROM posts);
+DROP TABLE likes;
+ALTER TABLE likes_new RENAME TO likes;
+CREATE INDEX likes_user_idx ON likes(userId);
+
+-- Number of the likes, changed together with the likes table
+ALTER TABLE posts ADD COLUMN likesCount integer NOT NULL DEFAULT 0;
+UPDATE posts SET likesCount = (SELECT COUNT(1) FROM likes WHERE likes.postId = posts.id);
@@ -0,0 +1,13 @@
+DROP TABLE postReactionCounts;
+
+CREATE TABLE likes_old(
+	postId text NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+	userId text NOT NULL,
+	PRIMARY KEY (postId, userId)
+);
+INSERT INTO likes_old(postId, userId) SELECT postId, userId FROM likes WHERE reaction='👍';
+DROP TABLE likes;
+ALTER TABLE likes_old RENAME TO likes;
+CREATE INDEX likes_user_idx ON likes(userId);
+
+DROP TABLE reactionTypes;
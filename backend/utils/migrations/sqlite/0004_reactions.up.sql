@@ -0,0 +1,26 @@
+-- Reactions the users can choose from, managed by the admins. The first one is the like
+CREATE TABLE reactionTypes(
+	emoji text PRIMARY KEY,
+	position integer NOT NULL DEFAULT 0
+);
+INSERT INTO reactionTypes(emoji, position) VALUES('👍', 0);
+
+-- The likes table keeps all reactions, the existing likes become the default reaction
+CREATE TABLE likes_new(
+	postId text NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+	userId text NOT NULL,
+	reaction text NOT NULL REFERENCES reactionTypes(emoji) ON DELETE CASCADE,
+	PRIMARY KEY (postId, userId, reaction)
+);
+INSERT INTO likes_new(postId, userId, reaction) SELECT postId, userId, '👍' FROM likes;
+DROP TABLE likes;
+ALTER TABLE likes_new RENAME TO likes;
+CREATE INDEX likes_user_idx ON likes(userId);
+
+-- Numbers of the reactions other than the default one, which is counted by posts.likesCount
+CREATE TABLE postReactionCounts(
+	postId text REFERENCES posts(id) ON DELETE CASCADE,
+	reaction text REFERENCES reactionTypes(emoji) ON DELETE CASCADE,
+	count integer NOT NULL,
+	PRIMARY KEY (postId, reaction)
+);
@@ -0,0 +1,9 @@
+-- The deleted posts stay in the trash until they are restored or purged after the retention period
+ALTER TABLE posts ADD COLUMN deletedAt integer;
+ALTER TABLE posts ADD COLUMN deletedBy text;
+ALTER TABLE posts ADD COLUMN deleteReason text NOT NULL DEFAULT '';
+
+-- The feeds read only the posts that aren't in the trash
+DROP INDEX posts_pubdate_idx;
+CREATE INDEX posts_pubdate_idx ON posts(pubDate DESC, id) WHERE deletedAt IS NULL;
+CREATE INDEX posts_deletedat_idx ON posts(deletedAt) WHERE deletedAt IS NOT NULL;
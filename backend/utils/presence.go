@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a user without connections is still shown as online,
+// so a reconnecting client doesn't cause a leave and a join
+const presenceLeaveDelay = 15 * time.Second
+
+type PresenceUser struct {
+	UserID          string `json:"userId"`
+	UserDisplayName string `json:"userDisplayName"`
+}
+
+// Users connected to this instance of the backend. The presence is instance-local:
+// the joins and leaves aren't shared through the fanout, as an instance can't tell
+// whether the user is still connected to another one
+type presenceTracker struct {
+	users      map[string]*presenceEntry
+	leaveDelay time.Duration
+	mutex      sync.Mutex
+}
+
+type presenceEntry struct {
+	user        PresenceUser
+	connections int
+	// Announces the leave, set while the user has no connections
+	leaveTimer *time.Timer
+}
+
+func newPresenceTracker(leaveDelay time.Duration) *presenceTracker {
+	return &presenceTracker{
+		users:      map[string]*presenceEntry{},
+		leaveDelay: leaveDelay,
+	}
+}
+
+// Records a connection of the user, announces the join if the user wasn't online
+func (a *sseServer) trackConnect(user ClientUser) {
+	if user.ID == "" {
+		return
+	}
+
+	p := a.presence
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	entry, ok := p.users[user.ID]
+	if !ok {
+		entry = &presenceEntry{user: PresenceUser{user.ID, user.DisplayName}}
+		p.users[user.ID] = entry
+		a.announcePresence(PresenceJoinEvent(entry.user))
+	}
+
+	entry.connections++
+	if entry.leaveTimer != nil {
+		entry.leaveTimer.Stop()
+		entry.leaveTimer = nil
+	}
+}
+
+// Records that a connection of the user is closed. The leave is announced after the delay
+// if the user doesn't connect again
+func (a *sseServer) trackDisconnect(user ClientUser) {
+	if user.ID == "" {
+		return
+	}
+
+	p := a.presence
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	entry, ok := p.users[user.ID]
+	if !ok {
+		return
+	}
+
+	entry.connections--
+	if entry.connections == 0 {
+		entry.leaveTimer = time.AfterFunc(p.leaveDelay, func() {
+			a.leave(entry)
+		})
+	}
+}
+
+func (a *sseServer) leave(entry *presenceEntry) {
+	p := a.presence
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// The user connected again after the timer fired
+	if p.users[entry.user.UserID] != entry || entry.connections > 0 {
+		return
+	}
+
+	delete(p.users, entry.user.UserID)
+	a.announcePresence(PresenceLeaveEvent(entry.user))
+}
+
+// Sent with the presence mutex locked, so the joins and leaves of a user keep their order.
+// Only the local clients receive them, the other instances track their own users
+func (a *sseServer) announcePresence(event Event) {
+	if err := a.sendTo(event, eventTarget{Transient: true}); err != nil {
+		log.Println("SSE presence:", err)
+	}
+}
+
+// Returns the users connected to this instance, sorted by the display name
+func (a *sseServer) Presence() []PresenceUser {
+	p := a.presence
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	users := make([]PresenceUser, 0, len(p.users))
+	for _, entry := range p.users {
+		users = append(users, entry.user)
+	}
+
+	slices.SortFunc(users, func(a PresenceUser, b PresenceUser) int {
+		if c := strings.Compare(a.UserDisplayName, b.UserDisplayName); c != 0 {
+			return c
+		}
+		return strings.Compare(a.UserID, b.UserID)
+	})
+
+	return users
+}
@@ -0,0 +1,280 @@
+package utils
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Reaction the like endpoints stand for, it can't be deleted
+const DefaultReaction = "👍"
+
+var ErrUnknownReaction = errors.New("the reaction isn't one of the reaction types")
+
+// Reaction the users can choose from
+type ReactionType struct {
+	Emoji    string `json:"emoji"`
+	Position int32  `json:"position"`
+}
+
+// Number of the users that reacted to a post with each reaction, the reactions nobody chose are left out
+type ReactionCounts map[string]uint64
+
+type PostReactions struct {
+	Counts ReactionCounts `json:"counts"`
+	// Reactions of the user that asks
+	Mine []string `json:"mine"`
+}
+
+// Returns the reaction types in their display order
+func GetReactionTypes(ctx context.Context) ([]ReactionType, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return []ReactionType{}, err
+	}
+	defer con.Release()
+
+	rows, err := con.Query(ctx, "SELECT emoji, position FROM reactionTypes ORDER BY position, emoji")
+	if err != nil {
+		return []ReactionType{}, err
+	}
+
+	defer rows.Close()
+
+	reactionTypes := []ReactionType{}
+	for rows.Next() {
+		var reactionType ReactionType
+		if err := rows.Scan(&reactionType.Emoji, &reactionType.Position); err != nil {
+			return []ReactionType{}, err
+		}
+
+		reactionTypes = append(reactionTypes, reactionType)
+	}
+
+	return reactionTypes, rows.Err()
+}
+
+// Adds the reaction type or changes the position of an existing one
+func SetReactionType(ctx context.Context, reactionType ReactionType) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer con.Release()
+
+	_, err = con.Exec(
+		ctx,
+		"INSERT INTO reactionTypes(emoji, position) VALUES($1, $2) ON CONFLICT (emoji) DO UPDATE SET position=EXCLUDED.position",
+		reactionType.Emoji, reactionType.Position,
+	)
+	return err
+}
+
+// Deletes the reaction type together with all reactions of its kind.
+// Returns ErrForbidden for the default reaction
+func DeleteReactionType(ctx context.Context, emoji string) error {
+	if emoji == DefaultReaction {
+		return ErrForbidden
+	}
+
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer con.Release()
+
+	tag, err := con.Exec(ctx, "DELETE FROM reactionTypes WHERE emoji=$1", emoji)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+// Adds the reaction of the user to the post and returns the counts after the change.
+// A second reaction of the same kind doesn't change anything. pgx.ErrNoRows is returned
+// if the post doesn't exist and ErrUnknownReaction if the reaction isn't a reaction type
+func AddReaction(ctx context.Context, userId string, postId string, reaction string) (ReactionCounts, error) {
+	return changeReaction(ctx, userId, postId, reaction, true)
+}
+
+// Removes the reaction of the user from the post and returns the counts after the change,
+// pgx.ErrNoRows is returned if the post doesn't exist
+func RemoveReaction(ctx context.Context, userId string, postId string, reaction string) (ReactionCounts, error) {
+	return changeReaction(ctx, userId, postId, reaction, false)
+}
+
+// The default reaction is the like, returns the number of likes of the post after the change
+func AddLike(ctx context.Context, userId string, postId string) (uint64, error) {
+	counts, err := AddReaction(ctx, userId, postId, DefaultReaction)
+	return counts[DefaultReaction], err
+}
+
+// Returns the number of likes of the post after the change
+func RemoveLike(ctx context.Context, userId string, postId string) (uint64, error) {
+	counts, err := RemoveReaction(ctx, userId, postId, DefaultReaction)
+	return counts[DefaultReaction], err
+}
+
+// The row of the post stays locked until the commit, so the concurrent changes of its counters
+// are applied one by one
+func changeReaction(ctx context.Context, userId string, postId string, reaction string, add bool) (ReactionCounts, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return ReactionCounts{}, err
+	}
+	defer con.Release()
+
+	tx, err := con.Begin(ctx)
+	if err != nil {
+		return ReactionCounts{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var exists int
+	if err := tx.QueryRow(ctx, "SELECT 1 FROM posts WHERE id=$1 AND deletedAt IS NULL FOR UPDATE", postId).Scan(&exists); err != nil {
+		return ReactionCounts{}, err
+	}
+
+	var delta int
+	if add {
+		var known bool
+		if err := tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM reactionTypes WHERE emoji=$1)", reaction).Scan(&known); err != nil {
+			return ReactionCounts{}, err
+		}
+		if !known {
+			return ReactionCounts{}, ErrUnknownReaction
+		}
+
+		tag, err := tx.Exec(ctx, "INSERT INTO likes(postId, userId, reaction) VALUES($1, $2, $3) ON CONFLICT DO NOTHING", postId, userId, reaction)
+		if err != nil {
+			return ReactionCounts{}, err
+		}
+		delta = int(tag.RowsAffected())
+	} else {
+		tag, err := tx.Exec(ctx, "DELETE FROM likes WHERE postId=$1 AND userId=$2 AND reaction=$3", postId, userId, reaction)
+		if err != nil {
+			return ReactionCounts{}, err
+		}
+		delta = -int(tag.RowsAffected())
+	}
+
+	if delta != 0 {
+		if reaction == DefaultReaction {
+			_, err = tx.Exec(ctx, "UPDATE posts SET likesCount=likesCount+$2 WHERE id=$1", postId, delta)
+		} else {
+			_, err = tx.Exec(
+				ctx,
+				`INSERT INTO postReactionCounts(postId, reaction, count) VALUES($1, $2, $3)
+				ON CONFLICT (postId, reaction) DO UPDATE SET count=postReactionCounts.count+EXCLUDED.count`,
+				postId, reaction, delta,
+			)
+		}
+		if err != nil {
+			return ReactionCounts{}, err
+		}
+	}
+
+	counts, err := reactionCounts(ctx, tx, postId)
+	if err != nil {
+		return ReactionCounts{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return ReactionCounts{}, err
+	}
+
+	return counts, nil
+}
+
+func reactionCounts(ctx context.Context, tx pgx.Tx, postId string) (ReactionCounts, error) {
+	rows, err := tx.Query(
+		ctx,
+		`SELECT $2::text, likesCount FROM posts WHERE id=$1
+		UNION ALL SELECT reaction, count FROM postReactionCounts WHERE postId=$1`,
+		postId, DefaultReaction,
+	)
+	if err != nil {
+		return ReactionCounts{}, err
+	}
+
+	defer rows.Close()
+
+	counts := ReactionCounts{}
+	for rows.Next() {
+		var reaction string
+		var count uint64
+		if err := rows.Scan(&reaction, &count); err != nil {
+			return ReactionCounts{}, err
+		}
+
+		if count > 0 {
+			counts[reaction] = count
+		}
+	}
+
+	return counts, rows.Err()
+}
+
+// Returns the reaction counts of the post and the reactions of the user.
+// The posts that don't exist have no reactions
+func GetPostReactions(ctx context.Context, postId string, userId string) (PostReactions, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return PostReactions{}, err
+	}
+	defer con.Release()
+
+	tx, err := con.Begin(ctx)
+	if err != nil {
+		return PostReactions{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	counts, err := reactionCounts(ctx, tx, postId)
+	if err != nil {
+		return PostReactions{}, err
+	}
+
+	rows, err := tx.Query(ctx, "SELECT reaction FROM likes WHERE postId=$1 AND userId=$2 ORDER BY reaction", postId, userId)
+	if err != nil {
+		return PostReactions{}, err
+	}
+
+	defer rows.Close()
+
+	mine := []string{}
+	for rows.Next() {
+		var reaction string
+		if err := rows.Scan(&reaction); err != nil {
+			return PostReactions{}, err
+		}
+
+		mine = append(mine, reaction)
+	}
+	if err := rows.Err(); err != nil {
+		return PostReactions{}, err
+	}
+
+	return PostReactions{Counts: counts, Mine: mine}, tx.Commit(ctx)
+}
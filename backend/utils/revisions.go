@@ -0,0 +1,299 @@
+package utils
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Previous version of a post. A revision is created every time a post is edited or restored,
+// EditorID and EditDate tell who replaced this version and when
+type PostRevision struct {
+	ID                string   `json:"revisionId"`
+	PostID            string   `json:"postId"`
+	EditorID          string   `json:"editorId"`
+	EditorDisplayName string   `json:"editorDisplayName"`
+	EditDate          JSONTime `json:"editTime"`
+	Content           string   `json:"content"`
+	AttachedImages    []string `json:"-"`
+}
+
+// New version of a post
+type PostEdit struct {
+	EditorID          string
+	EditorDisplayName string
+	Content           string
+	// Images that were uploaded with the new version
+	AttachedImages []string
+	// Already stored images the new version refers to. Only the images that belong
+	// to the post or to its revisions and the pending uploads of the editor are attached
+	ReferencedImages []string
+	// Language code of the new version, the language of the post isn't changed if empty
+	Language string
+}
+
+// Replaces the content of a post, keeping the previous version in the revisions history.
+// Returns ErrForbidden if the editor isn't the author of the post and asAdmin is false
+func EditPost(ctx context.Context, postId string, edit PostEdit, asAdmin bool) (Post, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return Post{}, err
+	}
+	defer con.Release()
+
+	tx, err := con.Begin(ctx)
+	if err != nil {
+		return Post{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	post, current, err := lockPostForEdit(ctx, tx, postId, edit.EditorID, asAdmin)
+	if err != nil {
+		return Post{}, err
+	}
+
+	knownImages, err := getPostRevisionsImages(ctx, tx, postId)
+	if err != nil {
+		return Post{}, err
+	}
+	knownImages = append(knownImages, current.AttachedImages...)
+
+	claimed, err := claimUploads(ctx, tx, edit.EditorID, edit.ReferencedImages)
+	if err != nil {
+		return Post{}, err
+	}
+	knownImages = append(knownImages, claimed...)
+
+	attachedImages := slices.Clone(edit.AttachedImages)
+	for _, img := range edit.ReferencedImages {
+		if slices.Contains(knownImages, img) && !slices.Contains(attachedImages, img) {
+			attachedImages = append(attachedImages, img)
+		}
+	}
+	edit.AttachedImages = attachedImages
+
+	if err := archiveAndReplacePost(ctx, tx, &post, current, edit); err != nil {
+		return Post{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Post{}, err
+	}
+
+	return post, nil
+}
+
+// Makes a revision the current version of a post, the replaced version becomes a new revision.
+// Returns ErrForbidden if the editor isn't the author of the post and asAdmin is false
+func RestorePostRevision(ctx context.Context, postId string, revisionId string, editorId string, editorDisplayName string, asAdmin bool) (Post, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return Post{}, err
+	}
+	defer con.Release()
+
+	tx, err := con.Begin(ctx)
+	if err != nil {
+		return Post{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	post, current, err := lockPostForEdit(ctx, tx, postId, editorId, asAdmin)
+	if err != nil {
+		return Post{}, err
+	}
+
+	var content, attachedImgs string
+	row := tx.QueryRow(ctx, "SELECT content, attachedImages FROM postRevisions WHERE id=$1 AND postId=$2", revisionId, postId)
+	if err := row.Scan(&content, &attachedImgs); err != nil {
+		return Post{}, err
+	}
+
+	if err := archiveAndReplacePost(ctx, tx, &post, current, PostEdit{
+		EditorID:          editorId,
+		EditorDisplayName: editorDisplayName,
+		Content:           content,
+		AttachedImages:    splitImages(attachedImgs),
+	}); err != nil {
+		return Post{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Post{}, err
+	}
+
+	return post, nil
+}
+
+// Returns the revisions of a post, the newest first
+func GetPostRevisions(ctx context.Context, postId string) ([]PostRevision, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return []PostRevision{}, err
+	}
+	defer con.Release()
+
+	rows, err := con.Query(ctx, "SELECT id, editorId, editorDisplayName, editDate, content, attachedImages FROM postRevisions WHERE postId=$1 ORDER BY editDate DESC", postId)
+	if err != nil {
+		return []PostRevision{}, err
+	}
+
+	defer rows.Close()
+
+	revisions := []PostRevision{}
+	for rows.Next() {
+		var revisionId, editorId, editorDisplayName, content, attachedImgs string
+		var editDate pgtype.Timestamp
+		if err := rows.Scan(&revisionId, &editorId, &editorDisplayName, &editDate, &content, &attachedImgs); err != nil {
+			return []PostRevision{}, err
+		}
+
+		revisions = append(revisions, PostRevision{
+			ID:                revisionId,
+			PostID:            postId,
+			EditorID:          editorId,
+			EditorDisplayName: editorDisplayName,
+			EditDate:          JSONTime(editDate.Time),
+			Content:           content,
+			AttachedImages:    splitImages(attachedImgs),
+		})
+	}
+
+	return revisions, rows.Err()
+}
+
+// Returns the user id of the post author
+func GetPostAuthor(ctx context.Context, postId string) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer con.Release()
+
+	var userId string
+
+	row := con.QueryRow(ctx, "SELECT userId FROM posts WHERE id=$1 AND deletedAt IS NULL", postId)
+	if err := row.Scan(&userId); err != nil {
+		return "", err
+	}
+
+	return userId, nil
+}
+
+// Locks the post row until the end of the transaction and returns its metadata and current version
+func lockPostForEdit(ctx context.Context, tx pgx.Tx, postId string, editorId string, asAdmin bool) (Post, PostEdit, error) {
+	var post Post
+	var current PostEdit
+	var pubDate pgtype.Timestamp
+	var attachedImgs string
+	var boardId *string
+
+	row := tx.QueryRow(ctx, "SELECT userId, userDisplayName, pubDate, content, attachedImages, boardId FROM posts WHERE id=$1 AND deletedAt IS NULL FOR UPDATE", postId)
+	if err := row.Scan(&post.UserID, &post.UserDisplayName, &pubDate, &current.Content, &attachedImgs, &boardId); err != nil {
+		return Post{}, PostEdit{}, err
+	}
+
+	if boardId != nil {
+		post.BoardID = *boardId
+	}
+
+	if !asAdmin && post.UserID != editorId {
+		return Post{}, PostEdit{}, ErrForbidden
+	}
+
+	post.ID = postId
+	post.PubDate = JSONTime(pubDate.Time)
+	current.AttachedImages = splitImages(attachedImgs)
+
+	return post, current, nil
+}
+
+// Saves the current version of a post as a revision and replaces it with the edit
+func archiveAndReplacePost(ctx context.Context, tx pgx.Tx, post *Post, current PostEdit, edit PostEdit) error {
+	_, err := tx.Exec(
+		ctx,
+		"INSERT INTO postRevisions(postId, editorId, editorDisplayName, content, attachedImages) VALUES($1, $2, $3, $4, $5)",
+		post.ID, edit.EditorID, edit.EditorDisplayName, current.Content, strings.Join(current.AttachedImages, ","),
+	)
+	if err != nil {
+		return err
+	}
+
+	var searchConfig *string
+	if edit.Language != "" {
+		config := SearchConfig(edit.Language)
+		searchConfig = &config
+	}
+
+	var editDate pgtype.Timestamp
+	row := tx.QueryRow(
+		ctx,
+		"UPDATE posts SET content=$1, attachedImages=$2, searchText=$3, searchConfig=COALESCE($4::regconfig, searchConfig), editDate=NOW() WHERE id=$5 RETURNING editDate",
+		edit.Content, strings.Join(edit.AttachedImages, ","), contentText(edit.Content), searchConfig, post.ID,
+	)
+	if err := row.Scan(&editDate); err != nil {
+		return err
+	}
+
+	post.EditDate = optionalJSONTime(editDate)
+
+	return nil
+}
+
+func getPostRevisionsImages(ctx context.Context, tx pgx.Tx, postId string) ([]string, error) {
+	rows, err := tx.Query(ctx, "SELECT attachedImages FROM postRevisions WHERE postId=$1", postId)
+	if err != nil {
+		return []string{}, err
+	}
+
+	defer rows.Close()
+
+	images := []string{}
+	for rows.Next() {
+		var attachedImgs string
+		if err := rows.Scan(&attachedImgs); err != nil {
+			return []string{}, err
+		}
+
+		images = append(images, splitImages(attachedImgs)...)
+	}
+
+	return images, rows.Err()
+}
+
+// Deletes all revisions of a post and returns the images they had attached
+func deletePostRevisions(ctx context.Context, tx pgx.Tx, postId string) ([]string, error) {
+	rows, err := tx.Query(ctx, "DELETE FROM postRevisions WHERE postId=$1 RETURNING attachedImages", postId)
+	if err != nil {
+		return []string{}, err
+	}
+
+	defer rows.Close()
+
+	images := []string{}
+	for rows.Next() {
+		var attachedImgs string
+		if err := rows.Scan(&attachedImgs); err != nil {
+			return []string{}, err
+		}
+
+		images = append(images, splitImages(attachedImgs)...)
+	}
+
+	return images, rows.Err()
+}
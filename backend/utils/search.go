@@ -0,0 +1,270 @@
+package utils
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Text search configurations of the languages the frontend supports.
+// PostgreSQL has no Ukrainian dictionary out of the box, so the "simple" configuration is used
+// unless SEARCH_CONFIG_UK points to a custom one
+var searchConfigs = map[string]string{
+	"en": envOr("SEARCH_CONFIG_EN", "english"),
+	"uk": envOr("SEARCH_CONFIG_UK", "simple"),
+}
+
+// Configuration for the posts without a known language
+const defaultSearchConfig = "simple"
+
+// Markers that ts_headline puts around the matched words, replaced with <mark> after escaping
+const (
+	headlineStart = "\x02"
+	headlineStop  = "\x03"
+)
+
+var ErrInvalidCursor = fmt.Errorf("invalid cursor")
+
+type SearchQuery struct {
+	Query string
+	// Only the posts of this user if not empty
+	AuthorID string
+	// Only the posts published in this time range, zero values mean no limit
+	From time.Time
+	To   time.Time
+	// Value of NextCursor of the previous page
+	Cursor string
+	Limit  uint32
+}
+
+type SearchResult struct {
+	Post
+	Rank float32 `json:"rank"`
+	// Plain text fragments of the post with the matched words wrapped into <mark>
+	Snippet string `json:"snippet"`
+}
+
+// The rank is kept as the database returned it, so the next page starts exactly after the last result
+type searchCursor struct {
+	Rank float64 `json:"r"`
+	ID   string  `json:"i"`
+}
+
+// Decodes the cursor of SearchQuery, nil if the query has no cursor
+func decodeSearchCursor(value string) (*searchCursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	cursor := &searchCursor{}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || json.Unmarshal(data, cursor) != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+func encodeSearchCursor(rank float64, id string) (string, error) {
+	data, err := json.Marshal(searchCursor{Rank: rank, ID: id})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Replaces the markers of the matched words in a plain text fragment with <mark>, escaping the rest
+func markSnippet(headline string) string {
+	return strings.NewReplacer(
+		headlineStart, "<mark>",
+		headlineStop, "</mark>",
+	).Replace(html.EscapeString(headline))
+}
+
+func envOr(name string, defVal string) string {
+	if val := os.Getenv(name); val != "" {
+		return val
+	}
+
+	return defVal
+}
+
+// Returns the text search configuration for a language code of the frontend
+func SearchConfig(lang string) string {
+	if config, ok := searchConfigs[lang]; ok {
+		return config
+	}
+
+	return defaultSearchConfig
+}
+
+// Checks whether the language code is supported by the search
+func IsSearchLanguage(lang string) bool {
+	_, ok := searchConfigs[lang]
+	return ok
+}
+
+// Extracts the plain text from the HTML content of a post
+func contentText(content string) string {
+	nodes, err := nethtml.ParseFragment(strings.NewReader(content), &nethtml.Node{
+		Type:     nethtml.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	})
+	if err != nil {
+		return ""
+	}
+
+	builder := strings.Builder{}
+	var walk func(node *nethtml.Node)
+	walk = func(node *nethtml.Node) {
+		if node.Type == nethtml.TextNode {
+			builder.WriteString(node.Data)
+		}
+
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+
+		if node.Type == nethtml.ElementNode {
+			builder.WriteByte(' ')
+		}
+	}
+
+	for _, node := range nodes {
+		walk(node)
+	}
+
+	return strings.Join(strings.Fields(builder.String()), " ")
+}
+
+// Searches the posts by their text, the most relevant first
+func SearchPosts(ctx context.Context, query SearchQuery) (results []SearchResult, nextCursor string, err error) {
+	cursor, err := decodeSearchCursor(query.Cursor)
+	if err != nil {
+		return []SearchResult{}, "", err
+	}
+
+	configs := []string{defaultSearchConfig}
+	for _, config := range searchConfigs {
+		if !slices.Contains(configs, config) {
+			configs = append(configs, config)
+		}
+	}
+
+	// A post matches if the query matches it in any of the configurations,
+	// since the language of the query itself is unknown
+	args := []any{query.Query}
+	tsQueries := []string{}
+	for _, config := range configs {
+		args = append(args, config)
+		tsQueries = append(tsQueries, fmt.Sprintf("websearch_to_tsquery($%d::regconfig, $1)", len(args)))
+	}
+
+	filters := []string{"searchVector @@ q.query", "deletedAt IS NULL"}
+	addFilter := func(filter string, arg any) {
+		args = append(args, arg)
+		filters = append(filters, fmt.Sprintf(filter, len(args)))
+	}
+
+	if query.AuthorID != "" {
+		addFilter("userId=$%d", query.AuthorID)
+	}
+	if !query.From.IsZero() {
+		addFilter("pubDate >= $%d", query.From)
+	}
+	if !query.To.IsZero() {
+		addFilter("pubDate < $%d", query.To)
+	}
+
+	cursorFilter := "TRUE"
+	if cursor != nil {
+		args = append(args, cursor.Rank, cursor.ID)
+		cursorFilter = fmt.Sprintf("(rank < $%d OR (rank = $%d AND id < $%d))", len(args)-1, len(args)-1, len(args))
+	}
+
+	args = append(args, query.Limit)
+
+	sql := fmt.Sprintf(
+		`SELECT %s, rank, ts_headline(searchConfig, searchText, query, '%s') FROM (
+			SELECT %s, searchConfig, searchText, q.query, ts_rank(searchVector, q.query) AS rank
+			FROM posts, (SELECT %s AS query) q
+			WHERE %s
+		) AS matched
+		WHERE %s
+		ORDER BY rank DESC, id DESC
+		LIMIT $%d`,
+		postColumns,
+		"StartSel="+headlineStart+", StopSel="+headlineStop+", MaxFragments=2, MaxWords=20, MinWords=5",
+		postColumns,
+		strings.Join(tsQueries, " || "),
+		strings.Join(filters, " AND "),
+		cursorFilter,
+		len(args),
+	)
+
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return []SearchResult{}, "", err
+	}
+	defer con.Release()
+
+	rows, err := con.Query(ctx, sql, args...)
+	if err != nil {
+		return []SearchResult{}, "", err
+	}
+
+	defer rows.Close()
+
+	results = []SearchResult{}
+	var lastRank float64
+	for rows.Next() {
+		var result SearchResult
+		var boardId *string
+		var pubDate, editDate pgtype.Timestamp
+		var headline string
+		err := rows.Scan(&result.ID, &pubDate, &editDate, &result.UserID, &result.UserDisplayName, &boardId, &lastRank, &headline)
+		if err != nil {
+			return []SearchResult{}, "", err
+		}
+
+		result.Rank = float32(lastRank)
+
+		result.PubDate = JSONTime(pubDate.Time)
+		result.EditDate = optionalJSONTime(editDate)
+		if boardId != nil {
+			result.BoardID = *boardId
+		}
+
+		result.Snippet = markSnippet(headline)
+
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return []SearchResult{}, "", err
+	}
+
+	if len(results) > 0 && uint32(len(results)) == query.Limit {
+		nextCursor, err = encodeSearchCursor(lastRank, results[len(results)-1].ID)
+		if err != nil {
+			return []SearchResult{}, "", err
+		}
+	}
+
+	return results, nextCursor, nil
+}
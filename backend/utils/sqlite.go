@@ -0,0 +1,927 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	_ "modernc.org/sqlite"
+)
+
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrationFiles embed.FS
+
+// Store in a SQLite file, for the single instance deployments. It has the tables of the Store only,
+// so the comments, boards, revisions and the events fanout need Postgres.
+// The errors are translated to the ones of PgStore, e.g. sql.ErrNoRows becomes pgx.ErrNoRows
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// Returns the path of the SQLite file if the address has the sqlite: scheme
+func sqlitePath(address string) (string, bool) {
+	path, ok := strings.CutPrefix(address, "sqlite:")
+	if !ok {
+		return "", false
+	}
+
+	// sqlite:///absolute/path and sqlite://relative/path
+	return strings.TrimPrefix(path, "//"), true
+}
+
+// Opens the SQLite file, it's created if it doesn't exist
+func OpenSQLite(path string) (*SQLiteStore, error) {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	db, err := sql.Open("sqlite", path+separator+"_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite has a single writer, one connection applies the transactions one by one
+	// instead of failing them with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Close() {
+	s.db.Close()
+}
+
+// Translates the errors of database/sql to the ones of the Postgres store
+func sqliteError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return pgx.ErrNoRows
+	}
+
+	return err
+}
+
+// Returns "?, ?, ?" for count placeholders
+func sqlitePlaceholders(count int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", count), ", ")
+}
+
+func toAnySlice(values []string) []any {
+	args := make([]any, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+
+	return args
+}
+
+func (s *SQLiteStore) migrations() ([]MigrationState, error) {
+	migrations, err := loadMigrations(sqliteMigrationFiles, "migrations/sqlite")
+	if err != nil {
+		return []MigrationState{}, err
+	}
+
+	return migrationStates(s, migrations)
+}
+
+func (s *SQLiteStore) appliedMigrations() (map[int]time.Time, error) {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations(
+	version integer PRIMARY KEY,
+	name text NOT NULL,
+	appliedAt integer NOT NULL
+)`)
+	if err != nil {
+		return map[int]time.Time{}, err
+	}
+
+	rows, err := s.db.Query("SELECT version, appliedAt FROM schema_migrations")
+	if err != nil {
+		return map[int]time.Time{}, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return map[int]time.Time{}, err
+		}
+
+		applied[version] = time.UnixMilli(appliedAt)
+	}
+
+	return applied, rows.Err()
+}
+
+func (s *SQLiteStore) runMigration(migration Migration, up bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := migration.Down
+	if up {
+		query = migration.Up
+	}
+
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("migration %s: %w", migration, err)
+	}
+
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_migrations(version, name, appliedAt) VALUES(?, ?, ?)", migration.Version, migration.Name, time.Now().UnixMilli())
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version=?", migration.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("migration %s: %w", migration, err)
+	}
+
+	return tx.Commit()
+}
+
+// The single connection keeps the other queries out while the migrations run
+func (s *SQLiteStore) MigrationStatus() ([]MigrationState, error) {
+	return s.migrations()
+}
+
+func (s *SQLiteStore) MigrateUp() ([]Migration, error) {
+	states, err := s.migrations()
+	if err != nil {
+		return []Migration{}, err
+	}
+
+	return migrateUp(s, states)
+}
+
+func (s *SQLiteStore) MigrateDown(steps int) ([]Migration, error) {
+	states, err := s.migrations()
+	if err != nil {
+		return []Migration{}, err
+	}
+
+	return migrateDown(s, states, steps)
+}
+
+func (s *SQLiteStore) AddPost(ctx context.Context, post Post, referencedImages []string) (Post, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Post{}, err
+	}
+	defer tx.Rollback()
+
+	claimed, err := sqliteClaimUploads(ctx, tx, post.UserID, referencedImages)
+	if err != nil {
+		return Post{}, err
+	}
+	post.AttachedImages = append(post.AttachedImages, claimed...)
+
+	pubDate := time.Now()
+	post.ID = uuid.NewString()
+	post.PubDate = JSONTime(pubDate)
+
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO posts(id, userId, userEmail, userDisplayName, content, pubDate, attachedImages, boardId, searchText) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		post.ID, post.UserID, post.UserEmail, post.UserDisplayName, post.Content, pubDate.UnixMilli(),
+		strings.Join(post.AttachedImages, ","), nullableId(post.BoardID), contentText(post.Content),
+	)
+	if err != nil {
+		return Post{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Post{}, err
+	}
+
+	return post, nil
+}
+
+func (s *SQLiteStore) DeletePost(ctx context.Context, postId string, userId string, reason string) error {
+	_, err := s.trashPost(ctx, postId, userId, reason, false)
+	return err
+}
+
+func (s *SQLiteStore) DeletePostAdmin(ctx context.Context, postId string, adminId string, reason string) (string, error) {
+	return s.trashPost(ctx, postId, adminId, reason, true)
+}
+
+func (s *SQLiteStore) trashPost(ctx context.Context, postId string, userId string, reason string, asAdmin bool) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var authorId string
+	err := s.db.QueryRowContext(
+		ctx,
+		"UPDATE posts SET deletedAt=?, deletedBy=?, deleteReason=? WHERE id=? AND deletedAt IS NULL AND (? OR userId=?) RETURNING userId",
+		time.Now().UnixMilli(), userId, reason, postId, asAdmin, userId,
+	).Scan(&authorId)
+	if err != nil {
+		return "", sqliteError(err)
+	}
+
+	return authorId, nil
+}
+
+func (s *SQLiteStore) RestorePost(ctx context.Context, postId string, userId string, asAdmin bool) (Post, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Post{}, err
+	}
+	defer tx.Rollback()
+
+	var authorId, deletedBy string
+	row := tx.QueryRowContext(ctx, "SELECT userId, deletedBy FROM posts WHERE id=? AND deletedAt IS NOT NULL", postId)
+	if err := row.Scan(&authorId, &deletedBy); err != nil {
+		return Post{}, sqliteError(err)
+	}
+
+	if !asAdmin && (authorId != userId || deletedBy != userId) {
+		return Post{}, ErrForbidden
+	}
+
+	post, err := scanSQLitePost(tx.QueryRowContext(ctx, "UPDATE posts SET deletedAt=NULL, deletedBy=NULL, deleteReason='' WHERE id=? RETURNING "+postColumns, postId))
+	if err != nil {
+		return Post{}, sqliteError(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Post{}, err
+	}
+
+	return post, nil
+}
+
+func (s *SQLiteStore) GetPosts(ctx context.Context, query FeedQuery) (FeedPage, error) {
+	cursor, err := decodeFeedCursor(query)
+	if err != nil {
+		return FeedPage{}, err
+	}
+
+	feed, args := sqliteFeedSQL(postColumns, "deletedAt IS NULL", []any{}, cursor, query)
+	posts, err := s.queryPosts(ctx, feed, args...)
+	if err != nil {
+		return FeedPage{}, err
+	}
+
+	return newFeedPage(posts, query), nil
+}
+
+func (s *SQLiteStore) GetTrash(ctx context.Context, userId string, query FeedQuery) (FeedPage, error) {
+	cursor, err := decodeFeedCursor(query)
+	if err != nil {
+		return FeedPage{}, err
+	}
+
+	feed, args := sqliteFeedSQL(
+		postColumns+", content, deletedAt, deletedBy, deleteReason",
+		"deletedAt IS NOT NULL AND (?='' OR userId=?)",
+		[]any{userId, userId}, cursor, query,
+	)
+
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, feed, args...)
+	if err != nil {
+		return FeedPage{}, err
+	}
+
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var content string
+		var deletedAt int64
+		var deletion PostDeletion
+		post, err := scanSQLitePost(rows, &content, &deletedAt, &deletion.UserID, &deletion.Reason)
+		if err != nil {
+			return FeedPage{}, err
+		}
+
+		post.Content = content
+		deletion.Date = JSONTime(time.UnixMilli(deletedAt))
+		post.Deletion = &deletion
+
+		posts = append(posts, post)
+	}
+
+	if err := rows.Err(); err != nil {
+		return FeedPage{}, err
+	}
+
+	return newFeedPage(posts, query), nil
+}
+
+func (s *SQLiteStore) PurgeTrash(ctx context.Context, maxAge time.Duration) ([]string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "DELETE FROM posts WHERE deletedAt < ? RETURNING COALESCE(attachedImages, '')", time.Now().Add(-maxAge).UnixMilli())
+	if err != nil {
+		return []string{}, err
+	}
+
+	attachedImgs, err := scanSQLiteStrings(rows)
+	if err != nil {
+		return []string{}, err
+	}
+
+	images := []string{}
+	for _, imgs := range attachedImgs {
+		images = append(images, splitImages(imgs)...)
+	}
+
+	return images, nil
+}
+
+// Builds the query of a page of the feed like feedSQL, with the dates in milliseconds
+func sqliteFeedSQL(columns string, filter string, args []any, cursor *feedCursor, query FeedQuery) (string, []any) {
+	where := filter
+	order := "pubDate DESC, id"
+	if cursor != nil {
+		date := cursor.pubDate().UnixMilli()
+		args = append(args, date, date, cursor.ID)
+
+		if query.Newer {
+			where += " AND pubDate >= ? AND (pubDate > ? OR id < ?)"
+			order = "pubDate, id DESC"
+		} else {
+			where += " AND pubDate <= ? AND (pubDate < ? OR id > ?)"
+		}
+	}
+
+	args = append(args, query.Limit+1)
+
+	return "SELECT " + columns + " FROM posts WHERE " + where + " ORDER BY " + order + " LIMIT ?", args
+}
+
+// Scans a row of postColumns, the columns that follow them are scanned into dest
+func scanSQLitePost(row interface{ Scan(dest ...any) error }, dest ...any) (Post, error) {
+	var post Post
+	var boardId *string
+	var pubDate int64
+	var editDate *int64
+	err := row.Scan(append([]any{&post.ID, &pubDate, &editDate, &post.UserID, &post.UserDisplayName, &boardId}, dest...)...)
+	if err != nil {
+		return Post{}, err
+	}
+
+	post.PubDate = JSONTime(time.UnixMilli(pubDate))
+	if editDate != nil {
+		jsonTime := JSONTime(time.UnixMilli(*editDate))
+		post.EditDate = &jsonTime
+	}
+	if boardId != nil {
+		post.BoardID = *boardId
+	}
+
+	return post, nil
+}
+
+func (s *SQLiteStore) queryPosts(ctx context.Context, query string, args ...any) ([]Post, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return []Post{}, err
+	}
+
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		post, err := scanSQLitePost(rows)
+		if err != nil {
+			return []Post{}, err
+		}
+
+		posts = append(posts, post)
+	}
+
+	return posts, rows.Err()
+}
+
+func (s *SQLiteStore) GetPostContent(ctx context.Context, postId string) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var content string
+	if err := s.db.QueryRowContext(ctx, "SELECT content FROM posts WHERE id=? AND deletedAt IS NULL", postId).Scan(&content); err != nil {
+		return "", sqliteError(err)
+	}
+
+	return content, nil
+}
+
+func (s *SQLiteStore) GetPostAuthor(ctx context.Context, postId string) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var userId string
+	if err := s.db.QueryRowContext(ctx, "SELECT userId FROM posts WHERE id=? AND deletedAt IS NULL", postId).Scan(&userId); err != nil {
+		return "", sqliteError(err)
+	}
+
+	return userId, nil
+}
+
+func (s *SQLiteStore) GetPostsMeta(ctx context.Context, postIds []string, userId string) (map[string]PostMeta, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	metas := map[string]PostMeta{}
+	if len(postIds) == 0 {
+		return metas, nil
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, content, likesCount, EXISTS(SELECT 1 FROM likes WHERE postId=posts.id AND userId=? AND reaction=?)
+		FROM posts WHERE deletedAt IS NULL AND id IN (`+sqlitePlaceholders(len(postIds))+`)`,
+		append([]any{userId, DefaultReaction}, toAnySlice(postIds)...)...,
+	)
+	if err != nil {
+		return map[string]PostMeta{}, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var postId string
+		var content *string
+		var meta PostMeta
+		if err := rows.Scan(&postId, &content, &meta.Likes, &meta.Liked); err != nil {
+			return map[string]PostMeta{}, err
+		}
+
+		if content != nil {
+			meta.Content = *content
+		}
+		metas[postId] = meta
+	}
+
+	return metas, rows.Err()
+}
+
+func (s *SQLiteStore) AddReaction(ctx context.Context, userId string, postId string, reaction string) (ReactionCounts, error) {
+	return s.changeReaction(ctx, userId, postId, reaction, true)
+}
+
+func (s *SQLiteStore) RemoveReaction(ctx context.Context, userId string, postId string, reaction string) (ReactionCounts, error) {
+	return s.changeReaction(ctx, userId, postId, reaction, false)
+}
+
+// The counters are consistent with the likes since the transactions don't run concurrently
+func (s *SQLiteStore) changeReaction(ctx context.Context, userId string, postId string, reaction string, add bool) (ReactionCounts, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ReactionCounts{}, err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRowContext(ctx, "SELECT 1 FROM posts WHERE id=? AND deletedAt IS NULL", postId).Scan(&exists); err != nil {
+		return ReactionCounts{}, sqliteError(err)
+	}
+
+	var result sql.Result
+	var delta int
+	if add {
+		var known bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM reactionTypes WHERE emoji=?)", reaction).Scan(&known); err != nil {
+			return ReactionCounts{}, err
+		}
+		if !known {
+			return ReactionCounts{}, ErrUnknownReaction
+		}
+
+		result, err = tx.ExecContext(ctx, "INSERT INTO likes(postId, userId, reaction) VALUES(?, ?, ?) ON CONFLICT DO NOTHING", postId, userId, reaction)
+		delta = 1
+	} else {
+		result, err = tx.ExecContext(ctx, "DELETE FROM likes WHERE postId=? AND userId=? AND reaction=?", postId, userId, reaction)
+		delta = -1
+	}
+	if err != nil {
+		return ReactionCounts{}, err
+	}
+
+	if changed, err := result.RowsAffected(); err != nil {
+		return ReactionCounts{}, err
+	} else if changed > 0 {
+		if reaction == DefaultReaction {
+			_, err = tx.ExecContext(ctx, "UPDATE posts SET likesCount=likesCount+? WHERE id=?", delta, postId)
+		} else {
+			_, err = tx.ExecContext(
+				ctx,
+				`INSERT INTO postReactionCounts(postId, reaction, count) VALUES(?, ?, ?)
+				ON CONFLICT (postId, reaction) DO UPDATE SET count=count+excluded.count`,
+				postId, reaction, delta,
+			)
+		}
+		if err != nil {
+			return ReactionCounts{}, err
+		}
+	}
+
+	counts, err := sqliteReactionCounts(ctx, tx, postId)
+	if err != nil {
+		return ReactionCounts{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ReactionCounts{}, err
+	}
+
+	return counts, nil
+}
+
+func sqliteReactionCounts(ctx context.Context, tx *sql.Tx, postId string) (ReactionCounts, error) {
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT ?, likesCount FROM posts WHERE id=?
+		UNION ALL SELECT reaction, count FROM postReactionCounts WHERE postId=?`,
+		DefaultReaction, postId, postId,
+	)
+	if err != nil {
+		return ReactionCounts{}, err
+	}
+
+	defer rows.Close()
+
+	counts := ReactionCounts{}
+	for rows.Next() {
+		var reaction string
+		var count uint64
+		if err := rows.Scan(&reaction, &count); err != nil {
+			return ReactionCounts{}, err
+		}
+
+		if count > 0 {
+			counts[reaction] = count
+		}
+	}
+
+	return counts, rows.Err()
+}
+
+func (s *SQLiteStore) GetPostReactions(ctx context.Context, postId string, userId string) (PostReactions, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return PostReactions{}, err
+	}
+	defer tx.Rollback()
+
+	counts, err := sqliteReactionCounts(ctx, tx, postId)
+	if err != nil {
+		return PostReactions{}, err
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT reaction FROM likes WHERE postId=? AND userId=? ORDER BY reaction", postId, userId)
+	if err != nil {
+		return PostReactions{}, err
+	}
+
+	defer rows.Close()
+
+	mine := []string{}
+	for rows.Next() {
+		var reaction string
+		if err := rows.Scan(&reaction); err != nil {
+			return PostReactions{}, err
+		}
+
+		mine = append(mine, reaction)
+	}
+	if err := rows.Err(); err != nil {
+		return PostReactions{}, err
+	}
+
+	return PostReactions{Counts: counts, Mine: mine}, tx.Commit()
+}
+
+func (s *SQLiteStore) GetPostLikes(ctx context.Context, postId string) (uint64, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var count uint64
+	if err := s.db.QueryRowContext(ctx, "SELECT COALESCE((SELECT likesCount FROM posts WHERE id=? AND deletedAt IS NULL), 0)", postId).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (s *SQLiteStore) CheckUserLikedPost(ctx context.Context, userId string, postId string) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var liked bool
+	row := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM likes WHERE userId=? AND postId=? AND reaction=?)", userId, postId, DefaultReaction)
+	if err := row.Scan(&liked); err != nil {
+		return true, err
+	}
+
+	return liked, nil
+}
+
+func (s *SQLiteStore) GetReactionTypes(ctx context.Context) ([]ReactionType, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT emoji, position FROM reactionTypes ORDER BY position, emoji")
+	if err != nil {
+		return []ReactionType{}, err
+	}
+
+	defer rows.Close()
+
+	reactionTypes := []ReactionType{}
+	for rows.Next() {
+		var reactionType ReactionType
+		if err := rows.Scan(&reactionType.Emoji, &reactionType.Position); err != nil {
+			return []ReactionType{}, err
+		}
+
+		reactionTypes = append(reactionTypes, reactionType)
+	}
+
+	return reactionTypes, rows.Err()
+}
+
+func (s *SQLiteStore) SetReactionType(ctx context.Context, reactionType ReactionType) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(
+		ctx,
+		"INSERT INTO reactionTypes(emoji, position) VALUES(?, ?) ON CONFLICT (emoji) DO UPDATE SET position=excluded.position",
+		reactionType.Emoji, reactionType.Position,
+	)
+	return err
+}
+
+func (s *SQLiteStore) DeleteReactionType(ctx context.Context, emoji string) error {
+	if emoji == DefaultReaction {
+		return ErrForbidden
+	}
+
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, "DELETE FROM reactionTypes WHERE emoji=?", emoji)
+	if err != nil {
+		return err
+	}
+
+	if deleted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+// FTS5 query that matches the posts with all words of the query. The words are quoted,
+// so the operators of FTS5 in the query of the user don't cause syntax errors
+func sqliteSearchQuery(query string) string {
+	words := searchWords(query)
+	for i, word := range words {
+		words[i] = `"` + word + `"`
+	}
+
+	return strings.Join(words, " ")
+}
+
+// The text is matched with the porter stemmer whatever the language of the post is
+func (s *SQLiteStore) SearchPosts(ctx context.Context, query SearchQuery) ([]SearchResult, string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	cursor, err := decodeSearchCursor(query.Cursor)
+	if err != nil {
+		return []SearchResult{}, "", err
+	}
+
+	match := sqliteSearchQuery(query.Query)
+	if match == "" {
+		return []SearchResult{}, "", nil
+	}
+
+	args := []any{headlineStart, headlineStop, match}
+	filters := []string{"postsSearch MATCH ?", "posts.deletedAt IS NULL"}
+	if query.AuthorID != "" {
+		filters = append(filters, "posts.userId=?")
+		args = append(args, query.AuthorID)
+	}
+	if !query.From.IsZero() {
+		filters = append(filters, "posts.pubDate >= ?")
+		args = append(args, query.From.UnixMilli())
+	}
+	if !query.To.IsZero() {
+		filters = append(filters, "posts.pubDate < ?")
+		args = append(args, query.To.UnixMilli())
+	}
+
+	cursorFilter := "1"
+	if cursor != nil {
+		cursorFilter = "(rank < ? OR (rank = ? AND id < ?))"
+		args = append(args, cursor.Rank, cursor.Rank, cursor.ID)
+	}
+
+	args = append(args, query.Limit)
+
+	// bm25 is lower for the better matches
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+postColumns+`, rank, snippet FROM (
+			SELECT posts.id AS id, posts.pubDate AS pubDate, posts.editDate AS editDate, posts.userId AS userId,
+				posts.userDisplayName AS userDisplayName, posts.boardId AS boardId,
+				-bm25(postsSearch) AS rank, snippet(postsSearch, 1, ?, ?, '…', 20) AS snippet
+			FROM postsSearch JOIN posts ON posts.id = postsSearch.postId
+			WHERE `+strings.Join(filters, " AND ")+`
+		)
+		WHERE `+cursorFilter+`
+		ORDER BY rank DESC, id DESC
+		LIMIT ?`,
+		args...,
+	)
+	if err != nil {
+		return []SearchResult{}, "", err
+	}
+
+	defer rows.Close()
+
+	results := []SearchResult{}
+	var lastRank float64
+	for rows.Next() {
+		var snippet string
+		post, err := scanSQLitePost(rows, &lastRank, &snippet)
+		if err != nil {
+			return []SearchResult{}, "", err
+		}
+
+		results = append(results, SearchResult{
+			Post:    post,
+			Rank:    float32(lastRank),
+			Snippet: markSnippet(snippet),
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return []SearchResult{}, "", err
+	}
+
+	nextCursor := ""
+	if len(results) > 0 && uint32(len(results)) == query.Limit {
+		nextCursor, err = encodeSearchCursor(lastRank, results[len(results)-1].ID)
+		if err != nil {
+			return []SearchResult{}, "", err
+		}
+	}
+
+	return results, nextCursor, nil
+}
+
+func (s *SQLiteStore) AddUploads(ctx context.Context, names []string, userId string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	uploadDate := time.Now().UnixMilli()
+	for _, name := range names {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO uploads(name, userId, uploadDate) VALUES(?, ?, ?)", name, userId, uploadDate); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) DeleteExpiredUploads(ctx context.Context, maxAge time.Duration) ([]string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "DELETE FROM uploads WHERE uploadDate < ? RETURNING name", time.Now().Add(-maxAge).UnixMilli())
+	if err != nil {
+		return []string{}, err
+	}
+
+	return scanSQLiteStrings(rows)
+}
+
+func sqliteClaimUploads(ctx context.Context, tx *sql.Tx, userId string, names []string) ([]string, error) {
+	if len(names) == 0 {
+		return []string{}, nil
+	}
+
+	rows, err := tx.QueryContext(
+		ctx,
+		"DELETE FROM uploads WHERE userId=? AND name IN ("+sqlitePlaceholders(len(names))+") RETURNING name",
+		append([]any{userId}, toAnySlice(names)...)...,
+	)
+	if err != nil {
+		return []string{}, err
+	}
+
+	return scanSQLiteStrings(rows)
+}
+
+// Collects the rows of one text column and closes them
+func scanSQLiteStrings(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return []string{}, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
+func (s *SQLiteStore) GetImageReferences(ctx context.Context) ([]ImageReference, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, COALESCE(attachedImages, '') FROM posts UNION ALL SELECT '', name FROM uploads")
+	if err != nil {
+		return []ImageReference{}, err
+	}
+
+	defer rows.Close()
+
+	references := []ImageReference{}
+	for rows.Next() {
+		var postId, attachedImgs string
+		if err := rows.Scan(&postId, &attachedImgs); err != nil {
+			return []ImageReference{}, err
+		}
+
+		for _, img := range splitImages(attachedImgs) {
+			references = append(references, ImageReference{Name: img, PostID: postId})
+		}
+	}
+
+	return references, rows.Err()
+}
+
+func (s *SQLiteStore) IsAdmin(ctx context.Context, email string) bool {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var isAdmin bool
+	if s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM admins WHERE gmail=?)", email).Scan(&isAdmin) != nil {
+		return false
+	}
+
+	return isAdmin
+}
+
+func (s *SQLiteStore) IsInBlacklist(ctx context.Context, email string) bool {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var inBlacklist bool
+	if s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM blacklist WHERE gmail=?)", email).Scan(&inBlacklist) != nil {
+		return false
+	}
+
+	return inBlacklist
+}
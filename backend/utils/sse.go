@@ -2,8 +2,10 @@ package utils
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,48 +14,195 @@ import (
 	"github.com/gofiber/fiber/v3/middleware/etag"
 	"github.com/gofiber/fiber/v3/middleware/helmet"
 	"github.com/gofiber/fiber/v3/middleware/limiter"
+	"github.com/google/uuid"
 	"github.com/valyala/fasthttp"
 )
 
+// How many messages can wait to be written to a client.
+// A client that falls further behind is evicted, so broadcasts never wait for it
+const clientQueueSize = 256
+
+// Reasons the clients are evicted with
+const (
+	evictQueueFull = "queue is full"
+)
+
 type sseServer struct {
-	serverClose chan struct{}
-	clients     []client
-	mutex       sync.Mutex
+	clients map[*client]struct{}
+	// Random id of the server, which is a part of the event ids,
+	// so the events are replayed only by the server that sent them
+	instance string
+	// Recent events for the clients that reconnect with Last-Event-ID
+	replay eventBuffer
+	// Shares the events with the other instances of the backend, nil if there is one instance
+	fanout *pgFanout
+	// Users connected to this instance
+	presence *presenceTracker
+	mutex    sync.Mutex
+}
+
+// Clients an event is sent to
+type eventTarget struct {
+	// The event is sent only to the clients that follow the board if it's set.
+	// An empty BoardID stands for the posts without a board
+	BoardScoped bool   `json:"boardScoped,omitempty"`
+	BoardID     string `json:"boardId,omitempty"`
+	// The event is sent only to the clients subscribed to the topic, e.g. post:<id>
+	Topic string `json:"topic,omitempty"`
+	// The event isn't kept for the replay and has no id
+	Transient bool `json:"transient,omitempty"`
+	// The event is sent only to the clients of the user if it's set
+	UserID string `json:"userId,omitempty"`
+	// The event is sent only to the clients of the admins
+	Admins bool `json:"admins,omitempty"`
+}
+
+func (t eventTarget) matches(c *client) bool {
+	if t.UserID != "" {
+		return c.user.ID == t.UserID
+	}
+
+	if t.Admins {
+		return c.user.Admin
+	}
+
+	if t.Topic != "" {
+		return slices.Contains(c.topics, t.Topic)
+	}
+
+	return !t.BoardScoped || c.boards == nil || slices.Contains(c.boards, t.BoardID)
+}
+
+// Format of the messages written to a client
+type clientFormat int
+
+const (
+	// SSE messages with the event names and the JSON envelopes
+	formatSSE clientFormat = iota
+	// SSE messages in the legacy "type;data" format
+	formatLegacySSE
+	// Only the JSON envelopes, for the transports that have their own framing
+	formatEnvelope
+)
+
+// Authenticated user of a client
+type ClientUser struct {
+	ID          string
+	DisplayName string
+	Admin       bool
 }
 
 type client struct {
-	writer      *bufio.Writer
-	ctx         *fasthttp.RequestCtx
-	sendMessage chan []byte
+	user ClientUser
+	// Messages waiting to be written to the client
+	queue chan []byte
+	// Closed when the client is evicted, evictReason is set before that
+	evicted     chan struct{}
+	evictReason string
+	// Ids of the boards the client follows, nil if the client follows everything
+	boards []string
+	// Topics the client is subscribed to
+	topics []string
+	format clientFormat
 }
 
 func NewSSEServer() sseServer {
 	return sseServer{
-		clients: []client{},
-		mutex:   sync.Mutex{},
+		clients:  map[*client]struct{}{},
+		instance: strings.ReplaceAll(uuid.NewString(), "-", "")[:12],
+		replay:   newEventBuffer(replayBufferSize),
+		presence: newPresenceTracker(presenceLeaveDelay),
+		mutex:    sync.Mutex{},
 	}
 }
 
-func (a *client) SendMessage(b []byte) error {
-	n, err := a.writer.Write(b)
+func writeMessage(w *bufio.Writer, b []byte) error {
+	n, err := w.Write(b)
 	if err != nil || n == 0 {
 		return fmt.Errorf("%s %s", err, "or n=0")
 	}
 
-	if err := a.writer.Flush(); err != nil {
-		return err
+	return w.Flush()
+}
+
+// Registers a client. If lastEventId isn't empty, the messages of the events the client missed
+// are returned. They are collected together with adding the client, so no event is either
+// lost or sent twice
+func (a *sseServer) addClient(user ClientUser, boards []string, format clientFormat, lastEventId string) (*client, [][]byte) {
+	c := &client{
+		user:    user,
+		queue:   make(chan []byte, clientQueueSize),
+		evicted: make(chan struct{}),
+		boards:  boards,
+		topics:  []string{},
+		format:  format,
 	}
 
-	return nil
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.clients[c] = struct{}{}
+
+	if lastEventId == "" {
+		return c, [][]byte{}
+	}
+
+	return c, a.replayMessages(c, lastEventId)
 }
 
-func (a *client) DeleteFromList(server *sseServer) {
-	server.mutex.Lock()
-	defer server.mutex.Unlock()
-	for i, c := range server.clients {
-		if c.writer == a.writer {
-			server.clients = append(server.clients[:i], server.clients[i+1:]...)
-			break
+func (a *sseServer) removeClient(c *client) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	delete(a.clients, c)
+}
+
+// Removes the client and tells its writer to stop. Must be called with the mutex locked
+func (a *sseServer) evictClient(c *client, reason string) {
+	if _, ok := a.clients[c]; !ok {
+		return
+	}
+
+	delete(a.clients, c)
+	c.evictReason = reason
+	close(c.evicted)
+}
+
+// Writes the messages of the client until it disconnects or is evicted
+func (a *sseServer) serveClient(c *client, w *bufio.Writer, missed [][]byte, done <-chan struct{}) {
+	for _, msg := range missed {
+		if err := writeMessage(w, msg); err != nil {
+			a.removeClient(c)
+			return
+		}
+	}
+
+	for {
+		select {
+		case msg := <-c.queue:
+			if err := writeMessage(w, msg); err != nil {
+				a.removeClient(c)
+				return
+			}
+		case <-time.After(20 * time.Second):
+			ping := []byte(": ping\n\n")
+			if c.format == formatLegacySSE {
+				ping = []byte("data: ping\n\n")
+			}
+
+			if err := writeMessage(w, ping); err != nil {
+				a.removeClient(c)
+				return
+			}
+		case <-c.evicted:
+			// The client may be too slow to read it, but there is nothing to lose
+			if event, err := newSSEEvent("", 0, evictedEvent(c.evictReason), eventTarget{}); err == nil {
+				writeMessage(w, event.messageFor(c))
+			}
+			return
+		case <-done:
+			a.removeClient(c)
+			return
 		}
 	}
 }
@@ -62,6 +211,30 @@ func (a *sseServer) FiberMiddleware() func(c fiber.Ctx) error {
 	return func(c fiber.Ctx) error {
 		ctx := c.Context()
 
+		// Set by the auth middleware, the values are copied as the stream outlives the request
+		userId, _ := c.Locals("uid").(string)
+		displayName, _ := c.Locals("displayName").(string)
+		admin, _ := c.Locals("admin").(bool)
+		user := ClientUser{
+			ID:          strings.Clone(userId),
+			DisplayName: strings.Clone(displayName),
+			Admin:       admin,
+		}
+
+		var boards []string
+		if boardsQuery := c.Query("boards", ""); boardsQuery != "" {
+			boards = strings.Split(boardsQuery, ",")
+		}
+
+		// The legacy format is used until the clients ask for the JSON envelope
+		format := formatLegacySSE
+		if c.Query("format", "legacy") == "json" {
+			format = formatSSE
+		}
+
+		// Set by the browser when it reconnects, the events it missed are sent again
+		lastEventId := c.Get("Last-Event-ID")
+
 		ctx.SetContentType("text/event-stream")
 		ctx.Response.Header.Set("Cache-Control", "no-cache")
 		ctx.Response.Header.Set("Connection", "keep-alive")
@@ -71,40 +244,11 @@ func (a *sseServer) FiberMiddleware() func(c fiber.Ctx) error {
 		ctx.Response.Header.Set("Access-Control-Allow-Credentials", "true")
 
 		c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
-			chMessage := make(chan []byte)
-			clientInstance := client{
-				writer:      w,
-				ctx:         ctx,
-				sendMessage: chMessage,
-			}
+			clientInstance, missed := a.addClient(user, boards, format, lastEventId)
+			a.trackConnect(user)
+			defer a.trackDisconnect(user)
 
-			a.mutex.Lock()
-			a.clients = append(a.clients, clientInstance)
-			a.mutex.Unlock()
-
-			for {
-				var msg []byte
-
-				select {
-				case msg = <-chMessage:
-					if err := clientInstance.SendMessage(msg); err != nil {
-						clientInstance.DeleteFromList(a)
-						return
-					}
-
-					break
-				case <-time.After(20 * time.Second):
-					if err := clientInstance.SendMessage([]byte("data: ping\n\n")); err != nil {
-						clientInstance.DeleteFromList(a)
-						return
-					}
-
-					break
-				case <-ctx.Done():
-					clientInstance.DeleteFromList(a)
-					return
-				}
-			}
+			a.serveClient(clientInstance, w, missed, ctx.Done())
 		}))
 
 		return nil
@@ -131,31 +275,173 @@ func (a *sseServer) FiberMiddlewaresSet() []func(c fiber.Ctx) error {
 	}
 }
 
-func (a *sseServer) SendBytes(b []byte) error {
-	sendData := append([]byte("data: "), append(b, []byte("\n\n")...)...)
+func (a *sseServer) Send(event Event) error {
+	return a.publish(event, eventTarget{})
+}
+
+// Sends the event only to the clients that follow the board.
+// Events without a board are sent only to the clients that follow everything
+func (a *sseServer) SendBoard(boardId string, event Event) error {
+	return a.publish(event, eventTarget{BoardScoped: true, BoardID: boardId})
+}
+
+// Sends the event to the clients subscribed to the topic. The event isn't replayed
+func (a *sseServer) SendToTopic(topic string, event Event) error {
+	return a.publish(event, eventTarget{Topic: topic, Transient: true})
+}
+
+// Sends the event only to the clients of the user, e.g. a notification
+func (a *sseServer) SendToUser(userId string, event Event) error {
+	if userId == "" {
+		return nil
+	}
+
+	return a.publish(event, eventTarget{UserID: userId})
+}
+
+// Sends the event only to the clients of the admins
+func (a *sseServer) SendToAdmins(event Event) error {
+	return a.publish(event, eventTarget{Admins: true})
+}
 
-	newClients := []client{}
-	defer func() {
-		a.clients = newClients
-	}()
+// Sends the event to the local clients and to the other instances
+func (a *sseServer) publish(event Event, target eventTarget) error {
+	if err := a.sendTo(event, target); err != nil {
+		return err
+	}
 
+	if a.fanout != nil {
+		return a.fanout.publish(event, target)
+	}
+
+	return nil
+}
+
+// Returns the messages of the events after lastEventId the client should receive,
+// or a resync event if they aren't in the buffer anymore or were sent by another server.
+// Must be called with the mutex locked
+func (a *sseServer) replayMessages(c *client, lastEventId string) [][]byte {
+	events, ok := []sseEvent{}, false
+	if instance, seqStr, found := strings.Cut(lastEventId, "-"); found && instance == a.instance {
+		if seq, err := strconv.ParseUint(seqStr, 10, 64); err == nil {
+			events, ok = a.replay.since(seq)
+		}
+	}
+
+	if !ok {
+		resync, err := newSSEEvent(a.instance, a.replay.lastId, resyncEvent(), eventTarget{})
+		if err != nil {
+			return [][]byte{}
+		}
+		return [][]byte{resync.messageFor(c)}
+	}
+
+	messages := [][]byte{}
+	for _, event := range events {
+		if event.target.matches(c) {
+			messages = append(messages, event.messageFor(c))
+		}
+	}
+
+	return messages
+}
+
+// Queues the event for the clients without waiting for them.
+// The clients whose queue is full are evicted
+func (a *sseServer) sendTo(e Event, target eventTarget) error {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
 
-	for _, c := range a.clients {
-		c.sendMessage <- sendData
+	var event sseEvent
+	var err error
+	if target.Transient {
+		event, err = newSSEEvent("", 0, e, target)
+	} else {
+		event, err = a.replay.push(a.instance, e, target)
+	}
+	if err != nil {
+		return err
+	}
+
+	for c := range a.clients {
+		if !target.matches(c) {
+			continue
+		}
 
-		newClients = append(newClients, c)
+		select {
+		case c.queue <- event.messageFor(c):
+		default:
+			a.evictClient(c, evictQueueFull)
+		}
 	}
 
 	return nil
 }
 
-func (a *sseServer) SendJSON(jsonData any) error {
-	jsonObject, err := json.Marshal(jsonData)
+// Connection of a client that is served outside of the SSE middleware, e.g. a WebSocket.
+// It receives the JSON envelopes of the events
+type Subscription struct {
+	server *sseServer
+	client *client
+}
+
+// Registers a client, the messages of the events it missed after lastEventId are returned,
+// as in FiberMiddleware
+func (a *sseServer) Subscribe(user ClientUser, boards []string, lastEventId string) (*Subscription, [][]byte) {
+	c, missed := a.addClient(user, boards, formatEnvelope, lastEventId)
+	a.trackConnect(user)
+
+	return &Subscription{server: a, client: c}, missed
+}
+
+// Messages waiting to be written to the client
+func (s *Subscription) Messages() <-chan []byte {
+	return s.client.queue
+}
+
+// Closed when the client is evicted
+func (s *Subscription) Evicted() <-chan struct{} {
+	return s.client.evicted
+}
+
+// Message that tells the client why it's evicted
+func (s *Subscription) EvictedMessage() []byte {
+	event, err := newSSEEvent("", 0, evictedEvent(s.client.evictReason), eventTarget{})
 	if err != nil {
-		return err
+		return nil
+	}
+
+	return event.envelope
+}
+
+// Changes the boards the client follows, nil follows everything
+func (s *Subscription) SetBoards(boards []string) {
+	s.server.mutex.Lock()
+	defer s.server.mutex.Unlock()
+
+	s.client.boards = boards
+}
+
+func (s *Subscription) SubscribeTopic(topic string) {
+	s.server.mutex.Lock()
+	defer s.server.mutex.Unlock()
+
+	if !slices.Contains(s.client.topics, topic) {
+		s.client.topics = append(s.client.topics, topic)
 	}
+}
+
+func (s *Subscription) UnsubscribeTopic(topic string) {
+	s.server.mutex.Lock()
+	defer s.server.mutex.Unlock()
+
+	s.client.topics = slices.DeleteFunc(s.client.topics, func(t string) bool {
+		return t == topic
+	})
+}
 
-	return a.SendBytes(jsonObject)
+// Removes the client, must be called when the connection is closed
+func (s *Subscription) Close() {
+	s.server.removeClient(s.client)
+	s.server.trackDisconnect(s.client.user)
 }
@@ -0,0 +1,411 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Broadcasts from several goroutines to thousands of clients, some of which never read.
+// The messages are sent in rounds, so the readers can't fall behind. Run with -race
+func TestSSEBroadcastSlowClients(t *testing.T) {
+	const readers = 2000
+	const stalled = 2000
+	const senders = 8
+	const messagesPerRound = 8
+	const rounds = clientQueueSize/(senders*messagesPerRound) + 2
+	const total = senders * messagesPerRound * rounds
+
+	server := NewSSEServer()
+
+	readerClients := make([]*client, readers)
+	for i := range readerClients {
+		readerClients[i], _ = server.addClient(ClientUser{}, nil, formatSSE, "")
+	}
+
+	stalledClients := make([]*client, stalled)
+	for i := range stalledClients {
+		stalledClients[i], _ = server.addClient(ClientUser{}, nil, formatSSE, "")
+	}
+
+	received := make([]atomic.Int64, readers)
+	for i, c := range readerClients {
+		go func() {
+			for {
+				select {
+				case <-c.queue:
+					received[i].Add(1)
+				case <-c.evicted:
+					return
+				}
+			}
+		}()
+	}
+
+	for round := 1; round <= rounds; round++ {
+		roundDone := make(chan struct{})
+		go func() {
+			wg := sync.WaitGroup{}
+			for s := 0; s < senders; s++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for m := 0; m < messagesPerRound; m++ {
+						server.Send(Event{Type: "test", Payload: fmt.Sprintf("%d-%d-%d", round, s, m)})
+					}
+				}()
+			}
+			wg.Wait()
+			close(roundDone)
+		}()
+
+		select {
+		case <-roundDone:
+		case <-time.After(10 * time.Second):
+			t.Fatal("broadcasts are blocked by the clients")
+		}
+
+		expected := int64(round * senders * messagesPerRound)
+		deadline := time.Now().Add(10 * time.Second)
+		for i := range received {
+			for received[i].Load() < expected {
+				if time.Now().After(deadline) {
+					t.Fatalf("reader %d received %d messages, expected %d", i, received[i].Load(), expected)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+
+	for i, c := range readerClients {
+		select {
+		case <-c.evicted:
+			t.Fatalf("reader %d is evicted with %q", i, c.evictReason)
+		default:
+		}
+
+		if count := received[i].Load(); count != total {
+			t.Fatalf("reader %d received %d messages, expected %d", i, count, total)
+		}
+	}
+
+	for i, c := range stalledClients {
+		select {
+		case <-c.evicted:
+			if c.evictReason != evictQueueFull {
+				t.Fatalf("stalled client %d is evicted with %q", i, c.evictReason)
+			}
+		default:
+			t.Fatalf("stalled client %d isn't evicted", i)
+		}
+	}
+
+	server.mutex.Lock()
+	connected := len(server.clients)
+	server.mutex.Unlock()
+	if connected != readers {
+		t.Errorf("%d clients are connected, expected %d", connected, readers)
+	}
+}
+
+// Connects and disconnects the clients while the messages are broadcasted
+func TestSSEClientsChurn(t *testing.T) {
+	server := NewSSEServer()
+	stop := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				server.SendBoard("board", Event{Type: "test"})
+			}
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, _ := server.addClient(ClientUser{}, []string{"board"}, formatSSE, "")
+			<-c.queue
+			server.removeClient(c)
+		}()
+	}
+
+	wg.Wait()
+	close(stop)
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if len(server.clients) != 0 {
+		t.Errorf("%d clients are left connected", len(server.clients))
+	}
+}
+
+func TestSSEReplay(t *testing.T) {
+	server := NewSSEServer()
+	eventId := func(seq uint64) string {
+		return server.instance + "-" + strconv.FormatUint(seq, 10)
+	}
+
+	server.SendBoard("a", Event{Type: "test", Payload: 1})
+	server.SendBoard("b", Event{Type: "test", Payload: 2})
+	server.SendBoard("a", Event{Type: "test", Payload: 3})
+
+	// Only the missed events of the followed board are replayed
+	_, missed := server.addClient(ClientUser{}, []string{"a"}, formatLegacySSE, eventId(1))
+	if len(missed) != 1 || !bytes.Contains(missed[0], []byte("data: test;3\n")) {
+		t.Errorf("replayed %q, expected only the third event", missed)
+	}
+
+	// The events of another server aren't known
+	for _, lastEventId := range []string{"other-1", "1", eventId(4)} {
+		_, missed = server.addClient(ClientUser{}, nil, formatSSE, lastEventId)
+		if len(missed) != 1 || !bytes.Contains(missed[0], []byte("event: resync\n")) {
+			t.Errorf("replayed %q after %q, expected a resync event", missed, lastEventId)
+		}
+	}
+
+	for i := 0; i < replayBufferSize; i++ {
+		server.Send(Event{Type: "test"})
+	}
+
+	_, missed = server.addClient(ClientUser{}, nil, formatSSE, eventId(3))
+	if len(missed) != replayBufferSize {
+		t.Errorf("replayed %d events, expected %d", len(missed), replayBufferSize)
+	}
+
+	_, missed = server.addClient(ClientUser{}, nil, formatSSE, eventId(2))
+	if len(missed) != 1 || !bytes.Contains(missed[0], []byte("event: resync\n")) {
+		t.Errorf("replayed %d events, expected a resync event as some events are overwritten", len(missed))
+	}
+}
+
+func TestSSETargets(t *testing.T) {
+	server := NewSSEServer()
+
+	user, _ := server.addClient(ClientUser{ID: "user"}, nil, formatSSE, "")
+	admin, _ := server.addClient(ClientUser{ID: "admin", Admin: true}, nil, formatSSE, "")
+	subscriber, _ := server.addClient(ClientUser{ID: "subscriber"}, nil, formatSSE, "")
+	subscriber.topics = []string{"post:1"}
+
+	server.SendToUser("user", Event{Type: "user"})
+	server.SendToAdmins(Event{Type: "admins"})
+	server.SendToTopic("post:1", Event{Type: "topic"})
+
+	expected := map[*client]string{user: "user", admin: "admins", subscriber: "topic"}
+	for c, eventType := range expected {
+		if len(c.queue) != 1 {
+			t.Errorf("%d messages are queued for %s, expected 1", len(c.queue), c.user.ID)
+			continue
+		}
+
+		if message := string(<-c.queue); !strings.Contains(message, "event: "+eventType+"\n") {
+			t.Errorf("%s received %q, expected the %s event", c.user.ID, message, eventType)
+		}
+	}
+
+	// The private events are replayed only to their user
+	_, missed := server.addClient(ClientUser{ID: "other"}, nil, formatSSE, server.instance+"-0")
+	if len(missed) != 0 {
+		t.Errorf("replayed %q to another user", missed)
+	}
+
+	_, missed = server.addClient(ClientUser{ID: "user"}, nil, formatSSE, server.instance+"-0")
+	if len(missed) != 1 || !bytes.Contains(missed[0], []byte("event: user\n")) {
+		t.Errorf("replayed %q, expected only the event of the user", missed)
+	}
+}
+
+// Passes the events between two servers the way the database does
+func TestFanoutReceive(t *testing.T) {
+	sender := NewSSEServer()
+	receiver := NewSSEServer()
+	fanout := &pgFanout{
+		server:    &receiver,
+		seen:      map[int64]struct{}{},
+		seenOrder: []int64{},
+	}
+
+	followsA, _ := receiver.addClient(ClientUser{}, []string{"a"}, formatSSE, "")
+	followsB, _ := receiver.addClient(ClientUser{}, []string{"b"}, formatLegacySSE, "")
+
+	data, err := encodeFanoutMessage(sender.instance, UpdateLikesEvent("post", 3), eventTarget{BoardScoped: true, BoardID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second delivery of the same event is skipped
+	for i := 0; i < 2; i++ {
+		if err := fanout.receive(1, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(followsA.queue) != 1 || len(followsB.queue) != 0 {
+		t.Fatalf("%d and %d messages are queued, expected 1 and 0", len(followsA.queue), len(followsB.queue))
+	}
+
+	message := string(<-followsA.queue)
+	if !strings.Contains(message, "event: updateLikes\n") || !strings.Contains(message, `"payload":{"postId":"post","likes":3}`) {
+		t.Errorf("unexpected message %q", message)
+	}
+
+	// The events of the receiver itself are already sent to its clients
+	data, err = encodeFanoutMessage(receiver.instance, UpdateBoardsEvent(), eventTarget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fanout.receive(2, data); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(followsA.queue) != 0 || len(followsB.queue) != 0 {
+		t.Errorf("the own event of the receiver is sent again")
+	}
+
+	data, err = encodeFanoutMessage(sender.instance, DeletePostEvent("post"), eventTarget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fanout.receive(3, data); err != nil {
+		t.Fatal(err)
+	}
+
+	if message := string(<-followsB.queue); !strings.HasSuffix(message, "data: delPost;post\n\n") {
+		t.Errorf("unexpected legacy message %q", message)
+	}
+}
+
+// The events are written into the outbox in the background, a database that hangs
+// and then fails doesn't hold up the local clients. Run with -race
+func TestFanoutPublishDoesNotBlock(t *testing.T) {
+	server := NewSSEServer()
+	release := make(chan struct{})
+	defer close(release)
+
+	writing := make(chan struct{}, 1)
+	server.fanout = newPgFanout(&server, func(batch [][]byte) error {
+		select {
+		case writing <- struct{}{}:
+		default:
+		}
+		<-release
+		return fmt.Errorf("the database is unavailable")
+	})
+
+	follower, _ := server.addClient(ClientUser{}, []string{"a"}, formatSSE, "")
+
+	var dropped atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range fanoutQueueSize + fanoutBatchSize + 10 {
+			if server.SendBoard("a", UpdateLikesEvent("post", 1)) == errFanoutQueueFull {
+				dropped.Add(1)
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sending is blocked by the fanout")
+	}
+
+	if message := string(<-follower.queue); !strings.Contains(message, "event: updateLikes\n") {
+		t.Errorf("unexpected local message %q", message)
+	}
+	if dropped.Load() == 0 {
+		t.Error("no event is dropped, expected the ones over the queue")
+	}
+	select {
+	case <-writing:
+	case <-time.After(5 * time.Second):
+		t.Error("the publisher doesn't write the events")
+	}
+}
+
+func TestEventMessages(t *testing.T) {
+	event, err := newSSEEvent("server", 42, UpdateLikesEvent("post", 3), eventTarget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := string(event.message)
+	if !strings.HasPrefix(message, "id: server-42\nevent: updateLikes\ndata: {\"type\":\"updateLikes\",\"id\":\"server-42\",\"timestamp\":") ||
+		!strings.HasSuffix(message, ",\"payload\":{\"postId\":\"post\",\"likes\":3}}\n\n") {
+		t.Errorf("unexpected message %q", message)
+	}
+
+	if legacy := string(event.legacyMessage); legacy != "id: server-42\ndata: updateLikes;post\n\n" {
+		t.Errorf("unexpected legacy message %q", legacy)
+	}
+
+	event, err = newSSEEvent("", 0, evictedEvent(evictQueueFull), eventTarget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if legacy := string(event.legacyMessage); legacy != "data: evicted;"+evictQueueFull+"\n\n" {
+		t.Errorf("unexpected legacy message %q", legacy)
+	}
+}
+
+func TestPresence(t *testing.T) {
+	server := NewSSEServer()
+	server.presence = newPresenceTracker(50 * time.Millisecond)
+	// Without the publisher the events shared with the other instances stay in the queue
+	server.fanout = &pgFanout{server: &server, queue: make(chan []byte, fanoutQueueSize)}
+
+	observer, _ := server.addClient(ClientUser{}, nil, formatSSE, "")
+	expectEvent := func(eventType string) {
+		select {
+		case message := <-observer.queue:
+			if !bytes.Contains(message, []byte("event: "+eventType+"\n")) {
+				t.Errorf("received %q, expected the %s event", message, eventType)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("the %s event isn't sent", eventType)
+		}
+	}
+
+	user := ClientUser{ID: "user", DisplayName: "User"}
+
+	// The second connection and a quick reconnect aren't announced
+	server.trackConnect(user)
+	server.trackConnect(user)
+	server.trackDisconnect(user)
+	server.trackDisconnect(user)
+	server.trackConnect(user)
+	time.Sleep(100 * time.Millisecond)
+
+	expectEvent(EventPresenceJoin)
+	if len(observer.queue) != 0 {
+		t.Errorf("%d more events are sent, expected only the join", len(observer.queue))
+	}
+
+	if presence := server.Presence(); len(presence) != 1 || presence[0] != (PresenceUser{"user", "User"}) {
+		t.Errorf("presence is %v, expected the user", presence)
+	}
+
+	server.trackDisconnect(user)
+	expectEvent(EventPresenceLeave)
+
+	if presence := server.Presence(); len(presence) != 0 {
+		t.Errorf("presence is %v after the leave, expected nobody", presence)
+	}
+
+	// The presence is instance-local
+	if len(server.fanout.queue) != 0 {
+		t.Errorf("%d presence events are shared with the other instances", len(server.fanout.queue))
+	}
+}
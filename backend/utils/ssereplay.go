@@ -0,0 +1,81 @@
+package utils
+
+// How many recent events are kept for the clients that reconnect
+const replayBufferSize = 1024
+
+type sseEvent struct {
+	id uint64
+	// JSON envelope of the event
+	envelope []byte
+	// Rendered SSE messages for the clients that use the JSON and the legacy format
+	message       []byte
+	legacyMessage []byte
+	// Clients the event is sent to
+	target eventTarget
+}
+
+// Ring buffer of the recent events. The ids increase by one with every event
+type eventBuffer struct {
+	events []sseEvent
+	// Index of the oldest event
+	start int
+	count int
+	// Id of the newest event
+	lastId uint64
+}
+
+func newEventBuffer(size int) eventBuffer {
+	return eventBuffer{
+		events: make([]sseEvent, size),
+	}
+}
+
+func (b *eventBuffer) push(instance string, e Event, target eventTarget) (sseEvent, error) {
+	event, err := newSSEEvent(instance, b.lastId+1, e, target)
+	if err != nil {
+		return sseEvent{}, err
+	}
+	b.lastId++
+
+	if b.count < len(b.events) {
+		b.events[(b.start+b.count)%len(b.events)] = event
+		b.count++
+	} else {
+		b.events[b.start] = event
+		b.start = (b.start + 1) % len(b.events)
+	}
+
+	return event, nil
+}
+
+// Returns the events that came after the event with the id.
+// ok is false if some of them aren't in the buffer anymore or the id is unknown
+func (b *eventBuffer) since(id uint64) (events []sseEvent, ok bool) {
+	if id > b.lastId {
+		return nil, false
+	}
+
+	missed := b.lastId - id
+	if missed > uint64(b.count) {
+		return nil, false
+	}
+
+	events = make([]sseEvent, 0, missed)
+	for i := b.count - int(missed); i < b.count; i++ {
+		events = append(events, b.events[(b.start+i)%len(b.events)])
+	}
+
+	return events, true
+}
+
+// Returns the message in the format the client uses
+func (e sseEvent) messageFor(c *client) []byte {
+	switch c.format {
+	case formatLegacySSE:
+		return e.legacyMessage
+	case formatEnvelope:
+		return e.envelope
+	default:
+		return e.message
+	}
+}
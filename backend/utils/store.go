@@ -0,0 +1,270 @@
+package utils
+
+import (
+	"context"
+	"time"
+)
+
+// Storage of the posts, their likes and reactions, the pending uploads and the roles of the users.
+// PgStore keeps them in Postgres, SQLiteStore in a SQLite file and MemoryStore in memory for the tests.
+// The errors are the same for all of them, e.g. pgx.ErrNoRows for a post that doesn't exist.
+// The calls stop when the context is done or after DB_QUERY_TIMEOUT, IsUnavailable tells these errors apart
+type Store interface {
+	AddPost(ctx context.Context, post Post, referencedImages []string) (Post, error)
+	// Moves the post of the user to the trash
+	DeletePost(ctx context.Context, postId string, userId string, reason string) error
+	// Moves any post to the trash and returns the id of its author
+	DeletePostAdmin(ctx context.Context, postId string, adminId string, reason string) (authorId string, err error)
+	// Takes the post out of the trash, ErrForbidden if the user isn't allowed to restore it
+	RestorePost(ctx context.Context, postId string, userId string, asAdmin bool) (Post, error)
+	// Returns a page of the trashed posts of the user, of all users if userId is empty
+	GetTrash(ctx context.Context, userId string, query FeedQuery) (FeedPage, error)
+	// Deletes the posts that are in the trash longer than maxAge and returns the images of all their versions
+	PurgeTrash(ctx context.Context, maxAge time.Duration) ([]string, error)
+	// Returns a page of the feed, ErrInvalidCursor if the cursor of the query isn't valid
+	GetPosts(ctx context.Context, query FeedQuery) (FeedPage, error)
+	GetPostContent(ctx context.Context, postId string) (string, error)
+	GetPostAuthor(ctx context.Context, postId string) (string, error)
+	GetPostsMeta(ctx context.Context, postIds []string, userId string) (map[string]PostMeta, error)
+	SearchPosts(ctx context.Context, query SearchQuery) (results []SearchResult, nextCursor string, err error)
+
+	AddReaction(ctx context.Context, userId string, postId string, reaction string) (ReactionCounts, error)
+	RemoveReaction(ctx context.Context, userId string, postId string, reaction string) (ReactionCounts, error)
+	GetPostReactions(ctx context.Context, postId string, userId string) (PostReactions, error)
+	GetPostLikes(ctx context.Context, postId string) (uint64, error)
+	CheckUserLikedPost(ctx context.Context, userId string, postId string) (bool, error)
+	GetReactionTypes(ctx context.Context) ([]ReactionType, error)
+	SetReactionType(ctx context.Context, reactionType ReactionType) error
+	DeleteReactionType(ctx context.Context, emoji string) error
+
+	AddUploads(ctx context.Context, names []string, userId string) error
+	// Deletes the uploads older than maxAge and returns their image names
+	DeleteExpiredUploads(ctx context.Context, maxAge time.Duration) ([]string, error)
+	GetImageReferences(ctx context.Context) ([]ImageReference, error)
+
+	IsAdmin(ctx context.Context, email string) bool
+	IsInBlacklist(ctx context.Context, email string) bool
+}
+
+// Comments, boards and the revisions of the posts. PgStore and MemoryStore have them, SQLiteStore
+// keeps the tables of the Store only. The errors are the same as the ones of the Store
+type ThreadStore interface {
+	// Adds a comment or a reply, pgx.ErrNoRows if the post or the parent comment doesn't exist
+	AddComment(ctx context.Context, comment Comment) (Comment, error)
+	// Deletes the comment of the user and the replies to it, returns the id of the post
+	DeleteComment(ctx context.Context, commentId string, userId string) (postId string, err error)
+	// Deletes any comment and the replies to it, returns the id of the post and of the author
+	DeleteCommentAdmin(ctx context.Context, commentId string) (postId string, authorId string, err error)
+	GetCommentAuthor(ctx context.Context, commentId string) (string, error)
+	// Returns a page of the comments of the post or of the replies to the parent, the oldest first
+	GetComments(ctx context.Context, postId string, parentId string, cursor string, count uint32) (CommentPage, error)
+
+	GetBoards(ctx context.Context) ([]Board, error)
+	GetBoard(ctx context.Context, boardId string) (Board, error)
+	// Adds the board, ErrBoardSlugTaken if another board has its slug
+	AddBoard(ctx context.Context, board Board) (Board, error)
+	UpdateBoard(ctx context.Context, board Board) error
+	DeleteBoard(ctx context.Context, boardId string) error
+	// Returns a page of the feed of the board, empty if there's no board with the slug
+	GetBoardPosts(ctx context.Context, boardSlug string, query FeedQuery) (FeedPage, error)
+
+	// Replaces the content of the post, ErrForbidden if the editor isn't allowed to edit it
+	EditPost(ctx context.Context, postId string, edit PostEdit, asAdmin bool) (Post, error)
+	RestorePostRevision(ctx context.Context, postId string, revisionId string, editorId string, editorDisplayName string, asAdmin bool) (Post, error)
+	// Returns the revisions of the post, the newest first
+	GetPostRevisions(ctx context.Context, postId string) ([]PostRevision, error)
+}
+
+// Store the server runs on, with its schema migrations
+type Database interface {
+	Store
+	MigrationStatus() ([]MigrationState, error)
+	MigrateUp() ([]Migration, error)
+	MigrateDown(steps int) ([]Migration, error)
+	Close()
+}
+
+// Opens the database of DB_ADDRESS. The addresses with the sqlite: scheme are SQLite files,
+// e.g. sqlite:///var/lib/threadhelp/threadhelp.db, the others are Postgres connection strings
+func OpenDatabase(cs *CacheStorage) (Database, error) {
+	if path, ok := sqlitePath(DBADDRESS); ok {
+		return OpenSQLite(path)
+	}
+
+	if err := InitDB(cs); err != nil {
+		return nil, err
+	}
+
+	return PgStore{}, nil
+}
+
+// Store of the database connected by InitDB
+type PgStore struct{}
+
+func (PgStore) AddPost(ctx context.Context, post Post, referencedImages []string) (Post, error) {
+	return AddPost(ctx, post, referencedImages)
+}
+
+func (PgStore) DeletePost(ctx context.Context, postId string, userId string, reason string) error {
+	return DeletePost(ctx, postId, userId, reason)
+}
+
+func (PgStore) DeletePostAdmin(ctx context.Context, postId string, adminId string, reason string) (string, error) {
+	return DeletePostAdmin(ctx, postId, adminId, reason)
+}
+
+func (PgStore) RestorePost(ctx context.Context, postId string, userId string, asAdmin bool) (Post, error) {
+	return RestorePost(ctx, postId, userId, asAdmin)
+}
+
+func (PgStore) GetTrash(ctx context.Context, userId string, query FeedQuery) (FeedPage, error) {
+	return GetTrash(ctx, userId, query)
+}
+
+func (PgStore) PurgeTrash(ctx context.Context, maxAge time.Duration) ([]string, error) {
+	return PurgeTrash(ctx, maxAge)
+}
+
+func (PgStore) GetPosts(ctx context.Context, query FeedQuery) (FeedPage, error) {
+	return GetPosts(ctx, query)
+}
+
+func (PgStore) GetPostContent(ctx context.Context, postId string) (string, error) {
+	return GetPostContent(ctx, postId)
+}
+
+func (PgStore) GetPostAuthor(ctx context.Context, postId string) (string, error) {
+	return GetPostAuthor(ctx, postId)
+}
+
+func (PgStore) GetPostsMeta(ctx context.Context, postIds []string, userId string) (map[string]PostMeta, error) {
+	return GetPostsMeta(ctx, postIds, userId)
+}
+
+func (PgStore) SearchPosts(ctx context.Context, query SearchQuery) ([]SearchResult, string, error) {
+	return SearchPosts(ctx, query)
+}
+
+func (PgStore) AddReaction(ctx context.Context, userId string, postId string, reaction string) (ReactionCounts, error) {
+	return AddReaction(ctx, userId, postId, reaction)
+}
+
+func (PgStore) RemoveReaction(ctx context.Context, userId string, postId string, reaction string) (ReactionCounts, error) {
+	return RemoveReaction(ctx, userId, postId, reaction)
+}
+
+func (PgStore) GetPostReactions(ctx context.Context, postId string, userId string) (PostReactions, error) {
+	return GetPostReactions(ctx, postId, userId)
+}
+
+func (PgStore) GetPostLikes(ctx context.Context, postId string) (uint64, error) {
+	return GetPostLikes(ctx, postId)
+}
+
+func (PgStore) CheckUserLikedPost(ctx context.Context, userId string, postId string) (bool, error) {
+	return CheckUserLikedPost(ctx, userId, postId)
+}
+
+func (PgStore) GetReactionTypes(ctx context.Context) ([]ReactionType, error) {
+	return GetReactionTypes(ctx)
+}
+
+func (PgStore) SetReactionType(ctx context.Context, reactionType ReactionType) error {
+	return SetReactionType(ctx, reactionType)
+}
+
+func (PgStore) DeleteReactionType(ctx context.Context, emoji string) error {
+	return DeleteReactionType(ctx, emoji)
+}
+
+func (PgStore) AddUploads(ctx context.Context, names []string, userId string) error {
+	return AddUploads(ctx, names, userId)
+}
+
+func (PgStore) DeleteExpiredUploads(ctx context.Context, maxAge time.Duration) ([]string, error) {
+	return DeleteExpiredUploads(ctx, maxAge)
+}
+
+func (PgStore) GetImageReferences(ctx context.Context) ([]ImageReference, error) {
+	return GetImageReferences(ctx)
+}
+
+func (PgStore) IsAdmin(ctx context.Context, email string) bool {
+	return IsAdmin(ctx, email)
+}
+
+func (PgStore) IsInBlacklist(ctx context.Context, email string) bool {
+	return IsInBlacklist(ctx, email)
+}
+
+func (PgStore) AddComment(ctx context.Context, comment Comment) (Comment, error) {
+	return AddComment(ctx, comment)
+}
+
+func (PgStore) DeleteComment(ctx context.Context, commentId string, userId string) (string, error) {
+	return DeleteComment(ctx, commentId, userId)
+}
+
+func (PgStore) DeleteCommentAdmin(ctx context.Context, commentId string) (string, string, error) {
+	return DeleteCommentAdmin(ctx, commentId)
+}
+
+func (PgStore) GetCommentAuthor(ctx context.Context, commentId string) (string, error) {
+	return GetCommentAuthor(ctx, commentId)
+}
+
+func (PgStore) GetComments(ctx context.Context, postId string, parentId string, cursor string, count uint32) (CommentPage, error) {
+	return GetComments(ctx, postId, parentId, cursor, count)
+}
+
+func (PgStore) GetBoards(ctx context.Context) ([]Board, error) {
+	return GetBoards(ctx)
+}
+
+func (PgStore) GetBoard(ctx context.Context, boardId string) (Board, error) {
+	return GetBoard(ctx, boardId)
+}
+
+func (PgStore) AddBoard(ctx context.Context, board Board) (Board, error) {
+	return AddBoard(ctx, board)
+}
+
+func (PgStore) UpdateBoard(ctx context.Context, board Board) error {
+	return UpdateBoard(ctx, board)
+}
+
+func (PgStore) DeleteBoard(ctx context.Context, boardId string) error {
+	return DeleteBoard(ctx, boardId)
+}
+
+func (PgStore) GetBoardPosts(ctx context.Context, boardSlug string, query FeedQuery) (FeedPage, error) {
+	return GetBoardPosts(ctx, boardSlug, query)
+}
+
+func (PgStore) EditPost(ctx context.Context, postId string, edit PostEdit, asAdmin bool) (Post, error) {
+	return EditPost(ctx, postId, edit, asAdmin)
+}
+
+func (PgStore) RestorePostRevision(ctx context.Context, postId string, revisionId string, editorId string, editorDisplayName string, asAdmin bool) (Post, error) {
+	return RestorePostRevision(ctx, postId, revisionId, editorId, editorDisplayName, asAdmin)
+}
+
+func (PgStore) GetPostRevisions(ctx context.Context, postId string) ([]PostRevision, error) {
+	return GetPostRevisions(ctx, postId)
+}
+
+func (PgStore) MigrationStatus() ([]MigrationState, error) {
+	return MigrationStatus()
+}
+
+func (PgStore) MigrateUp() ([]Migration, error) {
+	return MigrateUp()
+}
+
+func (PgStore) MigrateDown(steps int) ([]Migration, error) {
+	return MigrateDown(steps)
+}
+
+func (PgStore) Close() {
+	CloseDB()
+}
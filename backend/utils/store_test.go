@@ -0,0 +1,996 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Adds the email to the admins or to the blacklist of the store
+type addRoleFunc func(t *testing.T, role string, email string)
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	testStore(t, store, func(t *testing.T, role string, email string) {
+		if role == "admins" {
+			store.AddAdmin(email)
+		} else {
+			store.AddToBlacklist(email)
+		}
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	store, err := OpenSQLite(t.TempDir() + "/threadhelp.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, store, func(t *testing.T, role string, email string) {
+		if _, err := store.db.Exec("INSERT INTO "+role+"(gmail) VALUES(?)", email); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestPgStore(t *testing.T) {
+	ctx := context.Background()
+
+	testDB(t)
+
+	// The store is checked from scratch
+	if _, err := db.Exec(ctx, "TRUNCATE posts, uploads, admins, blacklist, boards CASCADE"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, "DELETE FROM reactionTypes WHERE emoji<>$1", DefaultReaction); err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, PgStore{}, func(t *testing.T, role string, email string) {
+		if _, err := db.Exec(ctx, "INSERT INTO "+role+"(gmail) VALUES($1)", email); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	ctx := context.Background()
+
+	pgMigrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sqliteMigrations, err := loadMigrations(sqliteMigrationFiles, "migrations/sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both databases have the same schema versions
+	if len(pgMigrations) != len(sqliteMigrations) {
+		t.Fatalf("%d SQLite migrations, expected %d", len(sqliteMigrations), len(pgMigrations))
+	}
+	for i := range pgMigrations {
+		if pgMigrations[i].String() != sqliteMigrations[i].String() {
+			t.Errorf("SQLite migration %s, expected %s", sqliteMigrations[i], pgMigrations[i])
+		}
+	}
+
+	store, err := OpenSQLite(t.TempDir() + "/threadhelp.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	post, err := store.AddPost(ctx, Post{UserID: "author", Content: "migrated"}, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddReaction(ctx, "user", post.ID, DefaultReaction); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every migration can be reverted and applied again, the likes are kept
+	reverted, err := store.MigrateDown(len(sqliteMigrations) - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != len(sqliteMigrations)-1 {
+		t.Errorf("%d migrations reverted, expected %d", len(reverted), len(sqliteMigrations)-1)
+	}
+
+	if _, err := store.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	if likes, err := store.GetPostLikes(ctx, post.ID); err != nil || likes != 1 {
+		t.Errorf("the post has %d likes after the migrations, expected 1 (%v)", likes, err)
+	}
+
+	states, err := store.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range states {
+		if state.AppliedAt == nil {
+			t.Errorf("migration %s isn't applied", state.Migration)
+		}
+	}
+}
+
+func TestSQLiteFeedSameDates(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := OpenSQLite(t.TempDir() + "/threadhelp.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 3 {
+		if _, err := store.AddPost(ctx, Post{UserID: "author", Content: "same date"}, []string{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.db.Exec("UPDATE posts SET pubDate=1000"); err != nil {
+		t.Fatal(err)
+	}
+
+	// None of the posts published at the same time is skipped
+	found := map[string]bool{}
+	query := FeedQuery{Limit: 1}
+	for range 4 {
+		page, err := store.GetPosts(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, post := range page.Posts {
+			found[post.ID] = true
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	if len(found) != 3 {
+		t.Errorf("found %d of 3 posts with the same date", len(found))
+	}
+}
+
+func TestCommentPage(t *testing.T) {
+	date := JSONTime(time.UnixMicro(1_700_000_000_000_001).UTC())
+	comments := []Comment{
+		{ID: "00000000-0000-0000-0000-000000000001", PubDate: date},
+		{ID: "00000000-0000-0000-0000-000000000002", PubDate: date},
+		{ID: "00000000-0000-0000-0000-000000000003", PubDate: date},
+	}
+
+	if page := newCommentPage(comments[:2], 2); len(page.Comments) != 2 || page.NextCursor != "" {
+		t.Errorf("the last page is %+v, expected no cursor", page)
+	}
+
+	page := newCommentPage(comments, 2)
+	if len(page.Comments) != 2 || page.NextCursor == "" {
+		t.Fatalf("the page is %+v, expected 2 comments and a cursor", page)
+	}
+
+	// The cursor keeps the position of the last comment, it doesn't have to exist anymore
+	cursor, err := decodeCursor(page.NextCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cursor.pubDate().Equal(time.Time(date)) || cursor.ID != comments[1].ID {
+		t.Errorf("the cursor is %+v, expected the position of %+v", cursor, comments[1])
+	}
+
+	for _, invalid := range []string{"invalid", comments[1].ID} {
+		if _, err := decodeCursor(invalid); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("decoding %q returned %v, expected ErrInvalidCursor", invalid, err)
+		}
+	}
+}
+
+// The only connection of SQLite is held by a transaction, the calls wait for it until the timeout
+func TestSQLiteUnavailable(t *testing.T) {
+	store, err := OpenSQLite(t.TempDir() + "/threadhelp.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	defaultTimeout := queryTimeout
+	queryTimeout = 50 * time.Millisecond
+	defer func() { queryTimeout = defaultTimeout }()
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := store.GetPosts(context.Background(), FeedQuery{Limit: 10}); !IsUnavailable(err) {
+		t.Errorf("expected the database to be unavailable, got %v", err)
+	}
+
+	// The calls stop with the context of the request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := store.GetPostContent(ctx, missingPostId); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the call to be canceled, got %v", err)
+	}
+}
+
+func TestSQLitePath(t *testing.T) {
+	cases := map[string]string{
+		"sqlite:///var/lib/threadhelp.db": "/var/lib/threadhelp.db",
+		"sqlite://threadhelp.db":          "threadhelp.db",
+		"sqlite:threadhelp.db":            "threadhelp.db",
+	}
+
+	for address, expected := range cases {
+		if path, ok := sqlitePath(address); !ok || path != expected {
+			t.Errorf("the path of %s is %q, expected %q", address, path, expected)
+		}
+	}
+
+	if _, ok := sqlitePath("postgres://user@localhost/threadhelp"); ok {
+		t.Error("a Postgres address is taken for SQLite")
+	}
+}
+
+// Checks the behavior every Store must have. The store must have no posts
+func testStore(t *testing.T, store Store, addRole addRoleFunc) {
+	t.Run("posts", func(t *testing.T) { testStorePosts(t, store) })
+	t.Run("feed", func(t *testing.T) { testStoreFeed(t, store) })
+	t.Run("trash", func(t *testing.T) { testStoreTrash(t, store) })
+	t.Run("reactions", func(t *testing.T) { testStoreReactions(t, store) })
+	t.Run("uploads", func(t *testing.T) { testStoreUploads(t, store) })
+	t.Run("search", func(t *testing.T) { testStoreSearch(t, store) })
+	t.Run("roles", func(t *testing.T) { testStoreRoles(t, store, addRole) })
+
+	if threads, ok := store.(ThreadStore); ok {
+		t.Run("boards", func(t *testing.T) { testStoreBoards(t, store, threads) })
+		t.Run("comments", func(t *testing.T) { testStoreComments(t, store, threads) })
+		t.Run("revisions", func(t *testing.T) { testStoreRevisions(t, store, threads) })
+	}
+}
+
+// Adds the posts one by one, so their publication dates differ
+func addTestPosts(t *testing.T, store Store, posts ...Post) []Post {
+	t.Helper()
+	ctx := context.Background()
+
+	added := []Post{}
+	for _, post := range posts {
+		time.Sleep(2 * time.Millisecond)
+
+		post, err := store.AddPost(ctx, post, []string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		added = append(added, post)
+	}
+
+	return added
+}
+
+// Deletes the posts at the end of the test, so the next tests start without them
+func deleteTestPosts(t *testing.T, store Store, posts []Post) {
+	ctx := context.Background()
+
+	t.Cleanup(func() {
+		for _, post := range posts {
+			store.DeletePostAdmin(ctx, post.ID, "admin", "")
+		}
+	})
+}
+
+const missingPostId = "00000000-0000-0000-0000-000000000000"
+
+func testStorePosts(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	posts := addTestPosts(t, store,
+		Post{UserID: "author", UserDisplayName: "Author", Content: "<p>first</p>", AttachedImages: []string{"first.webp"}},
+		Post{UserID: "author", UserDisplayName: "Author", Content: "<p>second</p>"},
+		Post{UserID: "other", UserDisplayName: "Other", Content: "<p>third</p>"},
+	)
+	deleteTestPosts(t, store, posts)
+
+	page, err := store.GetPosts(ctx, FeedQuery{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newest := page.Posts
+	if len(newest) != 2 || newest[0].ID != posts[2].ID || newest[1].ID != posts[1].ID {
+		t.Fatalf("unexpected newest posts %+v", newest)
+	}
+	if newest[0].UserDisplayName != "Other" || newest[0].Content != "" || time.Time(newest[0].PubDate).UnixMilli() != time.Time(posts[2].PubDate).UnixMilli() {
+		t.Errorf("unexpected metadata %+v of the post %+v", newest[0], posts[2])
+	}
+
+	page, err = store.GetPosts(ctx, FeedQuery{Cursor: page.NextCursor, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Posts) != 1 || page.Posts[0].ID != posts[0].ID || page.NextCursor != "" {
+		t.Errorf("unexpected page %+v after the newest posts", page)
+	}
+
+	if content, err := store.GetPostContent(ctx, posts[0].ID); err != nil || content != "<p>first</p>" {
+		t.Errorf("the content is %q (%v)", content, err)
+	}
+	if author, err := store.GetPostAuthor(ctx, posts[2].ID); err != nil || author != "other" {
+		t.Errorf("the author is %q (%v)", author, err)
+	}
+
+	if _, err := store.GetPostContent(ctx, missingPostId); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("the content of a missing post returned %v, expected pgx.ErrNoRows", err)
+	}
+	if _, err := store.GetPostAuthor(ctx, missingPostId); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("the author of a missing post returned %v, expected pgx.ErrNoRows", err)
+	}
+
+	// Only the author deletes the post without being an admin
+	if err := store.DeletePost(ctx, posts[0].ID, "other", ""); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("deleting a post of another user returned %v, expected pgx.ErrNoRows", err)
+	}
+
+	if err := store.DeletePost(ctx, posts[0].ID, "author", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	authorId, err := store.DeletePostAdmin(ctx, posts[2].ID, "admin", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorId != "other" {
+		t.Errorf("the deleted post was written by %q, expected other", authorId)
+	}
+
+	if _, err := store.DeletePostAdmin(ctx, posts[2].ID, "admin", ""); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("deleting a deleted post returned %v, expected pgx.ErrNoRows", err)
+	}
+
+	page, err = store.GetPosts(ctx, FeedQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Posts) != 1 || page.Posts[0].ID != posts[1].ID {
+		t.Errorf("unexpected posts %+v after the deletion", page.Posts)
+	}
+}
+
+func testStoreFeed(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	posts := []Post{}
+	for i := range 5 {
+		posts = append(posts, addTestPosts(t, store, Post{UserID: "author", Content: "post " + strconv.Itoa(i)})...)
+	}
+	deleteTestPosts(t, store, posts)
+
+	// The feed is read page by page from the newest post
+	read := func(query FeedQuery) FeedPage {
+		t.Helper()
+
+		page, err := store.GetPosts(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return page
+	}
+
+	ids := func(page FeedPage) []string {
+		ids := []string{}
+		for _, post := range page.Posts {
+			ids = append(ids, post.ID)
+		}
+		return ids
+	}
+
+	first := read(FeedQuery{Limit: 2})
+	if !slices.Equal(ids(first), []string{posts[4].ID, posts[3].ID}) || first.NextCursor == "" || first.PrevCursor == "" {
+		t.Fatalf("unexpected first page %+v", first)
+	}
+
+	// The deleted post of the cursor doesn't break the paging
+	if _, err := store.DeletePostAdmin(ctx, posts[3].ID, "admin", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	second := read(FeedQuery{Cursor: first.NextCursor, Limit: 2})
+	if !slices.Equal(ids(second), []string{posts[2].ID, posts[1].ID}) || second.NextCursor == "" {
+		t.Fatalf("unexpected second page %+v", second)
+	}
+
+	last := read(FeedQuery{Cursor: second.NextCursor, Limit: 2})
+	if !slices.Equal(ids(last), []string{posts[0].ID}) || last.NextCursor != "" {
+		t.Errorf("unexpected last page %+v", last)
+	}
+
+	// Back to the newer posts
+	newer := read(FeedQuery{Cursor: second.PrevCursor, Newer: true, Limit: 1})
+	if !slices.Equal(ids(newer), []string{posts[4].ID}) || newer.NextCursor == "" {
+		t.Errorf("unexpected newer page %+v", newer)
+	}
+
+	// Nothing is newer yet, the cursor stays for the next try
+	newest := read(FeedQuery{Cursor: first.PrevCursor, Newer: true, Limit: 10})
+	if len(newest.Posts) != 0 || newest.PrevCursor != first.PrevCursor {
+		t.Errorf("unexpected page %+v newer than the newest post", newest)
+	}
+
+	added := addTestPosts(t, store, Post{UserID: "author", Content: "new"})
+	deleteTestPosts(t, store, added)
+
+	newest = read(FeedQuery{Cursor: newest.PrevCursor, Newer: true, Limit: 10})
+	if !slices.Equal(ids(newest), []string{added[0].ID}) {
+		t.Errorf("unexpected new posts %+v", newest)
+	}
+
+	for _, query := range []FeedQuery{{Cursor: "invalid", Limit: 1}, {Newer: true, Limit: 1}} {
+		if _, err := store.GetPosts(ctx, query); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("reading the feed with %+v returned %v, expected ErrInvalidCursor", query, err)
+		}
+	}
+}
+
+func testStoreTrash(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	posts := addTestPosts(t, store,
+		Post{UserID: "trash-author", Content: "<p>mistake</p>"},
+		Post{UserID: "trash-author", Content: "<p>spam</p>"},
+		Post{UserID: "trash-other", Content: "<p>old</p>", AttachedImages: []string{"old.webp"}},
+	)
+	deleteTestPosts(t, store, posts)
+
+	if err := store.DeletePost(ctx, posts[0].ID, "trash-author", "typo"); err != nil {
+		t.Fatal(err)
+	}
+	if authorId, err := store.DeletePostAdmin(ctx, posts[1].ID, "admin", "spam"); err != nil || authorId != "trash-author" {
+		t.Fatalf("the deleted post was written by %q (%v), expected trash-author", authorId, err)
+	}
+
+	// The trashed posts are hidden like the deleted ones
+	if _, err := store.GetPostContent(ctx, posts[0].ID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("the content of a trashed post returned %v, expected pgx.ErrNoRows", err)
+	}
+	if _, err := store.AddReaction(ctx, "user", posts[0].ID, DefaultReaction); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("liking a trashed post returned %v, expected pgx.ErrNoRows", err)
+	}
+
+	trash, err := store.GetTrash(ctx, "trash-author", FeedQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash.Posts) != 2 || trash.Posts[0].ID != posts[1].ID || trash.Posts[1].ID != posts[0].ID {
+		t.Fatalf("unexpected trash %+v", trash)
+	}
+	if spam := trash.Posts[0]; spam.Content != "<p>spam</p>" || spam.Deletion == nil || spam.Deletion.UserID != "admin" || spam.Deletion.Reason != "spam" {
+		t.Errorf("unexpected trashed post %+v", spam)
+	}
+
+	if trash, err := store.GetTrash(ctx, "trash-other", FeedQuery{Limit: 10}); err != nil || len(trash.Posts) != 0 {
+		t.Errorf("unexpected trash %+v of another user (%v)", trash, err)
+	}
+
+	// The authors restore only the posts they deleted themselves
+	if _, err := store.RestorePost(ctx, posts[1].ID, "trash-author", false); !errors.Is(err, ErrForbidden) {
+		t.Errorf("restoring a post deleted by an admin returned %v, expected ErrForbidden", err)
+	}
+	if _, err := store.RestorePost(ctx, posts[0].ID, "trash-other", false); !errors.Is(err, ErrForbidden) {
+		t.Errorf("restoring a post of another user returned %v, expected ErrForbidden", err)
+	}
+
+	restored, err := store.RestorePost(ctx, posts[0].ID, "trash-author", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ID != posts[0].ID || restored.UserID != "trash-author" || restored.Deletion != nil {
+		t.Errorf("unexpected restored post %+v", restored)
+	}
+	if _, err := store.RestorePost(ctx, posts[0].ID, "trash-author", false); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("restoring a post that isn't in the trash returned %v, expected pgx.ErrNoRows", err)
+	}
+	if content, err := store.GetPostContent(ctx, posts[0].ID); err != nil || content != "<p>mistake</p>" {
+		t.Errorf("the content of the restored post is %q (%v)", content, err)
+	}
+
+	if _, err := store.RestorePost(ctx, posts[1].ID, "admin", true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the posts older than the retention are purged, with their images
+	if _, err := store.DeletePostAdmin(ctx, posts[2].ID, "admin", ""); err != nil {
+		t.Fatal(err)
+	}
+	if images, err := store.PurgeTrash(ctx, time.Hour); err != nil || slices.Contains(images, "old.webp") {
+		t.Errorf("purging the trash returned the images %v (%v) of a recent post", images, err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if images, err := store.PurgeTrash(ctx, time.Millisecond); err != nil || !slices.Contains(images, "old.webp") {
+		t.Errorf("purging the trash returned the images %v (%v), expected old.webp", images, err)
+	}
+	if trash, err := store.GetTrash(ctx, "trash-other", FeedQuery{Limit: 10}); err != nil || len(trash.Posts) != 0 {
+		t.Errorf("unexpected trash %+v after the purge (%v)", trash, err)
+	}
+}
+
+func testStoreReactions(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	posts := addTestPosts(t, store,
+		Post{UserID: "author", Content: "liked"},
+		Post{UserID: "author", Content: "other"},
+	)
+	deleteTestPosts(t, store, posts)
+	post := posts[0]
+
+	if err := store.SetReactionType(ctx, ReactionType{Emoji: "🎉", Position: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetReactionType(ctx, ReactionType{Emoji: "🔥", Position: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer store.DeleteReactionType(ctx, "🔥")
+
+	reactionTypes, err := store.GetReactionTypes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedTypes := []ReactionType{{DefaultReaction, 0}, {"🔥", 1}, {"🎉", 2}}
+	if !slices.Equal(reactionTypes, expectedTypes) {
+		t.Errorf("reaction types %v, expected %v", reactionTypes, expectedTypes)
+	}
+
+	if _, err := store.AddReaction(ctx, "user", post.ID, "🦀"); !errors.Is(err, ErrUnknownReaction) {
+		t.Errorf("adding an unknown reaction returned %v, expected ErrUnknownReaction", err)
+	}
+	if _, err := store.AddReaction(ctx, "user", missingPostId, DefaultReaction); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("reacting to a missing post returned %v, expected pgx.ErrNoRows", err)
+	}
+
+	for _, userId := range []string{"user", "other", "user"} {
+		if _, err := store.AddReaction(ctx, userId, post.ID, "🎉"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counts, err := store.AddReaction(ctx, "user", post.ID, DefaultReaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 2 || counts["🎉"] != 2 || counts[DefaultReaction] != 1 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+
+	if likes, err := store.GetPostLikes(ctx, post.ID); err != nil || likes != 1 {
+		t.Errorf("%d likes, expected 1 (%v)", likes, err)
+	}
+	if likes, err := store.GetPostLikes(ctx, missingPostId); err != nil || likes != 0 {
+		t.Errorf("%d likes of a missing post, expected 0 (%v)", likes, err)
+	}
+	if liked, err := store.CheckUserLikedPost(ctx, "user", post.ID); err != nil || !liked {
+		t.Errorf("the post isn't liked by the user (%v)", err)
+	}
+	if liked, err := store.CheckUserLikedPost(ctx, "other", post.ID); err != nil || liked {
+		t.Errorf("the post is liked by the other user (%v)", err)
+	}
+
+	reactions, err := store.GetPostReactions(ctx, post.ID, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(reactions.Mine, []string{"🎉", DefaultReaction}) || reactions.Counts["🎉"] != 2 {
+		t.Errorf("unexpected reactions %+v", reactions)
+	}
+
+	metas, err := store.GetPostsMeta(ctx, []string{post.ID, posts[1].ID, missingPostId}, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMetas := map[string]PostMeta{
+		post.ID:     {Content: "liked", Likes: 1, Liked: true},
+		posts[1].ID: {Content: "other"},
+	}
+	if len(metas) != len(expectedMetas) || metas[post.ID] != expectedMetas[post.ID] || metas[posts[1].ID] != expectedMetas[posts[1].ID] {
+		t.Errorf("unexpected metas %+v", metas)
+	}
+
+	counts, err = store.RemoveReaction(ctx, "other", post.ID, "🎉")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["🎉"] != 1 {
+		t.Errorf("unexpected counts %v after the removal", counts)
+	}
+
+	if err := store.DeleteReactionType(ctx, DefaultReaction); !errors.Is(err, ErrForbidden) {
+		t.Errorf("deleting the default reaction returned %v, expected ErrForbidden", err)
+	}
+	if err := store.DeleteReactionType(ctx, "🦀"); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("deleting an unknown reaction returned %v, expected pgx.ErrNoRows", err)
+	}
+
+	// The reactions are deleted together with their type
+	if err := store.DeleteReactionType(ctx, "🎉"); err != nil {
+		t.Fatal(err)
+	}
+
+	reactions, err = store.GetPostReactions(ctx, post.ID, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reactions.Counts) != 1 || !slices.Equal(reactions.Mine, []string{DefaultReaction}) {
+		t.Errorf("unexpected reactions %+v after the type is deleted", reactions)
+	}
+}
+
+func testStoreUploads(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	if err := store.AddUploads(ctx, []string{"mine.webp", "mine-thumb.webp"}, "author"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddUploads(ctx, []string{"foreign.webp"}, "other"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the pending uploads of the author are attached
+	post, err := store.AddPost(ctx, Post{UserID: "author", Content: "images"}, []string{"mine.webp", "foreign.webp", "unknown.webp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleteTestPosts(t, store, []Post{post})
+
+	if !slices.Equal(post.AttachedImages, []string{"mine.webp"}) {
+		t.Errorf("the post has the images %v, expected mine.webp", post.AttachedImages)
+	}
+
+	references, err := store.GetImageReferences(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(references, func(a ImageReference, b ImageReference) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	expected := []ImageReference{{"foreign.webp", ""}, {"mine-thumb.webp", ""}, {"mine.webp", post.ID}}
+	if !slices.Equal(references, expected) {
+		t.Errorf("image references %v, expected %v", references, expected)
+	}
+
+	if names, err := store.DeleteExpiredUploads(ctx, time.Hour); err != nil || len(names) != 0 {
+		t.Errorf("deleted the uploads %v that aren't expired (%v)", names, err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+
+	names, err := store.DeleteExpiredUploads(ctx, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"foreign.webp", "mine-thumb.webp"}) {
+		t.Errorf("deleted the uploads %v, expected the pending ones", names)
+	}
+}
+
+func testStoreSearch(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	posts := addTestPosts(t, store,
+		Post{UserID: "author", Content: "<p>The quick brown fox</p>"},
+		Post{UserID: "other", Content: "<p>A quick <b>dog</b> and a quick cat</p>"},
+		Post{UserID: "author", Content: "<p>Nothing to see</p>"},
+	)
+	deleteTestPosts(t, store, posts)
+
+	results, nextCursor, err := store.SearchPosts(ctx, SearchQuery{Query: "fox", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID != posts[0].ID || nextCursor != "" {
+		t.Fatalf("unexpected results %+v for fox", results)
+	}
+	if !strings.Contains(results[0].Snippet, "<mark>fox</mark>") || results[0].Content != "" {
+		t.Errorf("unexpected snippet %q", results[0].Snippet)
+	}
+
+	results, _, err = store.SearchPosts(ctx, SearchQuery{Query: "quick", AuthorID: "other", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID != posts[1].ID {
+		t.Errorf("unexpected results %+v of the other user", results)
+	}
+
+	// Both posts with the word are returned page by page
+	found := []string{}
+	cursor := ""
+	for range 3 {
+		results, nextCursor, err := store.SearchPosts(ctx, SearchQuery{Query: "quick", Cursor: cursor, Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, result := range results {
+			found = append(found, result.ID)
+		}
+
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+
+	slices.Sort(found)
+	expected := []string{posts[0].ID, posts[1].ID}
+	slices.Sort(expected)
+	if !slices.Equal(found, expected) {
+		t.Errorf("found the posts %v by pages, expected %v", found, expected)
+	}
+
+	if _, _, err := store.SearchPosts(ctx, SearchQuery{Query: "quick", Cursor: "invalid", Limit: 1}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("searching with an invalid cursor returned %v, expected ErrInvalidCursor", err)
+	}
+}
+
+func testStoreRoles(t *testing.T, store Store, addRole addRoleFunc) {
+	ctx := context.Background()
+
+	addRole(t, "admins", "admin@example.com")
+	addRole(t, "blacklist", "banned@example.com")
+
+	if !store.IsAdmin(ctx, "admin@example.com") || store.IsAdmin(ctx, "banned@example.com") {
+		t.Error("the admins are wrong")
+	}
+	if !store.IsInBlacklist(ctx, "banned@example.com") || store.IsInBlacklist(ctx, "admin@example.com") {
+		t.Error("the blacklist is wrong")
+	}
+}
+
+func testStoreBoards(t *testing.T, store Store, threads ThreadStore) {
+	ctx := context.Background()
+
+	news, err := threads.AddBoard(ctx, Board{Name: "News", Slug: "news", Position: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	help, err := threads.AddBoard(ctx, Board{Name: "Help", Slug: "help", Description: "Questions"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		threads.DeleteBoard(ctx, news.ID)
+		threads.DeleteBoard(ctx, help.ID)
+	})
+
+	if _, err := threads.AddBoard(ctx, Board{Name: "More news", Slug: "news"}); !errors.Is(err, ErrBoardSlugTaken) {
+		t.Errorf("adding a board with a taken slug returned %v, expected ErrBoardSlugTaken", err)
+	}
+
+	boards, err := threads.GetBoards(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(boards, []Board{help, news}) {
+		t.Errorf("boards are %+v, expected the help and the news", boards)
+	}
+
+	news.Name = "Announcements"
+	if err := threads.UpdateBoard(ctx, news); err != nil {
+		t.Fatal(err)
+	}
+	if board, err := threads.GetBoard(ctx, news.ID); err != nil || board != news {
+		t.Errorf("the board is %+v (%v) after the update, expected %+v", board, err, news)
+	}
+	if err := threads.UpdateBoard(ctx, Board{ID: news.ID, Name: "News", Slug: "help"}); !errors.Is(err, ErrBoardSlugTaken) {
+		t.Errorf("taking the slug of another board returned %v, expected ErrBoardSlugTaken", err)
+	}
+	if err := threads.UpdateBoard(ctx, Board{ID: missingPostId, Name: "News", Slug: "missing"}); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("updating a missing board returned %v, expected pgx.ErrNoRows", err)
+	}
+
+	posts := addTestPosts(t, store,
+		Post{UserID: "author", Content: "first news", BoardID: news.ID},
+		Post{UserID: "author", Content: "not on a board"},
+		Post{UserID: "author", Content: "second news", BoardID: news.ID},
+	)
+	deleteTestPosts(t, store, posts)
+
+	page, err := threads.GetBoardPosts(ctx, "news", FeedQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Posts) != 2 || page.Posts[0].ID != posts[2].ID || page.Posts[1].ID != posts[0].ID {
+		t.Errorf("the board has the posts %+v, expected the news", page.Posts)
+	}
+
+	if page, err := threads.GetBoardPosts(ctx, "missing", FeedQuery{Limit: 10}); err != nil || len(page.Posts) != 0 {
+		t.Errorf("a missing board has the posts %+v (%v)", page.Posts, err)
+	}
+
+	if err := threads.DeleteBoard(ctx, news.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := threads.DeleteBoard(ctx, news.ID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("deleting the deleted board returned %v, expected pgx.ErrNoRows", err)
+	}
+	if page, err := threads.GetBoardPosts(ctx, "news", FeedQuery{Limit: 10}); err != nil || len(page.Posts) != 0 {
+		t.Errorf("the deleted board has the posts %+v (%v)", page.Posts, err)
+	}
+}
+
+func testStoreComments(t *testing.T, store Store, threads ThreadStore) {
+	ctx := context.Background()
+
+	posts := addTestPosts(t, store, Post{UserID: "author", Content: "commented"}, Post{UserID: "author", Content: "other"})
+	deleteTestPosts(t, store, posts)
+	postId := posts[0].ID
+
+	addComment := func(comment Comment) Comment {
+		t.Helper()
+		time.Sleep(2 * time.Millisecond)
+
+		comment.PostID = postId
+		added, err := threads.AddComment(ctx, comment)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return added
+	}
+
+	first := addComment(Comment{UserID: "commenter", UserDisplayName: "Commenter", Content: "first"})
+	second := addComment(Comment{UserID: "author", Content: "second"})
+	reply := addComment(Comment{ParentID: first.ID, UserID: "author", Content: "reply"})
+
+	if _, err := threads.AddComment(ctx, Comment{PostID: missingPostId, UserID: "commenter", Content: "lost"}); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("commenting a missing post returned %v, expected pgx.ErrNoRows", err)
+	}
+	if _, err := threads.AddComment(ctx, Comment{PostID: posts[1].ID, ParentID: first.ID, UserID: "commenter", Content: "lost"}); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("replying to a comment of another post returned %v, expected pgx.ErrNoRows", err)
+	}
+
+	page, err := threads.GetComments(ctx, postId, "", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Comments) != 1 || page.Comments[0].ID != first.ID || page.Comments[0].Replies != 1 ||
+		page.Comments[0].UserDisplayName != "Commenter" || page.NextCursor == "" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+
+	page, err = threads.GetComments(ctx, postId, "", page.NextCursor, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Comments) != 1 || page.Comments[0].ID != second.ID || page.NextCursor != "" {
+		t.Errorf("unexpected next page %+v", page)
+	}
+
+	if page, err := threads.GetComments(ctx, postId, first.ID, "", 10); err != nil || len(page.Comments) != 1 || page.Comments[0].ID != reply.ID {
+		t.Errorf("the replies are %+v (%v), expected the reply", page.Comments, err)
+	}
+
+	if author, err := threads.GetCommentAuthor(ctx, reply.ID); err != nil || author != "author" {
+		t.Errorf("the author of the reply is %q (%v)", author, err)
+	}
+
+	// Only the author deletes the comment, the replies are deleted with it
+	if _, err := threads.DeleteComment(ctx, first.ID, "author"); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("deleting a comment of another user returned %v, expected pgx.ErrNoRows", err)
+	}
+	if deletedFrom, err := threads.DeleteComment(ctx, first.ID, "commenter"); err != nil || deletedFrom != postId {
+		t.Errorf("deleting the comment returned %q (%v), expected the post", deletedFrom, err)
+	}
+	if _, err := threads.GetCommentAuthor(ctx, reply.ID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("the reply of the deleted comment returned %v, expected pgx.ErrNoRows", err)
+	}
+
+	deletedFrom, authorId, err := threads.DeleteCommentAdmin(ctx, second.ID)
+	if err != nil || deletedFrom != postId || authorId != "author" {
+		t.Errorf("deleting the comment as an admin returned %q, %q (%v)", deletedFrom, authorId, err)
+	}
+
+	if _, err := threads.GetComments(ctx, postId, "", "invalid", 10); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("an invalid cursor returned %v, expected ErrInvalidCursor", err)
+	}
+}
+
+func testStoreRevisions(t *testing.T, store Store, threads ThreadStore) {
+	ctx := context.Background()
+
+	posts := addTestPosts(t, store, Post{UserID: "author", UserDisplayName: "Author", Content: "first version", AttachedImages: []string{"first.webp"}})
+	deleteTestPosts(t, store, posts)
+	postId := posts[0].ID
+
+	if _, err := threads.EditPost(ctx, postId, PostEdit{EditorID: "other", Content: "not mine"}, false); !errors.Is(err, ErrForbidden) {
+		t.Errorf("editing a post of another user returned %v, expected ErrForbidden", err)
+	}
+	if _, err := threads.EditPost(ctx, missingPostId, PostEdit{EditorID: "author", Content: "lost"}, false); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("editing a missing post returned %v, expected pgx.ErrNoRows", err)
+	}
+
+	// The image of the previous version is kept, the unknown one isn't attached
+	edited, err := threads.EditPost(ctx, postId, PostEdit{
+		EditorID:          "author",
+		EditorDisplayName: "Author",
+		Content:           "second version",
+		ReferencedImages:  []string{"first.webp", "unknown.webp"},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.ID != postId || edited.UserID != "author" || edited.EditDate == nil {
+		t.Errorf("unexpected edited post %+v", edited)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if _, err := threads.EditPost(ctx, postId, PostEdit{EditorID: "admin", EditorDisplayName: "Admin", Content: "third version"}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, err := store.GetPostContent(ctx, postId); err != nil || content != "third version" {
+		t.Errorf("the content is %q (%v) after the edits", content, err)
+	}
+
+	revisions, err := threads.GetPostRevisions(ctx, postId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Content != "second version" || revisions[0].EditorID != "admin" ||
+		!slices.Equal(revisions[0].AttachedImages, []string{"first.webp"}) || revisions[1].Content != "first version" {
+		t.Fatalf("unexpected revisions %+v", revisions)
+	}
+
+	if _, err := threads.RestorePostRevision(ctx, postId, revisions[1].ID, "other", "Other", false); !errors.Is(err, ErrForbidden) {
+		t.Errorf("restoring a revision of another user returned %v, expected ErrForbidden", err)
+	}
+	if _, err := threads.RestorePostRevision(ctx, postId, missingPostId, "author", "Author", false); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("restoring a missing revision returned %v, expected pgx.ErrNoRows", err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if _, err := threads.RestorePostRevision(ctx, postId, revisions[1].ID, "author", "Author", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, err := store.GetPostContent(ctx, postId); err != nil || content != "first version" {
+		t.Errorf("the content is %q (%v) after the restore", content, err)
+	}
+	if revisions, err := threads.GetPostRevisions(ctx, postId); err != nil || len(revisions) != 3 || revisions[0].Content != "third version" {
+		t.Errorf("unexpected revisions %+v (%v) after the restore", revisions, err)
+	}
+}
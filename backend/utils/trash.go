@@ -0,0 +1,210 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Deletion of a post that is in the trash
+type PostDeletion struct {
+	Date JSONTime `json:"time"`
+	// The author or the admin who deleted the post
+	UserID string `json:"userId"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Moves the post of the user to the trash
+func DeletePost(ctx context.Context, postId string, userId string, reason string) error {
+	_, err := trashPost(ctx, postId, userId, reason, false)
+	return err
+}
+
+// Moves any post to the trash and returns the id of its author
+func DeletePostAdmin(ctx context.Context, postId string, adminId string, reason string) (authorId string, err error) {
+	return trashPost(ctx, postId, adminId, reason, true)
+}
+
+// Marks the post as deleted by the user, only the author's posts unless asAdmin is true.
+// Returns pgx.ErrNoRows if there is no such post or it's already in the trash
+func trashPost(ctx context.Context, postId string, userId string, reason string, asAdmin bool) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer con.Release()
+
+	var authorId string
+	row := con.QueryRow(
+		ctx,
+		"UPDATE posts SET deletedAt=NOW(), deletedBy=$2, deleteReason=$3 WHERE id=$1 AND deletedAt IS NULL AND ($4 OR userId=$2) RETURNING userId",
+		postId, userId, reason, asAdmin,
+	)
+	if err := row.Scan(&authorId); err != nil {
+		return "", err
+	}
+
+	return authorId, nil
+}
+
+// Takes the post out of the trash and returns its metadata. The authors can only restore
+// the posts they deleted themselves, the posts deleted by an admin require asAdmin.
+// Returns pgx.ErrNoRows if the post isn't in the trash
+func RestorePost(ctx context.Context, postId string, userId string, asAdmin bool) (Post, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return Post{}, err
+	}
+	defer con.Release()
+
+	tx, err := con.Begin(ctx)
+	if err != nil {
+		return Post{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var authorId, deletedBy string
+	row := tx.QueryRow(ctx, "SELECT userId, deletedBy FROM posts WHERE id=$1 AND deletedAt IS NOT NULL FOR UPDATE", postId)
+	if err := row.Scan(&authorId, &deletedBy); err != nil {
+		return Post{}, err
+	}
+
+	if !asAdmin && (authorId != userId || deletedBy != userId) {
+		return Post{}, ErrForbidden
+	}
+
+	post, err := scanPost(tx.QueryRow(ctx, "UPDATE posts SET deletedAt=NULL, deletedBy=NULL, deleteReason='' WHERE id=$1 RETURNING "+postColumns, postId))
+	if err != nil {
+		return Post{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Post{}, err
+	}
+
+	return post, nil
+}
+
+// Returns a page of the trashed posts of the user, of all users if userId is empty.
+// The posts are ordered like the feed, with their content and deletion
+func GetTrash(ctx context.Context, userId string, query FeedQuery) (FeedPage, error) {
+	cursor, err := decodeFeedCursor(query)
+	if err != nil {
+		return FeedPage{}, err
+	}
+
+	sql, args := feedSQL(
+		postColumns+", content, deletedAt, deletedBy, deleteReason",
+		"deletedAt IS NOT NULL AND ($1='' OR userId=$1)",
+		[]any{userId}, cursor, query,
+	)
+
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return FeedPage{}, err
+	}
+	defer con.Release()
+
+	rows, err := con.Query(ctx, sql, args...)
+	if err != nil {
+		return FeedPage{}, err
+	}
+
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var content string
+		var deletion PostDeletion
+		var deletedAt pgtype.Timestamp
+		post, err := scanPost(rows, &content, &deletedAt, &deletion.UserID, &deletion.Reason)
+		if err != nil {
+			return FeedPage{}, err
+		}
+
+		post.Content = content
+		deletion.Date = JSONTime(deletedAt.Time)
+		post.Deletion = &deletion
+
+		posts = append(posts, post)
+	}
+
+	if err := rows.Err(); err != nil {
+		return FeedPage{}, err
+	}
+
+	return newFeedPage(posts, query), nil
+}
+
+// Deletes the posts that are in the trash longer than maxAge together with their revisions,
+// and returns the images of all their versions
+func PurgeTrash(ctx context.Context, maxAge time.Duration) ([]string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return []string{}, err
+	}
+	defer con.Release()
+
+	tx, err := con.Begin(ctx)
+	if err != nil {
+		return []string{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, "DELETE FROM posts WHERE deletedAt < NOW() - $1::interval RETURNING id, attachedImages", maxAge)
+	if err != nil {
+		return []string{}, err
+	}
+
+	postIds, images, err := scanPurgedPosts(rows)
+	if err != nil {
+		return []string{}, err
+	}
+
+	for _, postId := range postIds {
+		revisionsImages, err := deletePostRevisions(ctx, tx, postId)
+		if err != nil {
+			return []string{}, err
+		}
+
+		images = append(images, revisionsImages...)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return []string{}, err
+	}
+
+	return images, nil
+}
+
+func scanPurgedPosts(rows pgx.Rows) (postIds []string, images []string, err error) {
+	defer rows.Close()
+
+	postIds = []string{}
+	images = []string{}
+	for rows.Next() {
+		var postId, attachedImgs string
+		if err := rows.Scan(&postId, &attachedImgs); err != nil {
+			return []string{}, []string{}, err
+		}
+
+		postIds = append(postIds, postId)
+		images = append(images, splitImages(attachedImgs)...)
+	}
+
+	return postIds, images, rows.Err()
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Registers the uploaded images that aren't attached to any post yet
+func AddUploads(ctx context.Context, names []string, userId string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer con.Release()
+
+	_, err = con.Exec(ctx, "INSERT INTO uploads(name, userId) SELECT unnest($1::text[]), $2", names, userId)
+	return err
+}
+
+// Deletes the uploads older than maxAge and returns their image names
+func DeleteExpiredUploads(ctx context.Context, maxAge time.Duration) ([]string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	con, err := acquire(ctx)
+	if err != nil {
+		return []string{}, err
+	}
+	defer con.Release()
+
+	rows, err := con.Query(ctx, "DELETE FROM uploads WHERE uploadDate < NOW() - $1::interval RETURNING name", maxAge)
+	if err != nil {
+		return []string{}, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// Removes the images uploaded by the user from the pending uploads,
+// returns the names of the images that were pending
+func claimUploads(ctx context.Context, tx pgx.Tx, userId string, names []string) ([]string, error) {
+	if len(names) == 0 {
+		return []string{}, nil
+	}
+
+	rows, err := tx.Query(ctx, "DELETE FROM uploads WHERE userId=$1 AND name=ANY($2) RETURNING name", userId, names)
+	if err != nil {
+		return []string{}, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
@@ -1,71 +1,110 @@
 package main
 
 import (
-	"bytes"
-	"encoding/base64"
+	"context"
 	"encoding/json"
-	"fmt"
-	"image"
-	"image/jpeg"
-	"image/png"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"regexp"
 	"slices"
 	"strings"
-	"sync"
+	"threadhelpServer/imageproc"
+	"threadhelpServer/imagestore"
 	"threadhelpServer/providers"
 	"threadhelpServer/utils"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/gofiber/fiber/v3"
 	"github.com/gofiber/fiber/v3/middleware/cors"
 	midLogger "github.com/gofiber/fiber/v3/middleware/logger"
 	"github.com/gofiber/fiber/v3/middleware/static"
 	"github.com/google/uuid"
-	"github.com/kolesa-team/go-webp/encoder"
-	"github.com/kolesa-team/go-webp/webp"
-	"golang.org/x/net/html"
-	"golang.org/x/net/html/atom"
+	"github.com/jackc/pgx/v5"
 )
 
 var useOAuth = os.Getenv("USE_OAUTH") == "true"
 var password = os.Getenv("PASSWORD")
 var oauthAllowDomain = os.Getenv("OAUTH_ALLOW_DOMAIN")
-var webpImageEncoding = os.Getenv("WEBP_IMAGE_ENCODING") == "true"
+var imageConfig = imageproc.ConfigFromEnv()
+var imageStore imagestore.ImageStore
 var httpsDomain = os.Getenv("HTTPS_DOMAIN")
 var useHttps = os.Getenv("USE_HTTPS") == "true"
 var sse = utils.NewSSEServer()
 
-type SSEClient struct {
-	PingingSkip uint32
-	Mutex       sync.Mutex
-	Queue       []string
-}
-
-func StartWebServer() error {
-	app := fiber.New(fiber.Config{
-		BodyLimit: 20 * 1024 * 1024,
-	})
+// Posts, likes and users roles, set before the server starts
+var store utils.Store
 
-	app.Use(midLogger.New())
-	app.Use(cors.New())
+// Shares the events between the instances of the backend through the database
+var sseFanout = os.Getenv("SSE_FANOUT") == "postgres"
+var boardSlugRegexp = regexp.MustCompile("^[a-z0-9-]{1,64}$")
 
-	apiGroup := app.Group("/api")
+func StartWebServer() error {
 	var loginProvider providers.Provider
 	if useOAuth {
 		provider, err := providers.NewOAuthProvider(
 			oauthAllowDomain,
 			&cacheStorage,
+			store,
 		)
 		if err != nil {
 			return err
 		}
 
 		loginProvider = provider
+	} else {
+		loginProvider = providers.NewPasscodeProvider()
+	}
+
+	app := newApp(loginProvider)
+
+	if sseFanout {
+		if !usesPostgres() {
+			return errors.New("SSE_FANOUT=postgres needs a Postgres DB_ADDRESS")
+		}
+
+		sse.StartFanout()
+	}
+
+	go runUploadsCleaner()
+	go runTrashPurger()
+	go runImageCollector()
+
+	if useHttps {
+		go runHttpRedirector()
+
+		for {
+			time.Sleep(10 * time.Second)
+			logger.Println(app.Listen(":443", fiber.ListenConfig{
+				CertFile:    "/etc/letsencrypt/cert.crt",
+				CertKeyFile: "/etc/letsencrypt/privkey.key",
+			}))
+		}
+	} else {
+		return app.Listen(":80")
+	}
+}
 
+// Creates the app with all routes, the login routes depend on the type of the provider
+func newApp(loginProvider providers.Provider) *fiber.App {
+	app := fiber.New(fiber.Config{
+		BodyLimit: bodyLimit,
+		// The uploads are streamed to temporary files instead of being read into memory
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+	})
+
+	app.Use(midLogger.New())
+	app.Use(cors.New())
+	app.Use(limitBody)
+
+	apiGroup := app.Group("/api")
+	switch provider := loginProvider.(type) {
+	case providers.OAuthProvider:
 		apiGroup.Get("check", func(c fiber.Ctx) error {
 			if !loginProvider.CheckLogin(&c) {
 				return c.Status(fiber.StatusUnauthorized).SendString(loginProvider.GetProviderName())
@@ -73,14 +112,11 @@ func StartWebServer() error {
 
 			retInfo := map[string]any{}
 
-			retInfo["admin"] = utils.IsAdmin(c.Locals("email").(string))
+			retInfo["admin"] = store.IsAdmin(c.Context(), c.Locals("email").(string))
 
 			return c.Status(fiber.StatusOK).JSON(retInfo)
 		})
-	} else {
-		provider := providers.NewPasscodeProvider()
-		loginProvider = provider
-
+	case providers.PasscodeProvider:
 		apiGroup.Get("logout", func(c fiber.Ctx) error {
 			if loginProvider.CheckLogin(&c) {
 				c.Set("Set-Cookie", "Auth-Token=; expires=Thu, 01 Jan 1970 00:00:00 GMT;")
@@ -124,6 +160,9 @@ func StartWebServer() error {
 		return c.Status(fiber.StatusOK).SendString(loginProvider.GetProviderName())
 	})
 
+	// Checks the login itself, as the token can't be passed in a header
+	apiGroup.Get("ws", webSocketHandler(loginProvider))
+
 	apiGroup.Use(func(c fiber.Ctx) error {
 		if !loginProvider.CheckLogin(&c) {
 			return c.Status(fiber.StatusUnauthorized).SendString(loginProvider.GetProviderName())
@@ -133,155 +172,64 @@ func StartWebServer() error {
 	})
 
 	apiGroup.Post("sendPost", func(c fiber.Ctx) error {
-		allowedTags := []string{
-			"p", "img", "strong", "a", "em", "u", "pre", "span", "ul", "ol", "li",
-		}
-
 		var body map[string]string
 		if json.Unmarshal(c.Body(), &body) != nil {
 			return c.SendStatus(fiber.StatusBadRequest)
 		}
 
 		if content, ok := body["content"]; ok {
-			if len(content) > 1024*1024*1024*20 {
+			if len(content) > maxPostLength {
 				return c.SendStatus(fiber.StatusRequestEntityTooLarge)
 			}
 
-			attachedImages := []string{}
-			contentImages := [][]byte{}
-
-			for _, matched := range regexp.MustCompile("<\\s*([[:alpha:]]+)[ \\/>]").FindAllStringSubmatch(content, -1) {
-				tagName := matched[1]
-				if !slices.Contains(allowedTags, tagName) {
-					return c.SendStatus(fiber.StatusBadRequest)
-				}
-			}
-
-			ctx := &html.Node{
-				Type:     html.ElementNode,
-				DataAtom: atom.Div,
-				Data:     "div",
-			}
-			nodes, err := html.ParseFragment(strings.NewReader(content), ctx)
-			if err != nil {
-				return c.SendStatus(fiber.StatusBadRequest)
-			}
-
-			for _, node := range nodes {
-				ctx.AppendChild(node)
-			}
-
-			doc := goquery.NewDocumentFromNode(ctx)
-
-			if !strings.Contains(content, "<img") && len(strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(doc.Text(), " ", ""), "\n", ""), "\t", "")) < 5 {
+			lang := body["lang"]
+			if lang != "" && !utils.IsSearchLanguage(lang) {
 				return c.SendStatus(fiber.StatusBadRequest)
 			}
 
-			clearExtraAttributes(doc.Nodes)
-
-			doc.Find("img").Each(func(i int, s *goquery.Selection) {
-				srcVal, ex := s.Attr("src")
-				if !ex {
-					s.Remove()
-					return
+			boardId := body["boardId"]
+			if boardId != "" {
+				threads, ok := store.(utils.ThreadStore)
+				if !ok {
+					return c.SendStatus(fiber.StatusNotImplemented)
 				}
 
-				s.SetAttr("alt", "Post image "+fmt.Sprint(i))
-				ext := ""
-
-				if strings.HasPrefix(srcVal, "data:image/jpeg;base64,") {
-					ext = "jpg"
-				} else if strings.HasPrefix(srcVal, "data:image/png;base64,") {
-					ext = "png"
-				} else if strings.HasPrefix(srcVal, "data:image/webp;base64,") {
-					ext = "webp"
+				if _, err := uuid.Parse(boardId); err != nil {
+					return c.SendStatus(fiber.StatusBadRequest)
 				}
 
-				if ext != "" {
-					uuidVal, err := uuid.NewRandom()
-					for err != nil {
-						uuidVal, err = uuid.NewRandom()
-					}
-
-					var name string
-					if webpImageEncoding {
-						name = uuidVal.String() + ".webp"
-					} else {
-						name = uuidVal.String() + "." + ext
-					}
-
-					base64Data := srcVal[strings.IndexRune(srcVal, ',')+1:]
-					imgData, err := base64.StdEncoding.DecodeString(base64Data)
-					if err != nil {
-						s.Remove()
-						return
-					}
-
-					// Converting png or jpeg file into webp, if WEBP_IMAGE_ENCODING is turned on
-					if webpImageEncoding && ext != "webp" {
-						options, err := encoder.NewLossyEncoderOptions(encoder.PresetDefault, 25)
-						if err != nil {
-							s.Remove()
-							return
-						}
-
-						var img image.Image
-						reader := bytes.NewReader(imgData)
-						if ext == "jpg" {
-							img, err = jpeg.Decode(reader)
-						} else {
-							img, err = png.Decode(reader)
-						}
-
-						if err != nil {
-							s.Remove()
-							return
-						}
-
-						buff := bytes.NewBuffer([]byte{})
-						err = webp.Encode(buff, img, options)
-						if err != nil {
-							s.Remove()
-							return
-						}
-
-						imgData = buff.Bytes()
+				if _, err := threads.GetBoard(c.Context(), boardId); err != nil {
+					if errors.Is(err, pgx.ErrNoRows) {
+						return c.SendStatus(fiber.StatusBadRequest)
 					}
 
-					attachedImages = append(attachedImages, name)
-					contentImages = append(contentImages, imgData)
-					s.SetAttr("src", "/images/"+name)
+					return sendServerError(c, err)
 				}
-			})
+			}
 
-			content, err = doc.Html()
+			processed, err := processPostContent(content)
 			if err != nil {
+				if errors.Is(err, errInvalidContent) {
+					return c.SendStatus(fiber.StatusBadRequest)
+				}
+
 				logger.Println(err)
 				return c.SendStatus(fiber.StatusInternalServerError)
 			}
 
-			post, err := utils.AddPost(utils.Post{
+			post, err := store.AddPost(c.Context(), utils.Post{
 				UserID:          c.Locals("uid").(string),
 				UserEmail:       c.Locals("email").(string),
 				UserDisplayName: c.Locals("displayName").(string),
-				Content:         content,
-				AttachedImages:  attachedImages,
-			})
-			if err != nil {
-				logger.Println(err)
-				return c.SendStatus(fiber.StatusInternalServerError)
-			}
-
-			for i, imgPath := range attachedImages {
-				os.WriteFile("images/"+imgPath, contentImages[i], 0666)
-			}
-
-			jsonData, err := json.Marshal(post)
+				Content:         processed.Content,
+				BoardID:         boardId,
+				Language:        lang,
+			}, processed.ReferencedImages)
 			if err != nil {
-				return c.SendStatus(fiber.StatusCreated)
+				return sendServerError(c, err)
 			}
 
-			sse.SendBytes(append([]byte("newPost;"), jsonData...))
+			sse.SendBoard(post.BoardID, utils.NewPostEvent(post))
 
 			return c.Status(fiber.StatusOK).SendString(post.ID)
 		}
@@ -289,7 +237,7 @@ func StartWebServer() error {
 		return c.SendStatus(fiber.StatusBadRequest)
 	})
 
-	apiGroup.Post("deletePost", func(c fiber.Ctx) error {
+	apiGroup.Post("editPost", withThreads(func(c fiber.Ctx, threads utils.ThreadStore) error {
 		var body map[string]string
 		if json.Unmarshal(c.Body(), &body) != nil {
 			return c.SendStatus(fiber.StatusBadRequest)
@@ -300,37 +248,80 @@ func StartWebServer() error {
 			return c.SendStatus(fiber.StatusBadRequest)
 		}
 
-		userId, ok := c.Locals("uid").(string)
+		content, ok := body["content"]
 		if !ok {
-			return c.SendStatus(fiber.StatusInternalServerError)
+			return c.SendStatus(fiber.StatusBadRequest)
 		}
 
-		isAdmin := utils.IsAdmin(c.Locals("email").(string))
+		if len(content) > maxPostLength {
+			return c.SendStatus(fiber.StatusRequestEntityTooLarge)
+		}
 
-		var attachedImages []string
-		var err error
+		if _, err := uuid.Parse(postId); err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
 
-		if isAdmin {
-			attachedImages, err = utils.DeletePostAdmin(postId)
-		} else {
-			attachedImages, err = utils.DeletePost(postId, userId)
+		lang := body["lang"]
+		if lang != "" && !utils.IsSearchLanguage(lang) {
+			return c.SendStatus(fiber.StatusBadRequest)
 		}
 
+		processed, err := processPostContent(content)
 		if err != nil {
-			log.Println(err)
+			if errors.Is(err, errInvalidContent) {
+				return c.SendStatus(fiber.StatusBadRequest)
+			}
+
+			logger.Println(err)
 			return c.SendStatus(fiber.StatusInternalServerError)
 		}
 
-		for _, img := range attachedImages {
-			os.Remove("images/" + img)
+		post, err := threads.EditPost(
+			c.Context(),
+			postId,
+			utils.PostEdit{
+				EditorID:          c.Locals("uid").(string),
+				EditorDisplayName: c.Locals("displayName").(string),
+				Content:           processed.Content,
+				ReferencedImages:  processed.ReferencedImages,
+				Language:          lang,
+			},
+			store.IsAdmin(c.Context(), c.Locals("email").(string)),
+		)
+		if err != nil {
+			return sendPostError(c, err)
 		}
 
-		sse.SendBytes([]byte("delPost;" + postId))
+		sse.SendBoard(post.BoardID, utils.EditPostEvent(post))
+		sendModeration(c.Locals("uid").(string), post.UserID, utils.ModerationEvent(utils.EventEditPost, post.ID, "", post.UserID))
 
-		return c.SendStatus(fiber.StatusOK)
-	})
+		return c.Status(fiber.StatusOK).SendString(post.ID)
+	}))
 
-	apiGroup.Post("likePost", func(c fiber.Ctx) error {
+	apiGroup.Get("getPostRevisions/:postId", withThreads(func(c fiber.Ctx, threads utils.ThreadStore) error {
+		postId := c.Params("postId", "")
+		if _, err := uuid.Parse(postId); err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		authorId, err := store.GetPostAuthor(c.Context(), postId)
+		if err != nil {
+			return sendPostError(c, err)
+		}
+
+		if authorId != c.Locals("uid").(string) && !store.IsAdmin(c.Context(), c.Locals("email").(string)) {
+			return c.SendStatus(fiber.StatusForbidden)
+		}
+
+		revisions, err := threads.GetPostRevisions(c.Context(), postId)
+		if err != nil {
+			return sendServerError(c, err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(revisions)
+	}))
+
+	apiGroup.Post("restorePostRevision", withThreads(func(c fiber.Ctx, threads utils.ThreadStore) error {
 		var body map[string]string
 		if json.Unmarshal(c.Body(), &body) != nil {
 			return c.SendStatus(fiber.StatusBadRequest)
@@ -341,29 +332,80 @@ func StartWebServer() error {
 			return c.SendStatus(fiber.StatusBadRequest)
 		}
 
-		userId, ok := c.Locals("uid").(string)
+		revisionId, ok := body["revisionId"]
 		if !ok {
-			return c.SendStatus(fiber.StatusInternalServerError)
+			return c.SendStatus(fiber.StatusBadRequest)
 		}
 
-		postUuid, err := uuid.Parse(postId)
-		if err != nil {
-			log.Println(err)
+		if _, err := uuid.Parse(postId); err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+		if _, err := uuid.Parse(revisionId); err != nil {
 			return c.SendStatus(fiber.StatusBadRequest)
 		}
 
-		err = utils.AddLike(userId, postUuid.String())
+		post, err := threads.RestorePostRevision(
+			c.Context(),
+			postId,
+			revisionId,
+			c.Locals("uid").(string),
+			c.Locals("displayName").(string),
+			store.IsAdmin(c.Context(), c.Locals("email").(string)),
+		)
+		if err != nil {
+			return sendPostError(c, err)
+		}
+
+		sse.SendBoard(post.BoardID, utils.EditPostEvent(post))
+		sendModeration(c.Locals("uid").(string), post.UserID, utils.ModerationEvent(utils.EventEditPost, post.ID, "", post.UserID))
+
+		return c.Status(fiber.StatusOK).SendString(post.ID)
+	}))
+
+	apiGroup.Post("uploadImage", func(c fiber.Ctx) error {
+		file, err := receiveUpload(c.Request(), "image")
 		if err != nil {
-			log.Println(err)
+			if errors.Is(err, errNoUpload) {
+				return c.SendStatus(fiber.StatusBadRequest)
+			}
+			if errors.Is(err, errUploadTooLarge) {
+				c.Context().SetConnectionClose()
+				return c.SendStatus(fiber.StatusRequestEntityTooLarge)
+			}
+
+			logger.Println(err)
 			return c.SendStatus(fiber.StatusInternalServerError)
 		}
+		defer discardUpload(file)
 
-		sse.SendBytes([]byte("updateLikes;" + postId))
+		img, err := storeUploadedImage(file)
+		if err != nil {
+			if errors.Is(err, errUnsupportedImage) {
+				return c.SendStatus(fiber.StatusUnsupportedMediaType)
+			}
+			if errors.Is(err, imageproc.ErrImageTooLarge) {
+				return c.SendStatus(fiber.StatusRequestEntityTooLarge)
+			}
 
-		return c.SendStatus(fiber.StatusOK)
+			logger.Println(err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		names := []string{img.Name, img.ThumbnailName}
+		if err := store.AddUploads(c.Context(), names, c.Locals("uid").(string)); err != nil {
+			logger.Println(err)
+			removeImages(names)
+			return c.SendStatus(serverErrorStatus(c, err))
+		}
+
+		return c.Status(fiber.StatusOK).JSON(map[string]string{
+			"id":     img.Name,
+			"url":    "/images/" + img.Name,
+			"srcset": img.Srcset(),
+		})
 	})
 
-	apiGroup.Post("unlikePost", func(c fiber.Ctx) error {
+	apiGroup.Post("deletePost", func(c fiber.Ctx) error {
 		var body map[string]string
 		if json.Unmarshal(c.Body(), &body) != nil {
 			return c.SendStatus(fiber.StatusBadRequest)
@@ -374,133 +416,628 @@ func StartWebServer() error {
 			return c.SendStatus(fiber.StatusBadRequest)
 		}
 
+		if _, err := uuid.Parse(postId); err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
 		userId, ok := c.Locals("uid").(string)
 		if !ok {
 			return c.SendStatus(fiber.StatusInternalServerError)
 		}
 
-		postUuid, err := uuid.Parse(postId)
-		if err != nil {
-			log.Println(err)
+		reason := strings.TrimSpace(body["reason"])
+		if len(reason) > 512 {
 			return c.SendStatus(fiber.StatusBadRequest)
 		}
 
-		err = utils.RemoveLike(userId, postUuid.String())
+		isAdmin := store.IsAdmin(c.Context(), c.Locals("email").(string))
+
+		// The post goes to the trash, its images are deleted when it's purged
+		var authorId string
+		var err error
+
+		if isAdmin {
+			authorId, err = store.DeletePostAdmin(c.Context(), postId, userId, reason)
+		} else {
+			err = store.DeletePost(c.Context(), postId, userId, reason)
+		}
+
 		if err != nil {
-			log.Println(err)
-			return c.SendStatus(fiber.StatusInternalServerError)
+			return sendPostError(c, err)
 		}
 
-		sse.SendBytes([]byte("updateLikes;" + postId))
+		sse.Send(utils.DeletePostEvent(postId))
+		if isAdmin {
+			sendModeration(userId, authorId, utils.ModerationEvent(utils.EventDeletePost, postId, "", authorId))
+		}
 
 		return c.SendStatus(fiber.StatusOK)
 	})
 
-	apiGroup.Get("tenNewestPosts", func(c fiber.Ctx) error {
-		posts, err := utils.GetNewestPosts(10)
-		if err != nil {
-			log.Println(err)
-			return c.SendStatus(fiber.StatusInternalServerError)
+	// The authors restore the posts they deleted, the admins restore any post
+	apiGroup.Post("restorePost", func(c fiber.Ctx) error {
+		var body map[string]string
+		if json.Unmarshal(c.Body(), &body) != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
 		}
 
-		return c.Status(fiber.StatusOK).JSON(posts)
-	})
+		postId, ok := body["id"]
+		if !ok {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
 
-	apiGroup.Get("getNextTenPosts/:postId", func(c fiber.Ctx) error {
-		postId := c.Params("postId", "")
-		if postId == "" {
+		if _, err := uuid.Parse(postId); err != nil {
 			return c.SendStatus(fiber.StatusBadRequest)
 		}
 
-		posts, err := utils.GetNewestPostsFrom(postId, 10)
+		userId := c.Locals("uid").(string)
+
+		post, err := store.RestorePost(c.Context(), postId, userId, store.IsAdmin(c.Context(), c.Locals("email").(string)))
 		if err != nil {
-			log.Println(err)
-			return c.SendStatus(fiber.StatusInternalServerError)
+			return sendPostError(c, err)
 		}
 
-		return c.Status(fiber.StatusOK).JSON(posts)
+		sse.SendBoard(post.BoardID, utils.RestorePostEvent(post))
+		sendModeration(userId, post.UserID, utils.ModerationEvent(utils.EventRestorePost, post.ID, "", post.UserID))
+
+		return c.Status(fiber.StatusOK).JSON(post)
 	})
 
-	apiGroup.Get("getPostContent/:postId", func(c fiber.Ctx) error {
-		postId := c.Params("postId", "")
-		if postId == "" {
+	// Pages of the trash, like getPosts. The admins see the posts of all users,
+	// the others only their own posts
+	apiGroup.Get("getTrash", func(c fiber.Ctx) error {
+		query, ok := feedQuery(c)
+		if !ok {
 			return c.SendStatus(fiber.StatusBadRequest)
 		}
 
-		postContent, err := utils.GetPostContent(postId)
+		userId := c.Locals("uid").(string)
+		if store.IsAdmin(c.Context(), c.Locals("email").(string)) {
+			userId = ""
+		}
+
+		page, err := store.GetTrash(c.Context(), userId, query)
 		if err != nil {
-			log.Println(err)
-			return c.Status(fiber.StatusInternalServerError).SendString("")
+			if errors.Is(err, utils.ErrInvalidCursor) {
+				return c.SendStatus(fiber.StatusBadRequest)
+			}
+
+			return sendServerError(c, err)
 		}
 
-		return c.Status(fiber.StatusOK).SendString(postContent)
+		posts := make([]trashedPost, len(page.Posts))
+		for i, post := range page.Posts {
+			posts[i] = trashedPost{post, post.Content}
+		}
+
+		return c.Status(fiber.StatusOK).JSON(trashPage{posts, page.NextCursor, page.PrevCursor})
 	})
 
-	apiGroup.Get("getPostLikes/:postId", func(c fiber.Ctx) error {
-		postId := c.Params("postId", "")
-		if postId == "" {
+	apiGroup.Post("sendComment", withThreads(func(c fiber.Ctx, threads utils.ThreadStore) error {
+		var body map[string]string
+		if json.Unmarshal(c.Body(), &body) != nil {
 			return c.SendStatus(fiber.StatusBadRequest)
 		}
 
-		postLikes, err := utils.GetPostLikes(postId)
-		if err != nil {
-			log.Println(err)
-			return c.Status(fiber.StatusInternalServerError).SendString("{}")
+		postId, ok := body["postId"]
+		if !ok {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		if _, err := uuid.Parse(postId); err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		parentId := body["parentId"]
+		if parentId != "" {
+			if _, err := uuid.Parse(parentId); err != nil {
+				return c.SendStatus(fiber.StatusBadRequest)
+			}
+		}
+
+		if len(body["content"]) > maxCommentLength {
+			return c.SendStatus(fiber.StatusRequestEntityTooLarge)
 		}
 
-		liked, err := utils.CheckUserLikedPost(c.Locals("uid").(string), postId)
+		content, err := processCommentContent(body["content"])
 		if err != nil {
-			log.Println(err)
-			return c.Status(fiber.StatusInternalServerError).SendString("{}")
+			if errors.Is(err, errInvalidContent) {
+				return c.SendStatus(fiber.StatusBadRequest)
+			}
+
+			logger.Println(err)
+			return c.SendStatus(fiber.StatusInternalServerError)
 		}
 
-		return c.Status(fiber.StatusOK).JSON(map[string]any{
-			"liked": liked,
-			"likes": postLikes,
+		comment, err := threads.AddComment(c.Context(), utils.Comment{
+			PostID:          postId,
+			ParentID:        parentId,
+			UserID:          c.Locals("uid").(string),
+			UserEmail:       c.Locals("email").(string),
+			UserDisplayName: c.Locals("displayName").(string),
+			Content:         content,
 		})
-	})
+		if err != nil {
+			return sendPostError(c, err)
+		}
 
-	{
-		middlewaresSet := sse.FiberMiddlewaresSet()
-		apiGroup.Get("/events", middlewaresSet[0], middlewaresSet[1:]...)
-	}
+		sse.Send(utils.NewCommentEvent(comment))
+		sendCommentNotifications(c.Context(), threads, comment)
 
-	apiGroup.Use(func(c fiber.Ctx) error {
-		return c.SendStatus(fiber.StatusNotFound)
-	})
+		return c.Status(fiber.StatusOK).SendString(comment.ID)
+	}))
 
-	app.Get("/images/*", static.New(
-		"./images",
-		static.Config{
-			Compress:      true,
-			MaxAge:        int((5 * 24 * time.Hour).Seconds()),
-			CacheDuration: 3 * time.Minute,
-		},
-	))
-	app.Use(static.New(
-		"./frontend",
-		static.Config{
-			MaxAge: int((2 * 24 * time.Hour).Seconds()),
-		},
-	))
-	app.Use(func(c fiber.Ctx) error {
-		c.Context().SetContentType("text/html")
-		return c.Status(fiber.StatusOK).SendFile("./frontend/index.html")
-	})
+	apiGroup.Post("deleteComment", withThreads(func(c fiber.Ctx, threads utils.ThreadStore) error {
+		var body map[string]string
+		if json.Unmarshal(c.Body(), &body) != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
 
-	if useHttps {
-		go runHttpRedirector()
+		commentId, ok := body["id"]
+		if !ok {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
 
-		for {
-			time.Sleep(10 * time.Second)
-			logger.Println(app.Listen(":443", fiber.ListenConfig{
-				CertFile:    "/etc/letsencrypt/cert.crt",
-				CertKeyFile: "/etc/letsencrypt/privkey.key",
-			}))
+		if _, err := uuid.Parse(commentId); err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
 		}
-	} else {
-		return app.Listen(":80")
+
+		userId := c.Locals("uid").(string)
+		isAdmin := store.IsAdmin(c.Context(), c.Locals("email").(string))
+
+		var postId, authorId string
+		var err error
+		if isAdmin {
+			postId, authorId, err = threads.DeleteCommentAdmin(c.Context(), commentId)
+		} else {
+			_, err = threads.DeleteComment(c.Context(), commentId, userId)
+		}
+
+		if err != nil {
+			return sendPostError(c, err)
+		}
+
+		sse.Send(utils.DeleteCommentEvent(commentId))
+		if isAdmin {
+			sendModeration(userId, authorId, utils.ModerationEvent(utils.EventDeleteComment, postId, commentId, authorId))
+		}
+
+		return c.SendStatus(fiber.StatusOK)
+	}))
+
+	apiGroup.Get("getComments/:postId", withThreads(func(c fiber.Ctx, threads utils.ThreadStore) error {
+		postId := c.Params("postId", "")
+		parentId := c.Query("parent", "")
+		cursor := c.Query("cursor", "")
+
+		if _, err := uuid.Parse(postId); err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		if parentId != "" {
+			if _, err := uuid.Parse(parentId); err != nil {
+				return c.SendStatus(fiber.StatusBadRequest)
+			}
+		}
+
+		limit := fiber.Query[int](c, "limit", 10)
+		if limit < 1 || limit > 50 {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		page, err := threads.GetComments(c.Context(), postId, parentId, cursor, uint32(limit))
+		if err != nil {
+			if errors.Is(err, utils.ErrInvalidCursor) {
+				return c.SendStatus(fiber.StatusBadRequest)
+			}
+
+			return sendServerError(c, err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(page)
+	}))
+
+	apiGroup.Post("likePost", func(c fiber.Ctx) error {
+		var body map[string]string
+		if json.Unmarshal(c.Body(), &body) != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		postId, ok := body["id"]
+		if !ok {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		userId, ok := c.Locals("uid").(string)
+		if !ok {
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		postUuid, err := uuid.Parse(postId)
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		// The likes are the default reaction
+		counts, err := store.AddReaction(c.Context(), userId, postUuid.String(), utils.DefaultReaction)
+		if err != nil {
+			return sendPostError(c, err)
+		}
+
+		sendReactionEvents(postUuid.String(), utils.DefaultReaction, counts)
+
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	apiGroup.Post("unlikePost", func(c fiber.Ctx) error {
+		var body map[string]string
+		if json.Unmarshal(c.Body(), &body) != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		postId, ok := body["id"]
+		if !ok {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		userId, ok := c.Locals("uid").(string)
+		if !ok {
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		postUuid, err := uuid.Parse(postId)
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		// The likes are the default reaction
+		counts, err := store.RemoveReaction(c.Context(), userId, postUuid.String(), utils.DefaultReaction)
+		if err != nil {
+			return sendPostError(c, err)
+		}
+
+		sendReactionEvents(postUuid.String(), utils.DefaultReaction, counts)
+
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	apiGroup.Post("react", func(c fiber.Ctx) error {
+		return changeReaction(c, true)
+	})
+
+	apiGroup.Post("unreact", func(c fiber.Ctx) error {
+		return changeReaction(c, false)
+	})
+
+	apiGroup.Get("getPostReactions/:postId", func(c fiber.Ctx) error {
+		postId := c.Params("postId", "")
+		if _, err := uuid.Parse(postId); err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		reactions, err := store.GetPostReactions(c.Context(), postId, c.Locals("uid").(string))
+		if err != nil {
+			return sendServerError(c, err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(reactions)
+	})
+
+	apiGroup.Get("getReactionTypes", func(c fiber.Ctx) error {
+		reactionTypes, err := store.GetReactionTypes(c.Context())
+		if err != nil {
+			return sendServerError(c, err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(reactionTypes)
+	})
+
+	// Adds a reaction type or changes its position
+	apiGroup.Post("setReactionType", func(c fiber.Ctx) error {
+		if !store.IsAdmin(c.Context(), c.Locals("email").(string)) {
+			return c.SendStatus(fiber.StatusForbidden)
+		}
+
+		var reactionType utils.ReactionType
+		if json.Unmarshal(c.Body(), &reactionType) != nil || !validReaction(reactionType.Emoji) {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		if err := store.SetReactionType(c.Context(), reactionType); err != nil {
+			return sendPostError(c, err)
+		}
+
+		sse.Send(utils.UpdateReactionTypesEvent())
+
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	apiGroup.Post("deleteReactionType", func(c fiber.Ctx) error {
+		if !store.IsAdmin(c.Context(), c.Locals("email").(string)) {
+			return c.SendStatus(fiber.StatusForbidden)
+		}
+
+		var body map[string]string
+		if json.Unmarshal(c.Body(), &body) != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		emoji, ok := body["emoji"]
+		if !ok {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		if err := store.DeleteReactionType(c.Context(), emoji); err != nil {
+			return sendPostError(c, err)
+		}
+
+		sse.Send(utils.UpdateReactionTypesEvent())
+
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	// Pages of the feed, see feedQuery for the parameters
+	apiGroup.Get("getPosts", func(c fiber.Ctx) error {
+		query, ok := feedQuery(c)
+		if !ok {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		page, err := store.GetPosts(c.Context(), query)
+		return sendFeedPage(c, page, err)
+	})
+
+	apiGroup.Get("getBoardPosts/:board", withThreads(func(c fiber.Ctx, threads utils.ThreadStore) error {
+		board := c.Params("board", "")
+		if !boardSlugRegexp.MatchString(board) {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		query, ok := feedQuery(c)
+		if !ok {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		page, err := threads.GetBoardPosts(c.Context(), board, query)
+		return sendFeedPage(c, page, err)
+	}))
+
+	apiGroup.Get("getBoards", withThreads(func(c fiber.Ctx, threads utils.ThreadStore) error {
+		boards, err := threads.GetBoards(c.Context())
+		if err != nil {
+			return sendServerError(c, err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(boards)
+	}))
+
+	apiGroup.Post("addBoard", withThreads(func(c fiber.Ctx, threads utils.ThreadStore) error {
+		if !store.IsAdmin(c.Context(), c.Locals("email").(string)) {
+			return c.SendStatus(fiber.StatusForbidden)
+		}
+
+		var board utils.Board
+		if json.Unmarshal(c.Body(), &board) != nil || !validBoard(board) {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		board, err := threads.AddBoard(c.Context(), board)
+		if err != nil {
+			return sendPostError(c, err)
+		}
+
+		sse.Send(utils.UpdateBoardsEvent())
+
+		return c.Status(fiber.StatusOK).SendString(board.ID)
+	}))
+
+	apiGroup.Post("editBoard", withThreads(func(c fiber.Ctx, threads utils.ThreadStore) error {
+		if !store.IsAdmin(c.Context(), c.Locals("email").(string)) {
+			return c.SendStatus(fiber.StatusForbidden)
+		}
+
+		var board utils.Board
+		if json.Unmarshal(c.Body(), &board) != nil || !validBoard(board) {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		if _, err := uuid.Parse(board.ID); err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		if err := threads.UpdateBoard(c.Context(), board); err != nil {
+			return sendPostError(c, err)
+		}
+
+		sse.Send(utils.UpdateBoardsEvent())
+
+		return c.SendStatus(fiber.StatusOK)
+	}))
+
+	apiGroup.Post("deleteBoard", withThreads(func(c fiber.Ctx, threads utils.ThreadStore) error {
+		if !store.IsAdmin(c.Context(), c.Locals("email").(string)) {
+			return c.SendStatus(fiber.StatusForbidden)
+		}
+
+		var body map[string]string
+		if json.Unmarshal(c.Body(), &body) != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		boardId, ok := body["id"]
+		if !ok {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		if _, err := uuid.Parse(boardId); err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		if err := threads.DeleteBoard(c.Context(), boardId); err != nil {
+			return sendPostError(c, err)
+		}
+
+		sse.Send(utils.UpdateBoardsEvent())
+
+		return c.SendStatus(fiber.StatusOK)
+	}))
+
+	apiGroup.Get("search", func(c fiber.Ctx) error {
+		query := utils.SearchQuery{
+			Query:    strings.TrimSpace(c.Query("q", "")),
+			AuthorID: c.Query("author", ""),
+			Cursor:   c.Query("cursor", ""),
+		}
+
+		if query.Query == "" || len(query.Query) > 256 {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		limit := fiber.Query[int](c, "limit", 10)
+		if limit < 1 || limit > 50 {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+		query.Limit = uint32(limit)
+
+		// Time filters are unix timestamps in milliseconds, like the pubTime field of posts
+		if from := fiber.Query[int64](c, "from", 0); from > 0 {
+			query.From = time.UnixMilli(from).UTC()
+		}
+		if to := fiber.Query[int64](c, "to", 0); to > 0 {
+			query.To = time.UnixMilli(to).UTC()
+		}
+
+		results, nextCursor, err := store.SearchPosts(c.Context(), query)
+		if err != nil {
+			if errors.Is(err, utils.ErrInvalidCursor) {
+				return c.SendStatus(fiber.StatusBadRequest)
+			}
+
+			return sendServerError(c, err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(map[string]any{
+			"results":    results,
+			"nextCursor": nextCursor,
+		})
+	})
+
+	// Content and likes of several posts, the ids are separated by commas
+	apiGroup.Get("getPostsMeta", func(c fiber.Ctx) error {
+		postIds := strings.Split(c.Query("ids", ""), ",")
+		if len(postIds) > 100 {
+			return c.SendStatus(fiber.StatusRequestEntityTooLarge)
+		}
+
+		for _, postId := range postIds {
+			if _, err := uuid.Parse(postId); err != nil {
+				return c.SendStatus(fiber.StatusBadRequest)
+			}
+		}
+
+		metas, err := store.GetPostsMeta(c.Context(), postIds, c.Locals("uid").(string))
+		if err != nil {
+			return sendServerError(c, err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(metas)
+	})
+
+	apiGroup.Get("getPostContent/:postId", func(c fiber.Ctx) error {
+		postId := c.Params("postId", "")
+		if postId == "" {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		postContent, err := store.GetPostContent(c.Context(), postId)
+		if err != nil {
+			log.Println(err)
+			return c.Status(serverErrorStatus(c, err)).SendString("")
+		}
+
+		return c.Status(fiber.StatusOK).SendString(postContent)
+	})
+
+	apiGroup.Get("getPostLikes/:postId", func(c fiber.Ctx) error {
+		postId := c.Params("postId", "")
+		if _, err := uuid.Parse(postId); err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		postLikes, err := store.GetPostLikes(c.Context(), postId)
+		if err != nil {
+			log.Println(err)
+			return c.Status(serverErrorStatus(c, err)).SendString("{}")
+		}
+
+		liked, err := store.CheckUserLikedPost(c.Context(), c.Locals("uid").(string), postId)
+		if err != nil {
+			log.Println(err)
+			return c.Status(serverErrorStatus(c, err)).SendString("{}")
+		}
+
+		return c.Status(fiber.StatusOK).JSON(map[string]any{
+			"liked": liked,
+			"likes": postLikes,
+		})
+	})
+
+	// Users connected to the events of this instance. The presence is instance-local: with SSE_FANOUT
+	// the users connected to the other instances aren't included, and their joins and leaves aren't sent
+	apiGroup.Get("presence", func(c fiber.Ctx) error {
+		return c.Status(fiber.StatusOK).JSON(sse.Presence())
+	})
+
+	{
+		// The SSE server sends the private events of the admins to the clients marked as admins
+		markAdmin := func(c fiber.Ctx) error {
+			c.Locals("admin", store.IsAdmin(c.Context(), c.Locals("email").(string)))
+			return c.Next()
+		}
+
+		middlewaresSet := sse.FiberMiddlewaresSet()
+		apiGroup.Get("/events", markAdmin, middlewaresSet...)
+	}
+
+	apiGroup.Use(func(c fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNotFound)
+	})
+
+	// Images of the local store are served from its directory,
+	// other stores are asked for the URL the browser is redirected to
+	if localStore, ok := imageStore.(*imagestore.LocalStore); ok {
+		app.Get("/images/*", static.New(
+			localStore.Dir,
+			static.Config{
+				Compress:      true,
+				MaxAge:        int((5 * 24 * time.Hour).Seconds()),
+				CacheDuration: 3 * time.Minute,
+			},
+		))
+	} else {
+		app.Get("/images/:name", func(c fiber.Ctx) error {
+			url := imageStore.URL(c.Params("name"))
+			if url == "" {
+				return c.SendStatus(fiber.StatusNotFound)
+			}
+
+			return c.Redirect().Status(fiber.StatusFound).To(url)
+		})
 	}
+	app.Use(static.New(
+		"./frontend",
+		static.Config{
+			MaxAge: int((2 * 24 * time.Hour).Seconds()),
+		},
+	))
+	app.Use(func(c fiber.Ctx) error {
+		c.Context().SetContentType("text/html")
+		return c.Status(fiber.StatusOK).SendFile("./frontend/index.html")
+	})
+
+	return app
 }
 
 func runHttpRedirector() {
@@ -515,28 +1052,278 @@ func runHttpRedirector() {
 	logger.Fatalln(http.ListenAndServe(":80", nil))
 }
 
-func clearExtraAttributes(nodes []*html.Node) {
-	for _, node := range nodes {
-		if node.Type == html.ElementNode {
-			{
-				nodeName := node.DataAtom
-				newAttributes := []html.Attribute{}
-
-				for _, attr := range node.Attr {
-					if (nodeName == atom.Img && (attr.Key == "src" || attr.Key == "alt")) ||
-						(nodeName == atom.A && attr.Key == "href") {
-						newAttributes = append(newAttributes, attr)
-					}
-				}
+// The fanout of the events goes through the Postgres database
+func usesPostgres() bool {
+	_, ok := store.(utils.PgStore)
+	return ok
+}
 
-				node.Attr = newAttributes
-			}
+// Max size of the request bodies, except the uploads that limit their files themselves
+const bodyLimit = 1024 * 1024
 
-			children := []*html.Node{}
-			for child := node.FirstChild; child != nil; child = child.NextSibling {
-				children = append(children, child)
-			}
-			clearExtraAttributes(children)
+// Routes that read their request body as a stream
+var streamedBodyRoutes = []string{"/api/uploadImage"}
+
+// Answers 413 to the request bodies over bodyLimit. The server streams the bodies
+// over its limit instead of rejecting them, so they are read here up to the limit
+func limitBody(c fiber.Ctx) error {
+	req := c.Request()
+	if !req.IsBodyStream() || slices.Contains(streamedBodyRoutes, c.Path()) {
+		return c.Next()
+	}
+
+	if req.Header.ContentLength() > bodyLimit {
+		c.Context().SetConnectionClose()
+		return c.SendStatus(fiber.StatusRequestEntityTooLarge)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.BodyStream(), bodyLimit+1))
+	if err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	if len(body) > bodyLimit {
+		c.Context().SetConnectionClose()
+		return c.SendStatus(fiber.StatusRequestEntityTooLarge)
+	}
+
+	req.SetBody(body)
+	return c.Next()
+}
+
+// Passes the comments, boards and revisions of the store to the handler, or answers 501 if the
+// database doesn't have them. It wraps the handler, as the middleware passed to the routes of fiber
+// run after their handler
+func withThreads(handler func(c fiber.Ctx, threads utils.ThreadStore) error) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		threads, ok := store.(utils.ThreadStore)
+		if !ok {
+			return c.SendStatus(fiber.StatusNotImplemented)
+		}
+
+		return handler(c, threads)
+	}
+}
+
+func validBoard(board utils.Board) bool {
+	name := strings.TrimSpace(board.Name)
+	return len(name) > 0 && len(name) <= 64 && len(board.Description) <= 1024 && boardSlugRegexp.MatchString(board.Slug)
+}
+
+// Reads the parameters of the feeds: cursor is nextCursor or prevCursor of a page,
+// direction is older (default) or newer and limit is the page size, 10 by default
+func feedQuery(c fiber.Ctx) (utils.FeedQuery, bool) {
+	limit := fiber.Query[int](c, "limit", 10)
+	if limit < 1 || limit > 50 {
+		return utils.FeedQuery{}, false
+	}
+
+	direction := c.Query("direction", "older")
+	if direction != "older" && direction != "newer" {
+		return utils.FeedQuery{}, false
+	}
+
+	return utils.FeedQuery{
+		Cursor: c.Query("cursor", ""),
+		Newer:  direction == "newer",
+		Limit:  uint32(limit),
+	}, true
+}
+
+// Post in the trash, with the content so the user can tell what they restore
+type trashedPost struct {
+	utils.Post
+	Content string `json:"content"`
+}
+
+// Page of the trash, the cursors are the same as the ones of the feed
+type trashPage struct {
+	Posts      []trashedPost `json:"posts"`
+	NextCursor string        `json:"nextCursor"`
+	PrevCursor string        `json:"prevCursor"`
+}
+
+// Sends a page of the feed. With the withMeta query the content and the likes
+// of the posts are added, so the client doesn't have to request them one by one
+func sendFeedPage(c fiber.Ctx, page utils.FeedPage, err error) error {
+	if err != nil {
+		if errors.Is(err, utils.ErrInvalidCursor) {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		return sendServerError(c, err)
+	}
+
+	if !fiber.Query[bool](c, "withMeta", false) {
+		return c.Status(fiber.StatusOK).JSON(page)
+	}
+
+	postIds := make([]string, len(page.Posts))
+	for i, post := range page.Posts {
+		postIds[i] = post.ID
+	}
+
+	metas, err := store.GetPostsMeta(c.Context(), postIds, c.Locals("uid").(string))
+	if err != nil {
+		return sendServerError(c, err)
+	}
+
+	// The posts deleted in between are left out
+	postsWithMeta := make([]utils.PostWithMeta, 0, len(page.Posts))
+	for _, post := range page.Posts {
+		if meta, ok := metas[post.ID]; ok {
+			postsWithMeta = append(postsWithMeta, utils.PostWithMeta{Post: post, PostMeta: meta})
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(map[string]any{
+		"posts":      postsWithMeta,
+		"nextCursor": page.NextCursor,
+		"prevCursor": page.PrevCursor,
+	})
+}
+
+// A reaction is a short emoji sequence, e.g. a flag or a family
+func validReaction(emoji string) bool {
+	if len(emoji) == 0 || len(emoji) > 32 || !utf8.ValidString(emoji) || utf8.RuneCountInString(emoji) > 8 {
+		return false
+	}
+
+	hasNonASCII := false
+	for _, r := range emoji {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+		if r > unicode.MaxASCII {
+			hasNonASCII = true
+		}
+	}
+
+	return hasNonASCII
+}
+
+// Handles react and unreact, the body has the postId and the reaction
+func changeReaction(c fiber.Ctx, add bool) error {
+	var body map[string]string
+	if json.Unmarshal(c.Body(), &body) != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	postId := body["postId"]
+	if _, err := uuid.Parse(postId); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	reaction := body["reaction"]
+	if !validReaction(reaction) {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	var counts utils.ReactionCounts
+	var err error
+	if add {
+		counts, err = store.AddReaction(c.Context(), c.Locals("uid").(string), postId, reaction)
+	} else {
+		counts, err = store.RemoveReaction(c.Context(), c.Locals("uid").(string), postId, reaction)
+	}
+	if err != nil {
+		return sendPostError(c, err)
+	}
+
+	sendReactionEvents(postId, reaction, counts)
+
+	return c.Status(fiber.StatusOK).JSON(counts)
+}
+
+// The clients that know only the likes get updateLikes when the default reaction changes
+func sendReactionEvents(postId string, reaction string, counts utils.ReactionCounts) {
+	sse.Send(utils.UpdateReactionsEvent(postId, counts))
+	if reaction == utils.DefaultReaction {
+		sse.Send(utils.UpdateLikesEvent(postId, counts[utils.DefaultReaction]))
+	}
+}
+
+// Sends a status code that corresponds to an error returned by the post related utils functions
+func sendPostError(c fiber.Ctx, err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	if errors.Is(err, utils.ErrForbidden) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+
+	if errors.Is(err, utils.ErrUnknownReaction) {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	if errors.Is(err, utils.ErrBoardSlugTaken) {
+		return c.SendStatus(fiber.StatusConflict)
+	}
+
+	return sendServerError(c, err)
+}
+
+// Status of a failed storage call: 503 with Retry-After when the database is overloaded
+// or too slow, 500 otherwise
+func serverErrorStatus(c fiber.Ctx, err error) int {
+	if utils.IsUnavailable(err) {
+		c.Set(fiber.HeaderRetryAfter, "1")
+		return fiber.StatusServiceUnavailable
+	}
+
+	return fiber.StatusInternalServerError
+}
+
+// Logs the error of a storage call and sends its status
+func sendServerError(c fiber.Ctx, err error) error {
+	log.Println(err)
+	return c.SendStatus(serverErrorStatus(c, err))
+}
+
+// Tells the author and the admins that an admin changed the content of the author.
+// Nothing is sent if the moderator is the author
+func sendModeration(moderatorId string, authorId string, event utils.Event) {
+	if authorId == moderatorId {
+		return
+	}
+
+	if err := sse.SendToUser(authorId, event); err != nil {
+		logger.Println(err)
+	}
+	if err := sse.SendToAdmins(event); err != nil {
+		logger.Println(err)
+	}
+}
+
+// Notifies the author of the parent comment about a reply and the author of the post about
+// a comment. The users aren't notified about their own comments or twice about the same comment
+func sendCommentNotifications(ctx context.Context, threads utils.ThreadStore, comment utils.Comment) {
+	notified := []string{comment.UserID}
+
+	notify := func(kind string, userId string) {
+		if slices.Contains(notified, userId) {
+			return
+		}
+		notified = append(notified, userId)
+
+		if err := sse.SendToUser(userId, utils.NotificationEvent(kind, comment)); err != nil {
+			logger.Println(err)
+		}
+	}
+
+	if comment.ParentID != "" {
+		parentAuthorId, err := threads.GetCommentAuthor(ctx, comment.ParentID)
+		if err != nil {
+			logger.Println(err)
+		} else {
+			notify(utils.NotificationReply, parentAuthorId)
 		}
 	}
+
+	postAuthorId, err := store.GetPostAuthor(ctx, comment.PostID)
+	if err != nil {
+		logger.Println(err)
+		return
+	}
+	notify(utils.NotificationComment, postAuthorId)
 }
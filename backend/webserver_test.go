@@ -0,0 +1,792 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"threadhelpServer/imagestore"
+	"threadhelpServer/providers"
+	"threadhelpServer/utils"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Logs in the users of testUsers by their id passed as the token
+type testLoginProvider struct{}
+
+var testUsers = map[string]string{
+	"user":  "User",
+	"other": "Other",
+	"admin": "Admin",
+}
+
+func (testLoginProvider) GetProviderName() string {
+	return "test"
+}
+
+func (testLoginProvider) CheckLogin(c *fiber.Ctx) bool {
+	// The header is only valid during the request, the memory store keeps the id
+	uid := strings.Clone((*c).Get("Auth-Token"))
+	displayName, ok := testUsers[uid]
+	if !ok {
+		return false
+	}
+
+	(*c).Locals("uid", uid)
+	(*c).Locals("email", uid+"@example.com")
+	(*c).Locals("displayName", displayName)
+	return true
+}
+
+// Replaces the store of the server with an empty one, where admin is the admin
+func newTestStore() *utils.MemoryStore {
+	memoryStore := utils.NewMemoryStore()
+	memoryStore.AddAdmin("admin@example.com")
+	store = memoryStore
+
+	return memoryStore
+}
+
+type testClient struct {
+	t   *testing.T
+	app *fiber.App
+}
+
+func newTestClient(t *testing.T) testClient {
+	newTestStore()
+	return testClient{t: t, app: newApp(testLoginProvider{})}
+}
+
+// Sends the request as the user and returns the status and the body of the response.
+// The body of the request is encoded as JSON unless it's nil
+func (c testClient) request(method string, path string, user string, body any) (int, string) {
+	c.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		reader = strings.NewReader(string(data))
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if user != "" {
+		req.Header.Set("Auth-Token", user)
+	}
+
+	resp, err := c.app.Test(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return resp.StatusCode, string(data)
+}
+
+// Sends the request and fails the test if the status isn't the expected one
+func (c testClient) expect(status int, method string, path string, user string, body any) string {
+	c.t.Helper()
+
+	code, response := c.request(method, path, user, body)
+	if code != status {
+		c.t.Fatalf("%s %s as %q returned %d %q, expected %d", method, path, user, code, response, status)
+	}
+
+	return response
+}
+
+func (c testClient) sendPost(user string, content string) string {
+	c.t.Helper()
+	return c.expect(fiber.StatusOK, "POST", "/api/sendPost", user, map[string]string{"content": content})
+}
+
+func TestPostsEndpoints(t *testing.T) {
+	c := newTestClient(t)
+
+	c.expect(fiber.StatusUnauthorized, "GET", "/api/getPosts", "", nil)
+	c.expect(fiber.StatusUnauthorized, "GET", "/api/getPosts", "nobody", nil)
+	c.expect(fiber.StatusBadRequest, "POST", "/api/sendPost", "user", map[string]string{"content": "<p>hi</p>"})
+	c.expect(fiber.StatusBadRequest, "POST", "/api/sendPost", "user", map[string]string{"content": strings.Repeat("<em>", 100) + "too deep"})
+	c.expect(fiber.StatusRequestEntityTooLarge, "POST", "/api/sendPost", "user", map[string]string{"content": strings.Repeat("a", maxPostLength+1)})
+	c.expect(fiber.StatusRequestEntityTooLarge, "POST", "/api/sendPost", "user", map[string]string{"content": strings.Repeat("a", bodyLimit)})
+
+	first := c.sendPost("user", "<p>The first post</p>")
+	second := c.sendPost("other", "<p>The second post</p><script>alert(1)</script>")
+
+	var page utils.FeedPage
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getPosts", "user", nil)), &page)
+	if len(page.Posts) != 2 || page.Posts[0].ID != second || page.Posts[1].ID != first || page.Posts[0].UserDisplayName != "Other" {
+		t.Errorf("unexpected posts %+v", page.Posts)
+	}
+	if page.NextCursor != "" {
+		t.Errorf("the only page has the next cursor %q", page.NextCursor)
+	}
+
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getPosts?limit=1", "user", nil)), &page)
+	if len(page.Posts) != 1 || page.Posts[0].ID != second || page.NextCursor == "" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getPosts?limit=1&cursor="+page.NextCursor, "user", nil)), &page)
+	if len(page.Posts) != 1 || page.Posts[0].ID != first {
+		t.Errorf("unexpected next page %+v", page)
+	}
+
+	c.expect(fiber.StatusBadRequest, "GET", "/api/getPosts?cursor=invalid", "user", nil)
+	c.expect(fiber.StatusBadRequest, "GET", "/api/getPosts?limit=0", "user", nil)
+	c.expect(fiber.StatusBadRequest, "GET", "/api/getPosts?direction=newer", "user", nil)
+
+	if content := c.expect(fiber.StatusOK, "GET", "/api/getPostContent/"+second, "user", nil); content != "<p>The second post</p>" {
+		t.Errorf("the content is %q, expected it without the script", content)
+	}
+
+	var pageWithMeta struct {
+		Posts []utils.PostWithMeta `json:"posts"`
+	}
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getPosts?withMeta=true", "user", nil)), &pageWithMeta)
+	if len(pageWithMeta.Posts) != 2 || pageWithMeta.Posts[1].PostMeta.Content != "<p>The first post</p>" {
+		t.Errorf("unexpected posts with meta %+v", pageWithMeta.Posts)
+	}
+
+	// Only the author and the admins delete the posts
+	c.expect(fiber.StatusNotFound, "POST", "/api/deletePost", "other", map[string]string{"id": first})
+	c.expect(fiber.StatusOK, "POST", "/api/deletePost", "user", map[string]string{"id": first})
+	c.expect(fiber.StatusOK, "POST", "/api/deletePost", "admin", map[string]string{"id": second})
+
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getPosts", "user", nil)), &page)
+	if len(page.Posts) != 0 {
+		t.Errorf("posts are %+v after the deletion, expected none", page.Posts)
+	}
+}
+
+// Sends the file in the image field of a multipart form and returns the status and the body of the response
+func (c testClient) upload(user string, filename string, data []byte) (int, string) {
+	c.t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if filename != "" {
+		part, err := form.CreateFormFile("image", filename)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		part.Write(data)
+	}
+	form.Close()
+
+	req := httptest.NewRequest("POST", "/api/uploadImage", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Auth-Token", user)
+
+	resp, err := c.app.Test(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return resp.StatusCode, string(response)
+}
+
+func testPNG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestUploadImage(t *testing.T) {
+	c := newTestClient(t)
+
+	localStore, err := imagestore.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageStore = localStore
+
+	status, response := c.upload("user", "image.png", testPNG(t))
+	if status != fiber.StatusOK {
+		t.Fatalf("the upload returned %d %q", status, response)
+	}
+
+	var uploaded map[string]string
+	json.Unmarshal([]byte(response), &uploaded)
+	post := c.sendPost("user", `<p>With an uploaded image</p><img src="`+uploaded["url"]+`">`)
+	if content := c.expect(fiber.StatusOK, "GET", "/api/getPostContent/"+post, "user", nil); !strings.Contains(content, uploaded["url"]) {
+		t.Errorf("the content %q doesn't have the uploaded image", content)
+	}
+
+	// The images are uploaded separately, the inline ones are dropped
+	post = c.sendPost("user", `<p>With an inline image</p><img src="data:image/png;base64,`+base64.StdEncoding.EncodeToString(testPNG(t))+`">`)
+	if content := c.expect(fiber.StatusOK, "GET", "/api/getPostContent/"+post, "user", nil); content != "<p>With an inline image</p>" {
+		t.Errorf("the content is %q, expected it without the inline image", content)
+	}
+
+	cases := []struct {
+		name     string
+		filename string
+		data     []byte
+		status   int
+	}{
+		{"no file", "", nil, fiber.StatusBadRequest},
+		{"not an image", "notes.txt", []byte("just some text"), fiber.StatusUnsupportedMediaType},
+		{"over the size limit", "large.png", append(testPNG(t), make([]byte, uploadMaxSize)...), fiber.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range cases {
+		if status, response := c.upload("user", test.filename, test.data); status != test.status {
+			t.Errorf("%s: the upload returned %d %q, expected %d", test.name, status, response, test.status)
+		}
+	}
+}
+
+func TestTrashEndpoints(t *testing.T) {
+	c := newTestClient(t)
+
+	mistake := c.sendPost("user", "<p>A post written by mistake</p>")
+	spam := c.sendPost("user", "<p>Buy the cheapest watches</p>")
+
+	c.expect(fiber.StatusBadRequest, "POST", "/api/deletePost", "user", map[string]string{"id": "not-a-uuid"})
+	c.expect(fiber.StatusBadRequest, "POST", "/api/deletePost", "user", map[string]string{"id": mistake, "reason": strings.Repeat("a", 513)})
+	c.expect(fiber.StatusOK, "POST", "/api/deletePost", "user", map[string]string{"id": mistake, "reason": "typo"})
+	c.expect(fiber.StatusOK, "POST", "/api/deletePost", "admin", map[string]string{"id": spam, "reason": "spam"})
+
+	var trash struct {
+		Posts []struct {
+			ID       string `json:"postId"`
+			Content  string `json:"content"`
+			Deletion struct {
+				UserID string `json:"userId"`
+				Reason string `json:"reason"`
+			} `json:"deletion"`
+		} `json:"posts"`
+	}
+
+	// The authors see their own trashed posts, the admins see all of them
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getTrash", "user", nil)), &trash)
+	if len(trash.Posts) != 2 || trash.Posts[0].ID != spam || trash.Posts[0].Content != "<p>Buy the cheapest watches</p>" ||
+		trash.Posts[0].Deletion.UserID != "admin" || trash.Posts[1].Deletion.Reason != "typo" {
+		t.Errorf("unexpected trash %+v", trash.Posts)
+	}
+
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getTrash", "other", nil)), &trash)
+	if len(trash.Posts) != 0 {
+		t.Errorf("another user sees the trash %+v", trash.Posts)
+	}
+
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getTrash", "admin", nil)), &trash)
+	if len(trash.Posts) != 2 {
+		t.Errorf("the admin sees the trash %+v, expected both posts", trash.Posts)
+	}
+
+	c.expect(fiber.StatusBadRequest, "GET", "/api/getTrash?cursor=invalid", "user", nil)
+
+	// The authors restore only the posts they deleted themselves
+	c.expect(fiber.StatusBadRequest, "POST", "/api/restorePost", "user", map[string]string{"id": "invalid"})
+	c.expect(fiber.StatusForbidden, "POST", "/api/restorePost", "user", map[string]string{"id": spam})
+	c.expect(fiber.StatusForbidden, "POST", "/api/restorePost", "other", map[string]string{"id": mistake})
+
+	var restored utils.Post
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "POST", "/api/restorePost", "user", map[string]string{"id": mistake})), &restored)
+	if restored.ID != mistake || restored.UserID != "user" {
+		t.Errorf("unexpected restored post %+v", restored)
+	}
+
+	c.expect(fiber.StatusNotFound, "POST", "/api/restorePost", "user", map[string]string{"id": mistake})
+	c.expect(fiber.StatusOK, "POST", "/api/restorePost", "admin", map[string]string{"id": spam})
+
+	var page utils.FeedPage
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getPosts", "user", nil)), &page)
+	if len(page.Posts) != 2 {
+		t.Errorf("posts are %+v after the restore, expected both", page.Posts)
+	}
+}
+
+func TestLikesEndpoints(t *testing.T) {
+	c := newTestClient(t)
+
+	postId := c.sendPost("user", "<p>Like me</p>")
+
+	c.expect(fiber.StatusBadRequest, "POST", "/api/likePost", "user", map[string]string{"id": "invalid"})
+	c.expect(fiber.StatusNotFound, "POST", "/api/likePost", "user", map[string]string{"id": "00000000-0000-0000-0000-000000000000"})
+	c.expect(fiber.StatusOK, "POST", "/api/likePost", "user", map[string]string{"id": postId})
+	c.expect(fiber.StatusOK, "POST", "/api/likePost", "user", map[string]string{"id": postId})
+	c.expect(fiber.StatusOK, "POST", "/api/likePost", "other", map[string]string{"id": postId})
+
+	if likes := c.expect(fiber.StatusOK, "GET", "/api/getPostLikes/"+postId, "user", nil); likes != `{"liked":true,"likes":2}` {
+		t.Errorf("likes are %s, expected 2 liked", likes)
+	}
+
+	c.expect(fiber.StatusOK, "POST", "/api/unlikePost", "user", map[string]string{"id": postId})
+	if likes := c.expect(fiber.StatusOK, "GET", "/api/getPostLikes/"+postId, "user", nil); likes != `{"liked":false,"likes":1}` {
+		t.Errorf("likes are %s, expected 1 not liked", likes)
+	}
+
+	// The reaction types are managed by the admins
+	c.expect(fiber.StatusBadRequest, "POST", "/api/react", "user", map[string]string{"postId": postId, "reaction": "🎉"})
+	c.expect(fiber.StatusForbidden, "POST", "/api/setReactionType", "user", map[string]any{"emoji": "🎉", "position": 1})
+	c.expect(fiber.StatusBadRequest, "POST", "/api/setReactionType", "admin", map[string]any{"emoji": "abc", "position": 1})
+	c.expect(fiber.StatusOK, "POST", "/api/setReactionType", "admin", map[string]any{"emoji": "🎉", "position": 1})
+
+	if types := c.expect(fiber.StatusOK, "GET", "/api/getReactionTypes", "user", nil); types != `[{"emoji":"👍","position":0},{"emoji":"🎉","position":1}]` {
+		t.Errorf("unexpected reaction types %s", types)
+	}
+
+	if counts := c.expect(fiber.StatusOK, "POST", "/api/react", "user", map[string]string{"postId": postId, "reaction": "🎉"}); counts != `{"🎉":1,"👍":1}` {
+		t.Errorf("unexpected counts %s", counts)
+	}
+
+	if reactions := c.expect(fiber.StatusOK, "GET", "/api/getPostReactions/"+postId, "user", nil); reactions != `{"counts":{"🎉":1,"👍":1},"mine":["🎉"]}` {
+		t.Errorf("unexpected reactions %s", reactions)
+	}
+
+	c.expect(fiber.StatusForbidden, "POST", "/api/deleteReactionType", "admin", map[string]string{"emoji": "👍"})
+	c.expect(fiber.StatusOK, "POST", "/api/deleteReactionType", "admin", map[string]string{"emoji": "🎉"})
+	c.expect(fiber.StatusNotFound, "POST", "/api/deleteReactionType", "admin", map[string]string{"emoji": "🎉"})
+
+	metas := c.expect(fiber.StatusOK, "GET", "/api/getPostsMeta?ids="+postId, "other", nil)
+	if metas != `{"`+postId+`":{"content":"\u003cp\u003eLike me\u003c/p\u003e","likes":1,"liked":true}}` {
+		t.Errorf("unexpected metas %s", metas)
+	}
+	c.expect(fiber.StatusBadRequest, "GET", "/api/getPostsMeta?ids="+postId+",invalid", "other", nil)
+}
+
+func TestRevisionsEndpoints(t *testing.T) {
+	c := newTestClient(t)
+
+	postId := c.sendPost("user", "<p>The first version</p>")
+	unknown := "00000000-0000-0000-0000-000000000000"
+
+	c.expect(fiber.StatusBadRequest, "POST", "/api/editPost", "user", map[string]string{"id": "invalid", "content": "<p>The second version</p>"})
+	c.expect(fiber.StatusBadRequest, "POST", "/api/editPost", "user", map[string]string{"id": postId})
+	c.expect(fiber.StatusBadRequest, "POST", "/api/editPost", "user", map[string]string{"id": postId, "content": "<p>hi</p>"})
+	c.expect(fiber.StatusBadRequest, "POST", "/api/editPost", "user", map[string]string{"id": postId, "content": "<p>The second version</p>", "lang": "xx"})
+	c.expect(fiber.StatusNotFound, "POST", "/api/editPost", "user", map[string]string{"id": unknown, "content": "<p>The second version</p>"})
+
+	// Only the author and the admins edit the posts
+	c.expect(fiber.StatusForbidden, "POST", "/api/editPost", "other", map[string]string{"id": postId, "content": "<p>Not my post</p>"})
+	if id := c.expect(fiber.StatusOK, "POST", "/api/editPost", "user", map[string]string{"id": postId, "content": "<p>The second version</p>"}); id != postId {
+		t.Errorf("the edit returned %q, expected the post id", id)
+	}
+	c.expect(fiber.StatusOK, "POST", "/api/editPost", "admin", map[string]string{"id": postId, "content": "<p>The third version</p>"})
+
+	if content := c.expect(fiber.StatusOK, "GET", "/api/getPostContent/"+postId, "user", nil); content != "<p>The third version</p>" {
+		t.Errorf("the content is %q after the edits", content)
+	}
+
+	var page utils.FeedPage
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getPosts", "user", nil)), &page)
+	if len(page.Posts) != 1 || page.Posts[0].EditDate == nil {
+		t.Errorf("unexpected posts %+v, expected the edited post", page.Posts)
+	}
+
+	// The authors and the admins see the replaced versions, the newest first
+	c.expect(fiber.StatusBadRequest, "GET", "/api/getPostRevisions/invalid", "user", nil)
+	c.expect(fiber.StatusNotFound, "GET", "/api/getPostRevisions/"+unknown, "user", nil)
+	c.expect(fiber.StatusForbidden, "GET", "/api/getPostRevisions/"+postId, "other", nil)
+	c.expect(fiber.StatusOK, "GET", "/api/getPostRevisions/"+postId, "admin", nil)
+
+	var revisions []utils.PostRevision
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getPostRevisions/"+postId, "user", nil)), &revisions)
+	if len(revisions) != 2 || revisions[0].Content != "<p>The second version</p>" || revisions[0].EditorID != "admin" ||
+		revisions[1].Content != "<p>The first version</p>" || revisions[1].EditorDisplayName != "User" {
+		t.Fatalf("unexpected revisions %+v", revisions)
+	}
+
+	first := revisions[1].ID
+	c.expect(fiber.StatusBadRequest, "POST", "/api/restorePostRevision", "user", map[string]string{"id": postId, "revisionId": "invalid"})
+	c.expect(fiber.StatusNotFound, "POST", "/api/restorePostRevision", "user", map[string]string{"id": postId, "revisionId": unknown})
+	c.expect(fiber.StatusNotFound, "POST", "/api/restorePostRevision", "user", map[string]string{"id": unknown, "revisionId": first})
+	c.expect(fiber.StatusForbidden, "POST", "/api/restorePostRevision", "other", map[string]string{"id": postId, "revisionId": first})
+	if id := c.expect(fiber.StatusOK, "POST", "/api/restorePostRevision", "user", map[string]string{"id": postId, "revisionId": first}); id != postId {
+		t.Errorf("the restore returned %q, expected the post id", id)
+	}
+
+	if content := c.expect(fiber.StatusOK, "GET", "/api/getPostContent/"+postId, "user", nil); content != "<p>The first version</p>" {
+		t.Errorf("the content is %q after the restore", content)
+	}
+
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getPostRevisions/"+postId, "user", nil)), &revisions)
+	if len(revisions) != 3 || revisions[0].Content != "<p>The third version</p>" {
+		t.Errorf("unexpected revisions %+v after the restore", revisions)
+	}
+}
+
+func TestCommentsEndpoints(t *testing.T) {
+	c := newTestClient(t)
+
+	postId := c.sendPost("user", "<p>Comment on me</p>")
+	unknown := "00000000-0000-0000-0000-000000000000"
+
+	comment := func(user string, parentId string, content string) string {
+		t.Helper()
+		return c.expect(fiber.StatusOK, "POST", "/api/sendComment", user, map[string]string{"postId": postId, "parentId": parentId, "content": content})
+	}
+
+	c.expect(fiber.StatusBadRequest, "POST", "/api/sendComment", "other", map[string]string{"postId": "invalid", "content": "First"})
+	c.expect(fiber.StatusBadRequest, "POST", "/api/sendComment", "other", map[string]string{"postId": postId, "parentId": "invalid", "content": "First"})
+	c.expect(fiber.StatusBadRequest, "POST", "/api/sendComment", "other", map[string]string{"postId": postId, "content": ""})
+	c.expect(fiber.StatusRequestEntityTooLarge, "POST", "/api/sendComment", "other", map[string]string{"postId": postId, "content": strings.Repeat("a", maxCommentLength+1)})
+	c.expect(fiber.StatusNotFound, "POST", "/api/sendComment", "other", map[string]string{"postId": unknown, "content": "First"})
+	c.expect(fiber.StatusNotFound, "POST", "/api/sendComment", "other", map[string]string{"postId": postId, "parentId": unknown, "content": "First"})
+
+	first := comment("other", "", "First")
+	second := comment("admin", "", "Second")
+	reply := comment("user", first, "A reply")
+	comment("other", reply, "A reply to the reply")
+
+	var page utils.CommentPage
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getComments/"+postId, "user", nil)), &page)
+	if len(page.Comments) != 2 || page.Comments[0].ID != first || page.Comments[0].Replies != 1 ||
+		page.Comments[0].UserDisplayName != "Other" || page.Comments[1].ID != second || page.NextCursor != "" {
+		t.Errorf("unexpected comments %+v", page)
+	}
+
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getComments/"+postId+"?limit=1", "user", nil)), &page)
+	if len(page.Comments) != 1 || page.Comments[0].ID != first || page.NextCursor == "" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getComments/"+postId+"?limit=1&cursor="+page.NextCursor, "user", nil)), &page)
+	if len(page.Comments) != 1 || page.Comments[0].ID != second || page.NextCursor != "" {
+		t.Errorf("unexpected next page %+v", page)
+	}
+
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getComments/"+postId+"?parent="+first, "user", nil)), &page)
+	if len(page.Comments) != 1 || page.Comments[0].ID != reply || page.Comments[0].ParentID != first || page.Comments[0].Content != "A reply" {
+		t.Errorf("unexpected replies %+v", page)
+	}
+
+	c.expect(fiber.StatusBadRequest, "GET", "/api/getComments/invalid", "user", nil)
+	c.expect(fiber.StatusBadRequest, "GET", "/api/getComments/"+postId+"?parent=invalid", "user", nil)
+	c.expect(fiber.StatusBadRequest, "GET", "/api/getComments/"+postId+"?limit=51", "user", nil)
+	c.expect(fiber.StatusBadRequest, "GET", "/api/getComments/"+postId+"?cursor=invalid", "user", nil)
+
+	// Only the author and the admins delete the comments, the replies are deleted with them
+	c.expect(fiber.StatusBadRequest, "POST", "/api/deleteComment", "other", map[string]string{"id": "invalid"})
+	c.expect(fiber.StatusNotFound, "POST", "/api/deleteComment", "user", map[string]string{"id": first})
+	c.expect(fiber.StatusOK, "POST", "/api/deleteComment", "other", map[string]string{"id": first})
+	c.expect(fiber.StatusNotFound, "POST", "/api/deleteComment", "other", map[string]string{"id": first})
+	c.expect(fiber.StatusOK, "POST", "/api/deleteComment", "admin", map[string]string{"id": second})
+
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getComments/"+postId+"?parent="+reply, "user", nil)), &page)
+	if len(page.Comments) != 0 {
+		t.Errorf("the replies %+v are left after the deletion", page.Comments)
+	}
+
+	if comments := c.expect(fiber.StatusOK, "GET", "/api/getComments/"+postId, "user", nil); comments != `{"comments":[],"nextCursor":""}` {
+		t.Errorf("the comments are %s after the deletion, expected none", comments)
+	}
+}
+
+func TestBoardsEndpoints(t *testing.T) {
+	c := newTestClient(t)
+
+	unknown := "00000000-0000-0000-0000-000000000000"
+
+	// The boards are managed by the admins
+	c.expect(fiber.StatusForbidden, "POST", "/api/addBoard", "user", map[string]any{"name": "News", "slug": "news"})
+	c.expect(fiber.StatusBadRequest, "POST", "/api/addBoard", "admin", map[string]any{"name": " ", "slug": "news"})
+	c.expect(fiber.StatusBadRequest, "POST", "/api/addBoard", "admin", map[string]any{"name": "News", "slug": "Not a slug"})
+
+	news := c.expect(fiber.StatusOK, "POST", "/api/addBoard", "admin", map[string]any{"name": "News", "slug": "news", "position": 1})
+	help := c.expect(fiber.StatusOK, "POST", "/api/addBoard", "admin", map[string]any{"name": "Help", "slug": "help", "description": "Questions"})
+	c.expect(fiber.StatusConflict, "POST", "/api/addBoard", "admin", map[string]any{"name": "More news", "slug": "news"})
+
+	var boards []utils.Board
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getBoards", "user", nil)), &boards)
+	if len(boards) != 2 || boards[0] != (utils.Board{ID: help, Name: "Help", Slug: "help", Description: "Questions"}) || boards[1].ID != news {
+		t.Errorf("unexpected boards %+v", boards)
+	}
+
+	c.expect(fiber.StatusForbidden, "POST", "/api/editBoard", "user", map[string]any{"boardId": news, "name": "News", "slug": "news"})
+	c.expect(fiber.StatusBadRequest, "POST", "/api/editBoard", "admin", map[string]any{"boardId": "invalid", "name": "News", "slug": "news"})
+	c.expect(fiber.StatusNotFound, "POST", "/api/editBoard", "admin", map[string]any{"boardId": unknown, "name": "News", "slug": "news"})
+	c.expect(fiber.StatusConflict, "POST", "/api/editBoard", "admin", map[string]any{"boardId": news, "name": "News", "slug": "help"})
+	c.expect(fiber.StatusOK, "POST", "/api/editBoard", "admin", map[string]any{"boardId": news, "name": "Announcements", "slug": "news"})
+
+	// The posts are sent to the boards that exist
+	c.expect(fiber.StatusBadRequest, "POST", "/api/sendPost", "user", map[string]string{"content": "<p>Nowhere</p>", "boardId": "invalid"})
+	c.expect(fiber.StatusBadRequest, "POST", "/api/sendPost", "user", map[string]string{"content": "<p>Nowhere</p>", "boardId": unknown})
+
+	first := c.expect(fiber.StatusOK, "POST", "/api/sendPost", "user", map[string]string{"content": "<p>The first news</p>", "boardId": news})
+	c.sendPost("user", "<p>Not on a board</p>")
+	second := c.expect(fiber.StatusOK, "POST", "/api/sendPost", "other", map[string]string{"content": "<p>The second news</p>", "boardId": news})
+
+	var page utils.FeedPage
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getBoardPosts/news?limit=1", "user", nil)), &page)
+	if len(page.Posts) != 1 || page.Posts[0].ID != second || page.Posts[0].BoardID != news || page.NextCursor == "" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getBoardPosts/news?limit=1&cursor="+page.NextCursor, "user", nil)), &page)
+	if len(page.Posts) != 1 || page.Posts[0].ID != first || page.NextCursor != "" {
+		t.Errorf("unexpected next page %+v", page)
+	}
+
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getBoardPosts/help", "user", nil)), &page)
+	if len(page.Posts) != 0 {
+		t.Errorf("the empty board has the posts %+v", page.Posts)
+	}
+
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getBoardPosts/unknown", "user", nil)), &page)
+	if len(page.Posts) != 0 {
+		t.Errorf("an unknown board has the posts %+v", page.Posts)
+	}
+
+	c.expect(fiber.StatusBadRequest, "GET", "/api/getBoardPosts/Not-A-Slug", "user", nil)
+	c.expect(fiber.StatusBadRequest, "GET", "/api/getBoardPosts/news?cursor=invalid", "user", nil)
+
+	// The posts of a deleted board stay in the global feed
+	c.expect(fiber.StatusForbidden, "POST", "/api/deleteBoard", "user", map[string]string{"id": news})
+	c.expect(fiber.StatusBadRequest, "POST", "/api/deleteBoard", "admin", map[string]string{"id": "invalid"})
+	c.expect(fiber.StatusOK, "POST", "/api/deleteBoard", "admin", map[string]string{"id": news})
+	c.expect(fiber.StatusNotFound, "POST", "/api/deleteBoard", "admin", map[string]string{"id": news})
+
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/getPosts", "user", nil)), &page)
+	if len(page.Posts) != 3 || page.Posts[0].BoardID != "" {
+		t.Errorf("unexpected posts %+v after the deletion of the board", page.Posts)
+	}
+}
+
+func TestSearchEndpoint(t *testing.T) {
+	c := newTestClient(t)
+
+	first := c.sendPost("user", "<p>How to bake bread</p>")
+	second := c.sendPost("other", "<p>Bake bread, then bake a cake</p>")
+	c.sendPost("user", "<p>Nothing about baking here</p>")
+
+	var response struct {
+		Results    []utils.SearchResult `json:"results"`
+		NextCursor string               `json:"nextCursor"`
+	}
+
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/search?q=bake%20bread&limit=1", "user", nil)), &response)
+	if len(response.Results) != 1 || response.Results[0].ID != second || response.NextCursor == "" ||
+		response.Results[0].Snippet != "<mark>Bake</mark> <mark>bread,</mark> then <mark>bake</mark> a cake" {
+		t.Fatalf("unexpected first page %+v", response)
+	}
+
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/search?q=bake%20bread&limit=1&cursor="+response.NextCursor, "user", nil)), &response)
+	if len(response.Results) != 1 || response.Results[0].ID != first {
+		t.Errorf("unexpected next page %+v", response)
+	}
+
+	json.Unmarshal([]byte(c.expect(fiber.StatusOK, "GET", "/api/search?q=bread&author=user", "user", nil)), &response)
+	if len(response.Results) != 1 || response.Results[0].ID != first || response.NextCursor != "" {
+		t.Errorf("unexpected results of the author %+v", response)
+	}
+
+	if results := c.expect(fiber.StatusOK, "GET", "/api/search?q=pizza", "user", nil); results != `{"nextCursor":"","results":[]}` {
+		t.Errorf("unexpected results %s, expected none", results)
+	}
+
+	c.expect(fiber.StatusUnauthorized, "GET", "/api/search?q=bread", "", nil)
+	c.expect(fiber.StatusBadRequest, "GET", "/api/search?q=%20", "user", nil)
+	c.expect(fiber.StatusBadRequest, "GET", "/api/search?q="+strings.Repeat("a", 257), "user", nil)
+	c.expect(fiber.StatusBadRequest, "GET", "/api/search?q=bread&limit=0", "user", nil)
+	c.expect(fiber.StatusBadRequest, "GET", "/api/search?q=bread&cursor=invalid", "user", nil)
+}
+
+// The endpoints that the other tests don't go through
+func TestEndpointStatuses(t *testing.T) {
+	c := newTestClient(t)
+
+	postId := c.sendPost("user", "<p>React to me</p>")
+	c.expect(fiber.StatusOK, "POST", "/api/react", "user", map[string]string{"postId": postId, "reaction": "👍"})
+	c.expect(fiber.StatusOK, "POST", "/api/react", "other", map[string]string{"postId": postId, "reaction": "👍"})
+
+	cases := []struct {
+		name     string
+		method   string
+		path     string
+		user     string
+		body     any
+		status   int
+		response string
+	}{
+		{"unreact logged out", "POST", "/api/unreact", "", map[string]string{"postId": postId, "reaction": "👍"}, fiber.StatusUnauthorized, ""},
+		{"unreact invalid post id", "POST", "/api/unreact", "user", map[string]string{"postId": "invalid", "reaction": "👍"}, fiber.StatusBadRequest, ""},
+		{"unreact invalid reaction", "POST", "/api/unreact", "user", map[string]string{"postId": postId, "reaction": "abc"}, fiber.StatusBadRequest, ""},
+		{"unreact unknown post", "POST", "/api/unreact", "user", map[string]string{"postId": "00000000-0000-0000-0000-000000000000", "reaction": "👍"}, fiber.StatusNotFound, ""},
+		{"unreact", "POST", "/api/unreact", "user", map[string]string{"postId": postId, "reaction": "👍"}, fiber.StatusOK, `{"👍":1}`},
+		{"unreact again", "POST", "/api/unreact", "user", map[string]string{"postId": postId, "reaction": "👍"}, fiber.StatusOK, `{"👍":1}`},
+		{"getPostReactions logged out", "GET", "/api/getPostReactions/" + postId, "", nil, fiber.StatusUnauthorized, ""},
+		{"getPostReactions invalid post id", "GET", "/api/getPostReactions/invalid", "user", nil, fiber.StatusBadRequest, ""},
+		{"getPostReactions", "GET", "/api/getPostReactions/" + postId, "other", nil, fiber.StatusOK, `{"counts":{"👍":1},"mine":["👍"]}`},
+		{"getPostReactions not reacted", "GET", "/api/getPostReactions/" + postId, "user", nil, fiber.StatusOK, `{"counts":{"👍":1},"mine":[]}`},
+		{"editPost logged out", "POST", "/api/editPost", "", map[string]string{"id": postId, "content": "<p>Edited post</p>"}, fiber.StatusUnauthorized, ""},
+		{"getBoardPosts logged out", "GET", "/api/getBoardPosts/news", "", nil, fiber.StatusUnauthorized, ""},
+		{"getComments logged out", "GET", "/api/getComments/" + postId, "", nil, fiber.StatusUnauthorized, ""},
+		{"presence logged out", "GET", "/api/presence", "", nil, fiber.StatusUnauthorized, ""},
+		{"uploadImage logged out", "POST", "/api/uploadImage", "", nil, fiber.StatusUnauthorized, ""},
+		{"uploadImage without a form", "POST", "/api/uploadImage", "user", map[string]string{"image": "data"}, fiber.StatusBadRequest, ""},
+	}
+
+	for _, test := range cases {
+		status, response := c.request(test.method, test.path, test.user, test.body)
+		if status != test.status || test.response != "" && response != test.response {
+			t.Errorf("%s: %s %s returned %d %q, expected %d %q", test.name, test.method, test.path, status, response, test.status, test.response)
+		}
+	}
+}
+
+// Store without the comments, boards and revisions, like the SQLite one
+type storeWithoutThreads struct {
+	utils.Store
+}
+
+func TestWithoutThreads(t *testing.T) {
+	c := newTestClient(t)
+	store = storeWithoutThreads{utils.NewMemoryStore()}
+
+	postId := c.sendPost("user", "<p>Without the comments</p>")
+
+	cases := []struct {
+		method string
+		path   string
+		body   any
+	}{
+		{"POST", "/api/editPost", map[string]string{"id": postId, "content": "<p>Edited post</p>"}},
+		{"GET", "/api/getPostRevisions/" + postId, nil},
+		{"POST", "/api/sendComment", map[string]string{"postId": postId, "content": "A comment"}},
+		{"GET", "/api/getComments/" + postId, nil},
+		{"GET", "/api/getBoards", nil},
+		{"GET", "/api/getBoardPosts/news", nil},
+		{"POST", "/api/sendPost", map[string]string{"content": "<p>On a board</p>", "boardId": "00000000-0000-0000-0000-000000000000"}},
+	}
+
+	for _, test := range cases {
+		if status, response := c.request(test.method, test.path, "user", test.body); status != fiber.StatusNotImplemented {
+			t.Errorf("%s %s returned %d %q, expected %d", test.method, test.path, status, response, fiber.StatusNotImplemented)
+		}
+	}
+}
+
+// Sends the request with the cookies of the passcode provider and returns the response
+func passcodeRequest(t *testing.T, app *fiber.App, method string, path string, cookie string, body string) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if cookie != "" {
+		req.Header.Set("Cookie", "Auth-Token="+cookie)
+	}
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	return resp
+}
+
+// check and logout exist only with the passcode provider, which logs in with the password
+func TestPasscodeLogin(t *testing.T) {
+	newTestStore()
+	app := newApp(providers.NewPasscodeProvider())
+
+	defer func(previous string) { password = previous }(password)
+	password = "secret"
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		cookie string
+		body   string
+		status int
+	}{
+		{"check without a body", "POST", "/api/check", "", "", fiber.StatusBadRequest},
+		{"check with a wrong password", "POST", "/api/check", "", `{"password":"wrong"}`, fiber.StatusUnauthorized},
+		{"check with a forged token", "POST", "/api/check", "forged", `{}`, fiber.StatusUnauthorized},
+		{"logout logged out", "GET", "/api/logout", "", "", fiber.StatusUnauthorized},
+		{"logout with a forged token", "GET", "/api/logout", "forged", "", fiber.StatusUnauthorized},
+	}
+
+	for _, test := range cases {
+		if resp := passcodeRequest(t, app, test.method, test.path, test.cookie, test.body); resp.StatusCode != test.status {
+			t.Errorf("%s: %s %s returned %d, expected %d", test.name, test.method, test.path, resp.StatusCode, test.status)
+		}
+	}
+
+	resp := passcodeRequest(t, app, "POST", "/api/check", "", `{"password":"secret"}`)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("logging in returned %d", resp.StatusCode)
+	}
+
+	var token string
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "Auth-Token" {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		t.Fatal("logging in doesn't set the token cookie")
+	}
+
+	for _, path := range []string{"/api/check", "/api/getPosts"} {
+		method := map[string]string{"/api/check": "POST", "/api/getPosts": "GET"}[path]
+		if resp := passcodeRequest(t, app, method, path, token, ""); resp.StatusCode != fiber.StatusOK {
+			t.Errorf("%s %s with the token returned %d", method, path, resp.StatusCode)
+		}
+	}
+
+	resp = passcodeRequest(t, app, "GET", "/api/logout", token, "")
+	if resp.StatusCode != fiber.StatusOK || !strings.Contains(resp.Header.Get("Set-Cookie"), "Auth-Token=;") {
+		t.Errorf("logout returned %d with the cookie %q, expected the cookie to be cleared", resp.StatusCode, resp.Header.Get("Set-Cookie"))
+	}
+}
+
+// Store whose database has no free connection
+type unavailableStore struct {
+	*utils.MemoryStore
+}
+
+func (unavailableStore) GetPosts(context.Context, utils.FeedQuery) (utils.FeedPage, error) {
+	return utils.FeedPage{}, utils.ErrDBUnavailable
+}
+
+func (unavailableStore) AddReaction(context.Context, string, string, string) (utils.ReactionCounts, error) {
+	return utils.ReactionCounts{}, context.DeadlineExceeded
+}
+
+func TestUnavailableDatabase(t *testing.T) {
+	c := newTestClient(t)
+	store = unavailableStore{utils.NewMemoryStore()}
+
+	code, _ := c.request("GET", "/api/getPosts", "user", nil)
+	if code != fiber.StatusServiceUnavailable {
+		t.Errorf("the feed returned %d without a connection, expected %d", code, fiber.StatusServiceUnavailable)
+	}
+
+	// The slow queries are stopped by the timeout
+	code, _ = c.request("POST", "/api/likePost", "user", map[string]string{"id": "00000000-0000-0000-0000-000000000000"})
+	if code != fiber.StatusServiceUnavailable {
+		t.Errorf("the like returned %d after the timeout, expected %d", code, fiber.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"threadhelpServer/utils"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// How often the server pings the client
+	wsPingInterval = 20 * time.Second
+	// How long the server waits for any message or pong from the client
+	wsPongWait  = 60 * time.Second
+	wsWriteWait = 10 * time.Second
+	// Max size of a command
+	wsMaxMessageSize = 4096
+	// Min interval between the typing events of a connection
+	wsTypingInterval = 2 * time.Second
+)
+
+// Origins besides the one of the app that can open WebSockets, e.g. http://localhost:5173
+var wsAllowedOrigins = envList("WS_ALLOWED_ORIGINS")
+
+const (
+	// Subprotocol the server speaks
+	wsProtocol = "threadhelp"
+	// Browsers can't set headers on WebSocket requests, so they offer
+	// the auth token as a subprotocol with this prefix
+	wsTokenProtocolPrefix = "auth-token."
+)
+
+var wsUpgrader = websocket.FastHTTPUpgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	Subprotocols:    []string{wsProtocol},
+	CheckOrigin:     checkWebSocketOrigin,
+}
+
+// Only the pages of the app and the allowed origins can open WebSockets, so other sites
+// can't act as the users whose cookies the browser sends. Clients without an Origin header
+// aren't browsers and pass the token themselves
+func checkWebSocketOrigin(ctx *fasthttp.RequestCtx) bool {
+	origin := string(ctx.Request.Header.Peek("Origin"))
+	if origin == "" || slices.Contains(wsAllowedOrigins, origin) {
+		return true
+	}
+
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(originURL.Host, string(ctx.Host()))
+}
+
+// Returns the auth token offered among the subprotocols of the WebSocket request
+func webSocketToken(c fiber.Ctx) string {
+	for _, protocol := range strings.Split(c.Get("Sec-WebSocket-Protocol"), ",") {
+		if token, ok := strings.CutPrefix(strings.TrimSpace(protocol), wsTokenProtocolPrefix); ok {
+			return token
+		}
+	}
+
+	return ""
+}
+
+// Command sent by a WebSocket client
+type wsCommand struct {
+	// like, unlike, react, unreact, subscribeBoards, subscribePost, unsubscribePost or typing
+	Type     string   `json:"type"`
+	PostID   string   `json:"postId,omitempty"`
+	Boards   []string `json:"boards,omitempty"`
+	Reaction string   `json:"reaction,omitempty"`
+}
+
+// Reply to a command that failed
+type wsError struct {
+	Type    string `json:"type"`
+	Command string `json:"command"`
+	Message string `json:"message"`
+}
+
+// Upgrades the request to a WebSocket that receives the same events as the SSE clients
+// and accepts the commands. The auth token is passed in the Auth-Token header or cookie,
+// or as a subprotocol by the browsers, it isn't accepted in the URL where it would be logged
+func webSocketHandler(loginProvider interface{ CheckLogin(*fiber.Ctx) bool }) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if c.Get("Auth-Token") == "" {
+			if token := webSocketToken(c); token != "" {
+				c.Request().Header.Set("Auth-Token", token)
+			}
+		}
+
+		if !loginProvider.CheckLogin(&c) {
+			return c.SendStatus(fiber.StatusUnauthorized)
+		}
+
+		if !websocket.FastHTTPIsWebSocketUpgrade(c.Context()) {
+			return c.SendStatus(fiber.StatusUpgradeRequired)
+		}
+
+		// The fiber context is released before the connection is served
+		email, _ := c.Locals("email").(string)
+		user := utils.ClientUser{
+			ID:          strings.Clone(c.Locals("uid").(string)),
+			DisplayName: strings.Clone(c.Locals("displayName").(string)),
+			Admin:       store.IsAdmin(c.Context(), email),
+		}
+
+		var boards []string
+		if boardsQuery := c.Query("boards", ""); boardsQuery != "" {
+			boards = strings.Split(strings.Clone(boardsQuery), ",")
+		}
+		lastEventId := strings.Clone(c.Query("lastEventId"))
+
+		err := wsUpgrader.Upgrade(c.Context(), func(conn *websocket.Conn) {
+			serveWebSocket(conn, user, boards, lastEventId)
+		})
+		if err != nil {
+			// The upgrader has already responded
+			logger.Println(err)
+		}
+
+		return nil
+	}
+}
+
+func serveWebSocket(conn *websocket.Conn, user utils.ClientUser, boards []string, lastEventId string) {
+	defer conn.Close()
+
+	sub, missed := sse.Subscribe(user, boards, lastEventId)
+	defer sub.Close()
+
+	// The commands that still run when the connection closes are stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Only this goroutine writes to the connection, the replies of the reader are passed here
+	replies := make(chan []byte, 16)
+	readerDone := make(chan struct{})
+	go readWebSocketCommands(ctx, conn, sub, user, replies, readerDone)
+
+	write := func(msg []byte) error {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		return conn.WriteMessage(websocket.TextMessage, msg)
+	}
+
+	for _, msg := range missed {
+		if write(msg) != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg := <-sub.Messages():
+			if write(msg) != nil {
+				return
+			}
+		case msg := <-replies:
+			if write(msg) != nil {
+				return
+			}
+		case <-ticker.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)) != nil {
+				return
+			}
+		case <-sub.Evicted():
+			write(sub.EvictedMessage())
+			return
+		case <-readerDone:
+			return
+		}
+	}
+}
+
+func readWebSocketCommands(ctx context.Context, conn *websocket.Conn, sub *utils.Subscription, user utils.ClientUser, replies chan<- []byte, done chan<- struct{}) {
+	defer close(done)
+
+	conn.SetReadLimit(wsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	lastTyping := time.Time{}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		var command wsCommand
+		if err := json.Unmarshal(data, &command); err != nil {
+			replyWebSocketError(replies, "", "invalid command")
+			continue
+		}
+
+		if command.Type == "typing" {
+			if time.Since(lastTyping) < wsTypingInterval {
+				continue
+			}
+			lastTyping = time.Now()
+		}
+
+		if message := runWebSocketCommand(ctx, command, sub, user); message != "" {
+			replyWebSocketError(replies, command.Type, message)
+		}
+	}
+}
+
+// Runs the command, returns the error message for the client if it fails
+func runWebSocketCommand(ctx context.Context, command wsCommand, sub *utils.Subscription, user utils.ClientUser) string {
+	if command.Type != "subscribeBoards" {
+		if _, err := uuid.Parse(command.PostID); err != nil {
+			return "invalid postId"
+		}
+	}
+
+	switch command.Type {
+	case "like", "unlike", "react", "unreact":
+		// The likes are the default reaction
+		reaction := command.Reaction
+		if command.Type == "like" || command.Type == "unlike" {
+			reaction = utils.DefaultReaction
+		} else if !validReaction(reaction) {
+			return "invalid reaction"
+		}
+
+		var counts utils.ReactionCounts
+		var err error
+		if command.Type == "like" || command.Type == "react" {
+			counts, err = store.AddReaction(ctx, user.ID, command.PostID, reaction)
+		} else {
+			counts, err = store.RemoveReaction(ctx, user.ID, command.PostID, reaction)
+		}
+
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "post not found"
+		}
+		if errors.Is(err, utils.ErrUnknownReaction) {
+			return "unknown reaction"
+		}
+		if err != nil {
+			logger.Println(err)
+			return "internal error"
+		}
+
+		sendReactionEvents(command.PostID, reaction, counts)
+	case "subscribeBoards":
+		if len(command.Boards) > 64 {
+			return "too many boards"
+		}
+
+		sub.SetBoards(command.Boards)
+	case "subscribePost":
+		sub.SubscribeTopic("post:" + command.PostID)
+	case "unsubscribePost":
+		sub.UnsubscribeTopic("post:" + command.PostID)
+	case "typing":
+		sse.SendToTopic("post:"+command.PostID, utils.TypingEvent(command.PostID, user.ID, user.DisplayName))
+	default:
+		return "unknown command"
+	}
+
+	return ""
+}
+
+// The reply is dropped if the client doesn't read them
+func replyWebSocketError(replies chan<- []byte, command string, message string) {
+	data, err := json.Marshal(wsError{
+		Type:    "error",
+		Command: command,
+		Message: message,
+	})
+	if err != nil {
+		return
+	}
+
+	select {
+	case replies <- data:
+	default:
+	}
+}
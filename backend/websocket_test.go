@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"threadhelpServer/utils"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v3"
+)
+
+func TestWebSocket(t *testing.T) {
+	newTestStore()
+
+	app := fiber.New()
+	app.Get("/ws", webSocketHandler(testLoginProvider{}))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(listener, fiber.ListenConfig{DisableStartupMessage: true})
+	defer app.Shutdown()
+
+	url := "ws://" + listener.Addr().String() + "/ws"
+
+	host := listener.Addr().String()
+	cases := []struct {
+		name      string
+		url       string
+		header    http.Header
+		protocols []string
+		ok        bool
+	}{
+		{"wrong token", url, nil, []string{wsProtocol, wsTokenProtocolPrefix + "nobody"}, false},
+		{"token in the query", url + "?token=user", nil, nil, false},
+		{"token in the header", url, http.Header{"Auth-Token": {"user"}}, nil, true},
+		{"other site", url, http.Header{"Origin": {"https://evil.example.com"}}, []string{wsProtocol, wsTokenProtocolPrefix + "user"}, false},
+		{"same origin", url, http.Header{"Origin": {"http://" + host}}, []string{wsProtocol, wsTokenProtocolPrefix + "user"}, true},
+	}
+
+	for _, test := range cases {
+		dialer := websocket.Dialer{Subprotocols: test.protocols}
+		conn, _, err := dialer.Dial(test.url, test.header)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: connecting returned %v, expected success %t", test.name, err, test.ok)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+
+	dialer := websocket.Dialer{Subprotocols: []string{wsProtocol, wsTokenProtocolPrefix + "user"}}
+	conn, resp, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The token isn't echoed back as the chosen subprotocol
+	if protocol := resp.Header.Get("Sec-WebSocket-Protocol"); protocol != wsProtocol {
+		t.Errorf("the subprotocol is %q, expected %q", protocol, wsProtocol)
+	}
+
+	postId := "6f2e3c1a-5b4d-4e8f-9a7b-1c2d3e4f5a6b"
+	if err := conn.WriteJSON(wsCommand{Type: "subscribePost", PostID: postId}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteJSON(wsCommand{Type: "unknown", PostID: postId}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The join of the user itself comes first
+	var reply wsError
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for reply.Type != "error" {
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reply.Command != "unknown" {
+		t.Errorf("unexpected reply %+v", reply)
+	}
+
+	// The connected user is online for the other users
+	c := testClient{t: t, app: newApp(testLoginProvider{})}
+	if presence := c.expect(fiber.StatusOK, "GET", "/api/presence", "other", nil); !strings.Contains(presence, `{"userId":"user","userDisplayName":"User"}`) {
+		t.Errorf("the presence is %s, expected the connected user", presence)
+	}
+
+	// The commands are handled in order, so the subscription is already there
+	if err := conn.WriteJSON(wsCommand{Type: "typing", PostID: postId}); err != nil {
+		t.Fatal(err)
+	}
+	sse.Send(utils.DeletePostEvent(postId))
+
+	received := map[string]json.RawMessage{}
+	for len(received) < 2 {
+		var event struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatal(err)
+		}
+		received[event.Type] = event.Payload
+	}
+
+	if payload := string(received[utils.EventTyping]); !strings.Contains(payload, `"userDisplayName":"User"`) {
+		t.Errorf("unexpected typing payload %s", payload)
+	}
+	if payload := string(received[utils.EventDeletePost]); payload != `{"postId":"`+postId+`"}` {
+		t.Errorf("unexpected delPost payload %s", payload)
+	}
+}
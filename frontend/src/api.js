@@ -39,3 +39,24 @@ export async function APIPostRequest(endpoint, data) {
 
 	return res
 }
+
+export async function APIUploadRequest(endpoint, field, file) {
+	let authToken = (auth === null || auth.currentUser === null) ? null : await auth.currentUser.getIdToken();
+
+	let form = new FormData();
+	form.append(field, file);
+
+	let res = await fetch(connectPath(endpoint), {
+		method: "POST",
+		headers: {
+			"Auth-Token": authToken
+		},
+		body: form
+	})
+
+	if (res.status === 403 || res.status === 401) {
+		await makeSignout()
+	}
+
+	return res
+}
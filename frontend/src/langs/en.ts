@@ -10,7 +10,8 @@ export default () => {
 		"post": "Send post",
 		"postPlaceholder": "Enter the text of the post",
 		"emptyPostError": "The text of the post is empty",
-		"tooLargePostError": "The post is too large (> 20 Mb)",
+		"tooLargePostError": "The post is too large (> 256 Kb)",
+		"imageUploadError": "Failed to upload the image",
 		"postIsSendingError": "Wait until the previous post is sent",
 		"postRequestError": "The post was not sent due to an unknown error",
 		"postIsDeletingError": "Wait until the previous post is deleted",
@@ -10,7 +10,8 @@ export default () => {
 		"post": "Відправити",
 		"postPlaceholder": "Введіть текст посту",
 		"emptyPostError": "Текст посту порожній",
-		"tooLargePostError": "Пост занадто великий (> 20 Мб)",
+		"tooLargePostError": "Пост занадто великий (> 256 Кб)",
+		"imageUploadError": "Не вдалося завантажити зображення",
 		"postIsSendingError": "Зачекайте поки минулий пост відправиться",
 		"postRequestError": "Пост не було відправлено через невідому помилку",
 		"postIsDeletingError": "Зачекайте поки минулий пост видалиться",